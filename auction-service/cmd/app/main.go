@@ -4,8 +4,10 @@ import (
 	"auction-service/internal/config"
 	"auction-service/internal/kafka"
 	"auction-service/internal/repository"
+	"auction-service/internal/scheduler"
 	"auction-service/internal/services"
 	"auction-service/internal/transport"
+	"context"
 	"log"
 	"time"
 
@@ -18,7 +20,7 @@ func main() {
 
 	kafkaProducer, err := kafka.NewProducer()
 	if err != nil {
-		log.Printf("WARNING: Failed to initialize Kafka producer: %v. Continuing without Kafka.", err)
+		log.Printf("WARNING: Failed to initialize Kafka producer: %v. Events stay in the outbox until Kafka is reachable.", err)
 		kafkaProducer = nil
 	} else {
 		defer kafkaProducer.Close()
@@ -26,12 +28,22 @@ func main() {
 
 	lotRepository := repository.NewLotRepository(db)
 	bidRepository := repository.NewBidRepository(db)
-	lotService := services.NewLotService(lotRepository, bidRepository, kafkaProducer)
-	lotHandler := transport.NewLotHandler(lotService)
-	bidService := services.NewBidService(bidRepository, lotRepository, kafkaProducer)
+	proxyBidRepository := repository.NewProxyBidRepository(db)
+	outboxRepository := repository.NewOutboxRepository(db)
+	bidSagaRepository := repository.NewBidSagaRepository(db)
+	settlementRepository := repository.NewLotSettlementRepository(db)
+	lotScheduler := scheduler.NewLotScheduler()
+	lotService := services.NewLotService(lotRepository, bidRepository, proxyBidRepository, outboxRepository, settlementRepository, db, lotScheduler)
+	lotHandler := transport.NewLotHandler(lotService, services.NewStaticFXRates(config.LoadFXRates()))
+	bidService := services.NewBidService(bidRepository, lotRepository, proxyBidRepository, outboxRepository, bidSagaRepository, db, config.LoadSoftCloseConfig(), lotScheduler)
 	bidHandler := transport.NewBidHandler(bidService)
 
-	go startAuctionWorker(lotService)
+	if err := lotService.ScheduleOpenLots(); err != nil {
+		log.Printf("WARNING: failed to load open lots into scheduler: %v", err)
+	}
+	go lotScheduler.Run(context.Background(), lotService.CompleteLotIfDue)
+	go kafka.RunOutboxRelay(context.Background(), outboxRepository, kafkaProducer)
+	go startAuctionWorker(lotService, bidService)
 
 	tutu := server.Group("/api")
 
@@ -42,7 +54,10 @@ func main() {
 	tutu.POST("/lots/:id/publish", lotHandler.PublishLot)
 	tutu.POST("/lots/complete-expired", lotHandler.CompleteExpired)
 	tutu.POST("/lots/:id/force-complete", lotHandler.ForceComplete)
-	tutu.POST("/lots/:id/bids", bidHandler.CreateBid)
+	tutu.POST("/lots/:id/buy-now", transport.RequireVerifiedEmail(), lotHandler.BuyNow)
+	tutu.POST("/lots/:id/accept", transport.RequireVerifiedEmail(), lotHandler.AcceptDutchPrice)
+	tutu.POST("/lots/:id/cancel", lotHandler.CancelLot)
+	tutu.POST("/lots/:id/bids", transport.RequireVerifiedEmail(), bidHandler.CreateBid)
 	tutu.GET("/lots/:id/bids", bidHandler.GetAllBids)
 	tutu.GET("/users/:id/lots", lotHandler.GetAllLotsByUser)
 	tutu.GET("/users/:id/bids", bidHandler.GetAllBidsByUser)
@@ -50,14 +65,42 @@ func main() {
 	server.Run(":8081")
 }
 
-func startAuctionWorker(lotService services.LotService) {
-	interval := 5 * time.Minute
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+func startAuctionWorker(lotService services.LotService, bidService services.BidService) {
+	// Lots are closed by the scheduler; this resync only picks up lots
+	// published or extended on other replicas.
+	resyncTicker := time.NewTicker(time.Minute)
+	defer resyncTicker.Stop()
+
+	dutchTicker := time.NewTicker(10 * time.Second)
+	defer dutchTicker.Stop()
+
+	startTicker := time.NewTicker(time.Second)
+	defer startTicker.Stop()
+
+	sagaTicker := time.NewTicker(15 * time.Second)
+	defer sagaTicker.Stop()
 
-	for range ticker.C {
-		if err := lotService.CompleteExpiredLots(); err != nil {
-			continue
+	for {
+		select {
+		case <-resyncTicker.C:
+			if err := lotService.ScheduleOpenLots(); err != nil {
+				log.Printf("WARNING: failed to resync lot scheduler: %v", err)
+			}
+		case <-startTicker.C:
+			if err := lotService.StartScheduledLots(); err != nil {
+				log.Printf("WARNING: failed to start scheduled lots: %v", err)
+			}
+		case <-dutchTicker.C:
+			if err := lotService.TickDutchLots(); err != nil {
+				log.Printf("WARNING: failed to tick dutch lots: %v", err)
+			}
+		case <-sagaTicker.C:
+			if err := bidService.RecoverBidSagas(); err != nil {
+				log.Printf("WARNING: failed to recover bid sagas: %v", err)
+			}
+			if err := lotService.RetryLotSettlements(); err != nil {
+				log.Printf("WARNING: failed to retry lot settlements: %v", err)
+			}
 		}
 	}
 }
@@ -15,12 +15,12 @@ func InitDatabase() *gorm.DB {
 		dsl = "host=localhost user=postgres password=postgres dbname=postgres port=5432 sslmode=disable"
 	}
 
-	db, err := gorm.Open(postgres.Open(dsl), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsl), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	db.AutoMigrate(&models.LotModel{}, &models.Bid{})
+	db.AutoMigrate(&models.LotModel{}, &models.Bid{}, &models.ProxyBid{}, &models.OutboxEvent{}, &models.BidSaga{}, &models.LotSettlement{})
 
 	return db
 }
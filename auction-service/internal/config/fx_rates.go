@@ -0,0 +1,35 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"auction-service/internal/models"
+)
+
+// LoadFXRates reads FX_RATES, a comma separated list such as
+// "USD=92.5,EUR=100.1" giving the value of one unit of each currency in
+// models.DefaultCurrency, which always has rate 1.
+func LoadFXRates() map[string]float64 {
+	rates := map[string]float64{models.DefaultCurrency: 1}
+	value := os.Getenv("FX_RATES")
+	if value == "" {
+		return rates
+	}
+	for _, pair := range strings.Split(value, ",") {
+		currency, rateStr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Printf("WARNING: invalid FX_RATES entry %q, skipping", pair)
+			continue
+		}
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 {
+			log.Printf("WARNING: invalid FX_RATES rate %q for %s, skipping", rateStr, currency)
+			continue
+		}
+		rates[strings.ToUpper(currency)] = rate
+	}
+	return rates
+}
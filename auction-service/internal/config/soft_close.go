@@ -0,0 +1,34 @@
+package config
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+type SoftCloseConfig struct {
+	Window       time.Duration
+	Extension    time.Duration
+	MaxExtension time.Duration
+}
+
+func LoadSoftCloseConfig() SoftCloseConfig {
+	return SoftCloseConfig{
+		Window:       durationFromEnv("SOFT_CLOSE_WINDOW", 2*time.Minute),
+		Extension:    durationFromEnv("SOFT_CLOSE_EXTENSION", 2*time.Minute),
+		MaxExtension: durationFromEnv("SOFT_CLOSE_MAX_EXTENSION", 30*time.Minute),
+	}
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("WARNING: invalid %s=%q, using %s", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
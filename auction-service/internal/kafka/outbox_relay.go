@@ -0,0 +1,51 @@
+package kafka
+
+import (
+	"auction-service/internal/models"
+	"auction-service/internal/repository"
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	relayInterval  = time.Second
+	relayBatchSize = 100
+)
+
+// RunOutboxRelay publishes pending outbox events to Kafka until ctx is done.
+// If the producer is nil it keeps trying to connect, so events written while
+// Kafka was unavailable are delivered once it comes back (at least once).
+func RunOutboxRelay(ctx context.Context, outbox repository.OutboxRepository, producer *Producer) {
+	ticker := time.NewTicker(relayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if producer == nil {
+			p, err := NewProducer()
+			if err != nil {
+				continue
+			}
+			producer = p
+		}
+
+		for {
+			sent, err := outbox.ProcessPending(relayBatchSize, func(event *models.OutboxEvent) error {
+				return producer.SendRaw(event.Topic, event.Key, event.Payload)
+			})
+			if err != nil {
+				log.Printf("WARNING: outbox relay failed: %v", err)
+				break
+			}
+			if sent < relayBatchSize {
+				break
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/IBM/sarama"
 )
@@ -16,10 +17,33 @@ type BidPlacedEvent struct {
 }
 
 type LotCompletedEvent struct {
+	LotID       uint64   `json:"lot_id"`
+	Winner      uint64   `json:"winner"`
+	FinalPrice  int64    `json:"final_price"`
+	LoserIDs    []uint64 `json:"loser_ids"`
+	Outcome     string   `json:"outcome"`
+	SellerID    uint64   `json:"seller_id"`
+	TopBidderID uint64   `json:"top_bidder_id"`
+}
+
+type LotStartedEvent struct {
+	LotID     uint64    `json:"lot_id"`
+	SellerID  uint64    `json:"seller_id"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+}
+
+type LotCanceledEvent struct {
 	LotID      uint64   `json:"lot_id"`
-	Winner     uint64   `json:"winner"`
-	FinalPrice int64    `json:"final_price"`
-	LoserIDs   []uint64 `json:"loser_ids"`
+	SellerID   uint64   `json:"seller_id"`
+	CanceledBy uint64   `json:"canceled_by"`
+	BidderIDs  []uint64 `json:"bidder_ids"`
+}
+
+type LotExtendedEvent struct {
+	LotID           uint64    `json:"lot_id"`
+	PreviousEndDate time.Time `json:"previous_end_date"`
+	NewEndDate      time.Time `json:"new_end_date"`
 }
 
 type Producer struct {
@@ -59,10 +83,18 @@ func (p *Producer) SendMessage(topic string, key string, value interface{}) erro
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
+	return p.SendRaw(topic, key, jsonValue)
+}
+
+func (p *Producer) SendRaw(topic string, key string, value []byte) error {
+	if p == nil || p.producer == nil {
+		return fmt.Errorf("producer is not initialized")
+	}
+
 	msg := &sarama.ProducerMessage{
 		Topic: topic,
 		Key:   sarama.StringEncoder(key),
-		Value: sarama.ByteEncoder(jsonValue),
+		Value: sarama.ByteEncoder(value),
 	}
 
 	partition, offset, err := p.producer.SendMessage(msg)
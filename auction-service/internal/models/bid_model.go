@@ -2,8 +2,27 @@ package models
 
 type Bid struct {
 	Base
-	Amount     int64     `json:"amount" binding:"required,gte=1" gorm:"not null"`
-	UserID     uint      `json:"user_id" binding:"required" gorm:"not null"`
-	LotModelID uint      `json:"-" gorm:"not null"`
-	LotModel   *LotModel `json:"-" binding:"-" gorm:"foreignKey:LotModelID"`
+	Amount     int64  `json:"amount" binding:"required,gte=1" gorm:"not null"`
+	Currency   string `json:"currency" binding:"omitempty,iso4217" gorm:"size:3;not null;default:RUB"`
+	MaxAmount  int64  `json:"max_amount,omitempty" binding:"omitempty,gtefield=Amount" gorm:"-"`
+	IsProxy    bool   `json:"is_proxy" gorm:"not null;default:false"`
+	UserID     uint   `json:"user_id" binding:"required" gorm:"not null;uniqueIndex:idx_bid_sealed_lot_user,where:sealed"`
+	LotModelID uint   `json:"-" gorm:"not null;uniqueIndex:idx_bid_sealed_lot_user"`
+	// Sealed bids are limited to one per user and lot by a partial unique
+	// index, so two concurrent first bids cannot both be stored.
+	Sealed   bool      `json:"-" binding:"-" gorm:"not null;default:false"`
+	LotModel *LotModel `json:"-" binding:"-" gorm:"foreignKey:LotModelID"`
+}
+
+// MaskSealedBids hides the amounts of other users' bids on a sealed lot that
+// is still running.
+func MaskSealedBids(lot *LotModel, bids []Bid, viewerID uint64) {
+	if !lot.IsSealed() || lot.Status == LotStatusCompleted {
+		return
+	}
+	for i := range bids {
+		if uint64(bids[i].UserID) != viewerID {
+			bids[i].Amount = 0
+		}
+	}
 }
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+type BidSagaStep string
+
+const (
+	BidSagaStepFreeze  BidSagaStep = "freeze"
+	BidSagaStepCommit  BidSagaStep = "commit"
+	BidSagaStepRelease BidSagaStep = "release"
+	BidSagaStepDone    BidSagaStep = "done"
+)
+
+type BidSagaStatus string
+
+const (
+	BidSagaStatusRunning      BidSagaStatus = "running"
+	BidSagaStatusCompleted    BidSagaStatus = "completed"
+	BidSagaStatusCompensating BidSagaStatus = "compensating"
+	BidSagaStatusCompensated  BidSagaStatus = "compensated"
+	BidSagaStatusFailed       BidSagaStatus = "failed"
+)
+
+// BidSaga tracks one bid placement across the wallet service and the local
+// database: freeze the bidder's funds, commit the bid and the lot change, then
+// release the funds of the replaced leader. Until the commit step succeeds a
+// failure is compensated by unfreezing; after it the release is retried.
+type BidSaga struct {
+	Base
+	LotModelID uint `json:"lot_id" gorm:"not null;index"`
+	BidID      uint `json:"bid_id" gorm:"default:0"`
+
+	// Currency is the lot's currency, in which FreezeAmount is frozen.
+	Currency      string `json:"currency" gorm:"size:3;not null;default:RUB"`
+	FreezeUserID  uint   `json:"freeze_user_id" gorm:"not null"`
+	FreezeAmount  int64  `json:"freeze_amount" gorm:"not null;default:0"`
+	ReleaseUserID uint   `json:"release_user_id" gorm:"default:0"`
+	ReleaseAmount int64  `json:"release_amount" gorm:"not null;default:0"`
+
+	Step          BidSagaStep   `json:"step" gorm:"type:varchar(16);not null"`
+	Status        BidSagaStatus `json:"status" gorm:"type:varchar(16);not null;index:idx_bid_saga_due,priority:1"`
+	NextAttemptAt time.Time     `json:"next_attempt_at" gorm:"not null;index:idx_bid_saga_due,priority:2"`
+	Attempts      int           `json:"attempts" gorm:"not null;default:0"`
+	LastError     string        `json:"last_error" gorm:"type:text"`
+}
@@ -1,38 +1,122 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type LotStatus string
 
 const (
 	LotStatusDraft     LotStatus = "draft"
+	LotStatusScheduled LotStatus = "scheduled"
 	LotStatusActive    LotStatus = "active"
 	LotStatusCompleted LotStatus = "completed"
+	LotStatusCanceled  LotStatus = "canceled"
+)
+
+// DefaultCurrency is the currency of lots created without one.
+const DefaultCurrency = "RUB"
+
+type LotType string
+
+const (
+	LotTypeEnglish           LotType = "english"
+	LotTypeDutch             LotType = "dutch"
+	LotTypeSealedFirstPrice  LotType = "sealed_first_price"
+	LotTypeSealedSecondPrice LotType = "sealed_second_price"
+)
+
+type LotOutcome string
+
+const (
+	LotOutcomeSold          LotOutcome = "sold"
+	LotOutcomeReserveNotMet LotOutcome = "reserve_not_met"
+	LotOutcomeNoBids        LotOutcome = "no_bids"
 )
 
 type LotModel struct {
 	Base
 	Title       string    `json:"title" binding:"required,min=1,max=255" gorm:"not null"`
 	Description string    `json:"description" binding:"required,min=1" gorm:"not null"`
+	Type        LotType   `json:"type" binding:"omitempty,oneof=english dutch sealed_first_price sealed_second_price" gorm:"type:varchar(32);not null;default:english"`
 	StartDate   time.Time `json:"start_date,omitempty" binding:"omitempty" gorm:"not null"`
 	EndDate     time.Time `json:"end_date,omitempty" binding:"omitempty" gorm:"not null"`
 
+	OriginalEndDate *time.Time `json:"original_end_date,omitempty" binding:"-"`
+
+	// Currency applies to every price of the lot and to its bids, which are
+	// frozen in the bidder's wallet of the same currency.
+	Currency string `json:"currency" binding:"omitempty,iso4217" gorm:"size:3;not null;default:RUB"`
+
 	StartPrice   int64 `json:"start_price" binding:"required,gte=1" gorm:"not null"`
 	CurrentPrice int64 `json:"current_price" gorm:"not null"`
 	MinStep      int64 `json:"min_step" binding:"required,gte=1" gorm:"not null"`
+	ReservePrice int64 `json:"reserve_price,omitempty" binding:"omitempty,gte=0" gorm:"not null;default:0"`
+	ReserveMet   bool  `json:"reserve_met" binding:"-" gorm:"-"`
+	BuyNowPrice  int64 `json:"buy_now_price,omitempty" binding:"omitempty,gte=0" gorm:"not null;default:0"`
+
+	// Dutch lots start at StartPrice and drop by MinStep every
+	// DropIntervalSeconds, never going below FloorPrice.
+	FloorPrice          int64 `json:"floor_price,omitempty" binding:"omitempty,gte=0" gorm:"not null;default:0"`
+	DropIntervalSeconds int64 `json:"drop_interval_seconds,omitempty" binding:"omitempty,gte=1" gorm:"not null;default:0"`
 
-	Status LotStatus `json:"status,omitempty" gorm:"not null"`
+	Status  LotStatus  `json:"status,omitempty" gorm:"not null"`
+	Outcome LotOutcome `json:"outcome,omitempty" binding:"-" gorm:"type:varchar(32)"`
 
 	SellerID uint64 `json:"seller_id" binding:"required" gorm:"not null;index"`
 	WinnerID uint64 `json:"winner_id" gorm:"default:0"`
 
 	CurrentBidID uint64 `json:"current_bid_id" gorm:"default:0"`
-	Bids         []Bid  `json:"bids" gorm:"foreignKey:LotModelID"`
+
+	// Version is bumped by every update; writers holding a stale copy of the
+	// lot fail instead of overwriting a concurrent bid.
+	Version uint64 `json:"version" binding:"-" gorm:"not null;default:0"`
+
+	Bids []Bid `json:"bids" gorm:"foreignKey:LotModelID"`
+
+	// DisplayPrice is the lot's prices converted to the currency the viewer
+	// asked for; it is not stored.
+	DisplayPrice *ConvertedPrice `json:"display_price,omitempty" binding:"-" gorm:"-"`
+}
+
+// ConvertedPrice is an approximation for display only; bids are always placed
+// in the lot's own currency.
+type ConvertedPrice struct {
+	Currency     string `json:"currency"`
+	StartPrice   int64  `json:"start_price"`
+	CurrentPrice int64  `json:"current_price"`
+	BuyNowPrice  int64  `json:"buy_now_price,omitempty"`
+}
+
+func (l *LotModel) IsSealed() bool {
+	return l.Type == LotTypeSealedFirstPrice || l.Type == LotTypeSealedSecondPrice
 }
+
+// IsReserveMet reports whether the current price satisfies the hidden reserve.
+// Lots without a reserve are always considered met.
+func (l *LotModel) IsReserveMet() bool {
+	if l.ReservePrice <= 0 {
+		return true
+	}
+	return l.CurrentBidID != 0 && l.CurrentPrice >= l.ReservePrice
+}
+
+func (l *LotModel) AfterFind(tx *gorm.DB) error {
+	l.ReserveMet = l.IsReserveMet()
+	return nil
+}
+
 type UpdateLotRequest struct {
-	Title       *string    `json:"title" binding:"omitempty,min=1,max=255"`
-	Description *string    `json:"description" binding:"omitempty,min=1"`
-	StartPrice  *int64     `json:"start_price" binding:"omitempty,gte=1"`
-	MinStep     *int64     `json:"min_step" binding:"omitempty,gte=1"`
-	EndDate     *time.Time `json:"end_date" binding:"omitempty"`
+	Title        *string    `json:"title" binding:"omitempty,min=1,max=255"`
+	Description  *string    `json:"description" binding:"omitempty,min=1"`
+	StartPrice   *int64     `json:"start_price" binding:"omitempty,gte=1"`
+	MinStep      *int64     `json:"min_step" binding:"omitempty,gte=1"`
+	ReservePrice *int64     `json:"reserve_price" binding:"omitempty,gte=0"`
+	BuyNowPrice  *int64     `json:"buy_now_price" binding:"omitempty,gte=0"`
+	EndDate      *time.Time `json:"end_date" binding:"omitempty"`
+
+	FloorPrice          *int64 `json:"floor_price" binding:"omitempty,gte=0"`
+	DropIntervalSeconds *int64 `json:"drop_interval_seconds" binding:"omitempty,gte=1"`
 }
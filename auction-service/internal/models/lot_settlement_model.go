@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+type LotSettlementAction string
+
+const (
+	LotSettlementPayout  LotSettlementAction = "payout"
+	LotSettlementRelease LotSettlementAction = "release"
+)
+
+type LotSettlementStatus string
+
+const (
+	LotSettlementStatusPending LotSettlementStatus = "pending"
+	LotSettlementStatusDone    LotSettlementStatus = "done"
+	LotSettlementStatusFailed  LotSettlementStatus = "failed"
+)
+
+// LotSettlement is a wallet call that settles a closed lot: the payout of the
+// winner's hold to the seller or the release of a bidder's hold. It is written
+// in the transaction that closes the lot and retried until the wallet accepts
+// it, so a wallet outage never strands the funds of a closed lot.
+type LotSettlement struct {
+	Base
+	LotModelID uint                `json:"lot_id" gorm:"not null;index"`
+	Action     LotSettlementAction `json:"action" gorm:"type:varchar(16);not null"`
+
+	// UserID is the user whose hold on the lot is paid out or released.
+	// Amount zero releases the whole hold.
+	UserID         uint   `json:"user_id" gorm:"not null"`
+	Amount         int64  `json:"amount" gorm:"not null;default:0"`
+	SellerID       uint64 `json:"seller_id" gorm:"default:0"`
+	Description    string `json:"description" gorm:"not null"`
+	IdempotencyKey string `json:"idempotency_key" gorm:"not null"`
+
+	Status        LotSettlementStatus `json:"status" gorm:"type:varchar(16);not null;index:idx_lot_settlement_due,priority:1"`
+	NextAttemptAt time.Time           `json:"next_attempt_at" gorm:"not null;index:idx_lot_settlement_due,priority:2"`
+	Attempts      int                 `json:"attempts" gorm:"not null;default:0"`
+	LastError     string              `json:"last_error" gorm:"type:text"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type OutboxStatus string
+
+const (
+	OutboxStatusPending OutboxStatus = "pending"
+	OutboxStatusSent    OutboxStatus = "sent"
+)
+
+// OutboxEvent is a Kafka message written in the same transaction as the
+// lot/bid change that produced it and published later by the outbox relay.
+type OutboxEvent struct {
+	Base
+	Topic         string       `json:"topic" gorm:"not null"`
+	Key           string       `json:"key" gorm:"not null;index:idx_outbox_key"`
+	Payload       []byte       `json:"payload" gorm:"type:bytea;not null"`
+	Status        OutboxStatus `json:"status" gorm:"type:varchar(16);not null;index:idx_outbox_pending,priority:1"`
+	NextAttemptAt time.Time    `json:"next_attempt_at" gorm:"not null;index:idx_outbox_pending,priority:2"`
+	Attempts      int          `json:"attempts" gorm:"not null;default:0"`
+	LastError     string       `json:"last_error" gorm:"type:text"`
+	SentAt        *time.Time   `json:"sent_at"`
+}
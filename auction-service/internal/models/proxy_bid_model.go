@@ -0,0 +1,12 @@
+package models
+
+// ProxyBid is a bidder's hidden maximum on a lot. It stops bidding once it is
+// outbid past MaxAmount, its wallet cannot cover a counter-bid or the lot is
+// closed.
+type ProxyBid struct {
+	Base
+	LotModelID uint  `json:"lot_id" gorm:"not null;uniqueIndex:idx_proxy_bid_lot_user"`
+	UserID     uint  `json:"user_id" gorm:"not null;uniqueIndex:idx_proxy_bid_lot_user"`
+	MaxAmount  int64 `json:"-" gorm:"not null"`
+	Active     bool  `json:"active" gorm:"not null;default:true"`
+}
@@ -2,16 +2,23 @@ package repository
 
 import (
 	"auction-service/internal/models"
+	"errors"
 
 	"gorm.io/gorm"
 )
 
+var ErrBidModified = errors.New("bid was modified concurrently")
+
 type BidRepository interface {
 	CreateBid(bidModel *models.Bid) error
+	UpdateBid(bidModel *models.Bid) error
+	ReplaceBidAmount(bidModel *models.Bid, previousAmount int64) error
 	GetBidByID(id uint64) (*models.Bid, error)
 	GetAllBids() ([]models.Bid, error)
 	GetAllBidsByUser(userID uint64) ([]models.Bid, error)
 	GetAllBidsByLot(lotID uint64) ([]models.Bid, error)
+	GetBidByLotAndUser(lotID uint64, userID uint64) (*models.Bid, error)
+	WithDB(db *gorm.DB) BidRepository
 }
 
 type bidRepository struct {
@@ -22,10 +29,30 @@ func NewBidRepository(db *gorm.DB) BidRepository {
 	return &bidRepository{db: db}
 }
 
+func (r *bidRepository) WithDB(db *gorm.DB) BidRepository {
+	return &bidRepository{db: db}
+}
+
 func (r *bidRepository) CreateBid(bidModel *models.Bid) error {
 	return r.db.Create(bidModel).Error
 }
 
+func (r *bidRepository) UpdateBid(bidModel *models.Bid) error {
+	return r.db.Save(bidModel).Error
+}
+
+// ReplaceBidAmount stores the bid's new amount only if it still has
+// previousAmount and returns ErrBidModified otherwise.
+func (r *bidRepository) ReplaceBidAmount(bidModel *models.Bid, previousAmount int64) error {
+	result := r.db.Model(bidModel).
+		Where("amount = ?", previousAmount).
+		Update("amount", bidModel.Amount)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrBidModified
+	}
+	return result.Error
+}
+
 func (r *bidRepository) GetBidByID(id uint64) (*models.Bid, error) {
 	var bidModel models.Bid
 	if err := r.db.First(&bidModel, uint(id)).Error; err != nil {
@@ -57,3 +84,11 @@ func (r *bidRepository) GetAllBidsByLot(lotID uint64) ([]models.Bid, error) {
 	}
 	return bidModels, nil
 }
+
+func (r *bidRepository) GetBidByLotAndUser(lotID uint64, userID uint64) (*models.Bid, error) {
+	var bidModel models.Bid
+	if err := r.db.Where("lot_model_id = ? AND user_id = ?", lotID, userID).First(&bidModel).Error; err != nil {
+		return nil, err
+	}
+	return &bidModel, nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"auction-service/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BidSagaRepository interface {
+	CreateSaga(saga *models.BidSaga) error
+	SaveSaga(saga *models.BidSaga) error
+	LockSaga(id uint) (*models.BidSaga, error)
+	ClaimStalled(limit int, staleBefore time.Time) ([]models.BidSaga, error)
+	WithDB(db *gorm.DB) BidSagaRepository
+}
+
+type bidSagaRepository struct {
+	db *gorm.DB
+}
+
+func NewBidSagaRepository(db *gorm.DB) BidSagaRepository {
+	return &bidSagaRepository{db: db}
+}
+
+func (r *bidSagaRepository) WithDB(db *gorm.DB) BidSagaRepository {
+	return &bidSagaRepository{db: db}
+}
+
+func (r *bidSagaRepository) CreateSaga(saga *models.BidSaga) error {
+	return r.db.Create(saga).Error
+}
+
+func (r *bidSagaRepository) SaveSaga(saga *models.BidSaga) error {
+	return r.db.Save(saga).Error
+}
+
+// ClaimStalled picks unfinished sagas that are due for a retry and were not
+// touched since staleBefore (so requests still in flight are left alone) and
+// touches them, so other replicas leave them alone while the caller drives
+// them outside any transaction. An uncommitted running saga is moved to
+// compensating right away: a request that wakes up late then sees it aborted
+// instead of committing a bid whose funds are being released.
+func (r *bidSagaRepository) ClaimStalled(limit int, staleBefore time.Time) ([]models.BidSaga, error) {
+	var sagas []models.BidSaga
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ? AND updated_at < ?",
+				[]models.BidSagaStatus{models.BidSagaStatusRunning, models.BidSagaStatusCompensating},
+				time.Now().UTC(), staleBefore).
+			Order("id").Limit(limit).Find(&sagas).Error; err != nil {
+			return err
+		}
+
+		for i := range sagas {
+			saga := &sagas[i]
+			if saga.Status == models.BidSagaStatusRunning && saga.Step != models.BidSagaStepRelease {
+				saga.Status = models.BidSagaStatusCompensating
+			}
+			if err := tx.Model(saga).Updates(map[string]any{"status": saga.Status}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sagas, nil
+}
+
+// LockSaga re-reads the saga with a row lock; it must run inside a
+// transaction, see WithDB.
+func (r *bidSagaRepository) LockSaga(id uint) (*models.BidSaga, error) {
+	var saga models.BidSaga
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&saga, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &saga, nil
+}
@@ -2,13 +2,19 @@ package repository
 
 import (
 	"auction-service/internal/models"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrLotVersionConflict = errors.New("lot was modified concurrently")
+
 type LotFilters struct {
 	Status     *models.LotStatus
+	Type       *models.LotType
+	Currency   *string
 	MinPrice   *int64
 	MaxPrice   *int64
 	MinEndDate *time.Time
@@ -19,9 +25,15 @@ type LotRepository interface {
 	CreateLot(lotModel *models.LotModel) error
 	UpdateLot(lotModel *models.LotModel) error
 	GetLotByID(id uint64) (*models.LotModel, error)
+	LockLot(id uint64) (*models.LotModel, error)
 	GetAllLots(offset int, limit int, filters *LotFilters) ([]models.LotModel, error)
 	GetAllLotsByUser(userID uint64) ([]models.LotModel, error)
 	GetExpiredActiveLots() ([]models.LotModel, error)
+	GetActiveLotsByType(lotType models.LotType) ([]models.LotModel, error)
+	GetDueScheduledLots() ([]models.LotModel, error)
+	GetOpenLots() ([]models.LotModel, error)
+	TryLockLot(lotID uint64, fn func() error) (bool, error)
+	WithDB(db *gorm.DB) LotRepository
 }
 
 type lotRepository struct {
@@ -32,12 +44,32 @@ func NewLotRepository(db *gorm.DB) LotRepository {
 	return &lotRepository{db: db}
 }
 
+func (r *lotRepository) WithDB(db *gorm.DB) LotRepository {
+	return &lotRepository{db: db}
+}
+
 func (r *lotRepository) CreateLot(lotModel *models.LotModel) error {
 	return r.db.Create(lotModel).Error
 }
 
+// UpdateLot writes the lot only if its version is unchanged since it was
+// read and returns ErrLotVersionConflict otherwise.
 func (r *lotRepository) UpdateLot(lotModel *models.LotModel) error {
-	return r.db.Save(lotModel).Error
+	version := lotModel.Version
+	lotModel.Version++
+	result := r.db.Model(lotModel).
+		Where("version = ?", version).
+		Select("*").
+		Omit(clause.Associations).
+		Updates(lotModel)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = ErrLotVersionConflict
+	}
+	if result.Error != nil {
+		lotModel.Version = version
+		return result.Error
+	}
+	return nil
 }
 
 func (r *lotRepository) GetLotByID(id uint64) (*models.LotModel, error) {
@@ -52,6 +84,16 @@ func (r *lotRepository) GetLotByID(id uint64) (*models.LotModel, error) {
 	return &lotModel, nil
 }
 
+// LockLot re-reads the lot, without its bids, with a row lock; it must run
+// inside a transaction, see WithDB.
+func (r *lotRepository) LockLot(id uint64) (*models.LotModel, error) {
+	var lotModel models.LotModel
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&lotModel, uint(id)).Error; err != nil {
+		return nil, err
+	}
+	return &lotModel, nil
+}
+
 func (r *lotRepository) GetAllLots(offset int, limit int, filters *LotFilters) ([]models.LotModel, error) {
 	var lots []models.LotModel
 	query := r.db
@@ -60,6 +102,12 @@ func (r *lotRepository) GetAllLots(offset int, limit int, filters *LotFilters) (
 		if filters.Status != nil {
 			query = query.Where("status = ?", *filters.Status)
 		}
+		if filters.Type != nil {
+			query = query.Where("type = ?", *filters.Type)
+		}
+		if filters.Currency != nil {
+			query = query.Where("currency = ?", *filters.Currency)
+		}
 		if filters.MinPrice != nil {
 			query = query.Where("current_price >= ?", *filters.MinPrice)
 		}
@@ -105,3 +153,45 @@ func (r *lotRepository) GetExpiredActiveLots() ([]models.LotModel, error) {
 	}
 	return lots, nil
 }
+
+func (r *lotRepository) GetActiveLotsByType(lotType models.LotType) ([]models.LotModel, error) {
+	var lots []models.LotModel
+	if err := r.db.Where("status = ? AND type = ?", models.LotStatusActive, lotType).Find(&lots).Error; err != nil {
+		return nil, err
+	}
+	return lots, nil
+}
+
+func (r *lotRepository) GetDueScheduledLots() ([]models.LotModel, error) {
+	var lots []models.LotModel
+	if err := r.db.Where("status = ? AND start_date <= ?", models.LotStatusScheduled, time.Now()).Find(&lots).Error; err != nil {
+		return nil, err
+	}
+	return lots, nil
+}
+
+func (r *lotRepository) GetOpenLots() ([]models.LotModel, error) {
+	var lots []models.LotModel
+	if err := r.db.Where("status IN ?", []models.LotStatus{models.LotStatusScheduled, models.LotStatusActive}).Find(&lots).Error; err != nil {
+		return nil, err
+	}
+	return lots, nil
+}
+
+// TryLockLot runs fn while holding a transaction-scoped advisory lock on the
+// lot, so replicas never complete it twice. It reports false without running
+// fn if another session holds the lock. The lock key is the full 64-bit lot
+// ID; Postgres keeps single-key locks apart from two-key ones.
+func (r *lotRepository) TryLockLot(lotID uint64, fn func() error) (bool, error) {
+	acquired := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", int64(lotID)).Scan(&acquired).Error; err != nil {
+			return err
+		}
+		if !acquired {
+			return nil
+		}
+		return fn()
+	})
+	return acquired, err
+}
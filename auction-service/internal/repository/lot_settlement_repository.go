@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"auction-service/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LotSettlementRepository interface {
+	CreateSettlements(settlements []models.LotSettlement) error
+	SaveSettlement(settlement *models.LotSettlement) error
+	ClaimDue(limit int, leaseUntil time.Time) ([]models.LotSettlement, error)
+	WithDB(db *gorm.DB) LotSettlementRepository
+}
+
+type lotSettlementRepository struct {
+	db *gorm.DB
+}
+
+func NewLotSettlementRepository(db *gorm.DB) LotSettlementRepository {
+	return &lotSettlementRepository{db: db}
+}
+
+func (r *lotSettlementRepository) WithDB(db *gorm.DB) LotSettlementRepository {
+	return &lotSettlementRepository{db: db}
+}
+
+func (r *lotSettlementRepository) CreateSettlements(settlements []models.LotSettlement) error {
+	if len(settlements) == 0 {
+		return nil
+	}
+	return r.db.Create(&settlements).Error
+}
+
+func (r *lotSettlementRepository) SaveSettlement(settlement *models.LotSettlement) error {
+	return r.db.Save(settlement).Error
+}
+
+// ClaimDue picks pending settlements that are due and moves their next
+// attempt to leaseUntil, so other replicas leave them alone while the caller
+// makes the wallet calls outside any transaction.
+func (r *lotSettlementRepository) ClaimDue(limit int, leaseUntil time.Time) ([]models.LotSettlement, error) {
+	var settlements []models.LotSettlement
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.LotSettlementStatusPending, time.Now().UTC()).
+			Order("id").Limit(limit).Find(&settlements).Error; err != nil {
+			return err
+		}
+
+		for i := range settlements {
+			settlements[i].NextAttemptAt = leaseUntil
+			if err := tx.Model(&settlements[i]).Updates(map[string]any{"next_attempt_at": leaseUntil}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return settlements, nil
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"auction-service/internal/models"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxOutboxBackoff = 5 * time.Minute
+
+type OutboxRepository interface {
+	Enqueue(topic string, key string, event any) error
+	ProcessPending(limit int, send func(event *models.OutboxEvent) error) (int, error)
+	WithDB(db *gorm.DB) OutboxRepository
+}
+
+type outboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) OutboxRepository {
+	return &outboxRepository{db: db}
+}
+
+func (r *outboxRepository) WithDB(db *gorm.DB) OutboxRepository {
+	return &outboxRepository{db: db}
+}
+
+func (r *outboxRepository) Enqueue(topic string, key string, event any) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", topic, err)
+	}
+	return r.db.Create(&models.OutboxEvent{
+		Topic:         topic,
+		Key:           key,
+		Payload:       payload,
+		Status:        models.OutboxStatusPending,
+		NextAttemptAt: time.Now().UTC(),
+	}).Error
+}
+
+// ProcessPending locks up to limit due events (skipping rows locked by other
+// replicas), hands them to send in insertion order and records the result,
+// returning how many events were sent. Events are ordered per key (the lot
+// ID): an event is not picked while an earlier event with the same key is
+// still pending, so a failed event holds back the later events of its lot
+// until it is delivered, while other lots go on.
+func (r *outboxRepository) ProcessPending(limit int, send func(event *models.OutboxEvent) error) (int, error) {
+	sent := 0
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var events []models.OutboxEvent
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.OutboxStatusPending, time.Now().UTC()).
+			Where("NOT EXISTS (SELECT 1 FROM outbox_events earlier WHERE earlier.key = outbox_events.key AND earlier.status = ? AND earlier.id < outbox_events.id AND earlier.deleted_at IS NULL)", models.OutboxStatusPending).
+			Order("id").Limit(limit).Find(&events).Error; err != nil {
+			return err
+		}
+
+		failedKeys := make(map[string]bool)
+		for i := range events {
+			event := &events[i]
+			if failedKeys[event.Key] {
+				continue
+			}
+			if err := send(event); err != nil {
+				failedKeys[event.Key] = true
+				event.Attempts++
+				event.LastError = err.Error()
+				event.NextAttemptAt = time.Now().UTC().Add(outboxBackoff(event.Attempts))
+				if err := tx.Save(event).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			now := time.Now().UTC()
+			event.Status = models.OutboxStatusSent
+			event.SentAt = &now
+			if err := tx.Save(event).Error; err != nil {
+				return err
+			}
+			sent++
+		}
+		return nil
+	})
+	return sent, err
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempts && backoff < maxOutboxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxOutboxBackoff)
+}
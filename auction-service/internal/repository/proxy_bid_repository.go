@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"auction-service/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ProxyBidRepository interface {
+	UpsertProxyBid(proxyBid *models.ProxyBid) error
+	GetProxyBid(lotID uint64, userID uint64) (*models.ProxyBid, error)
+	DeactivateProxyBid(lotID uint64, userID uint64) error
+	DeactivateLotProxyBids(lotID uint64) error
+	WithDB(db *gorm.DB) ProxyBidRepository
+}
+
+type proxyBidRepository struct {
+	db *gorm.DB
+}
+
+func NewProxyBidRepository(db *gorm.DB) ProxyBidRepository {
+	return &proxyBidRepository{db: db}
+}
+
+func (r *proxyBidRepository) WithDB(db *gorm.DB) ProxyBidRepository {
+	return &proxyBidRepository{db: db}
+}
+
+// UpsertProxyBid stores the user's maximum on the lot and reactivates a proxy
+// that was deactivated earlier.
+func (r *proxyBidRepository) UpsertProxyBid(proxyBid *models.ProxyBid) error {
+	proxyBid.Active = true
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "lot_model_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"max_amount", "active", "updated_at"}),
+	}).Create(proxyBid).Error
+}
+
+func (r *proxyBidRepository) GetProxyBid(lotID uint64, userID uint64) (*models.ProxyBid, error) {
+	var proxyBid models.ProxyBid
+	if err := r.db.Where("lot_model_id = ? AND user_id = ? AND active", lotID, userID).First(&proxyBid).Error; err != nil {
+		return nil, err
+	}
+	return &proxyBid, nil
+}
+
+func (r *proxyBidRepository) DeactivateProxyBid(lotID uint64, userID uint64) error {
+	return r.db.Model(&models.ProxyBid{}).
+		Where("lot_model_id = ? AND user_id = ? AND active", lotID, userID).
+		Update("active", false).Error
+}
+
+func (r *proxyBidRepository) DeactivateLotProxyBids(lotID uint64) error {
+	return r.db.Model(&models.ProxyBid{}).
+		Where("lot_model_id = ? AND active", lotID).
+		Update("active", false).Error
+}
@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// idleWait is how long the loop sleeps when nothing is scheduled; any
+// Schedule call wakes it up earlier.
+const idleWait = time.Hour
+
+type entry struct {
+	lotID uint64
+	at    time.Time
+	index int
+}
+
+type entryHeap []*entry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// LotScheduler keeps an in-memory min-heap of lot end dates and fires a
+// callback as soon as each one is reached.
+type LotScheduler struct {
+	mu      sync.Mutex
+	entries entryHeap
+	byLot   map[uint64]*entry
+	wake    chan struct{}
+}
+
+func NewLotScheduler() *LotScheduler {
+	return &LotScheduler{
+		byLot: make(map[uint64]*entry),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Schedule registers the lot end date or moves it if the lot is already known.
+func (s *LotScheduler) Schedule(lotID uint64, at time.Time) {
+	s.mu.Lock()
+	if e, ok := s.byLot[lotID]; ok {
+		e.at = at
+		heap.Fix(&s.entries, e.index)
+	} else {
+		e := &entry{lotID: lotID, at: at}
+		heap.Push(&s.entries, e)
+		s.byLot[lotID] = e
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *LotScheduler) Run(ctx context.Context, closeLot func(lotID uint64) error) {
+	timer := time.NewTimer(idleWait)
+	defer timer.Stop()
+
+	for {
+		timer.Reset(s.nextWait())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+			continue
+		case <-timer.C:
+			for _, lotID := range s.popDue(time.Now()) {
+				if err := closeLot(lotID); err != nil {
+					log.Printf("WARNING: failed to close lot %d: %v", lotID, err)
+				}
+			}
+		}
+	}
+}
+
+func (s *LotScheduler) nextWait() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) == 0 {
+		return idleWait
+	}
+	wait := time.Until(s.entries[0].at)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+func (s *LotScheduler) popDue(now time.Time) []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []uint64
+	for len(s.entries) > 0 && !s.entries[0].at.After(now) {
+		e := heap.Pop(&s.entries).(*entry)
+		delete(s.byLot, e.lotID)
+		due = append(due, e.lotID)
+	}
+	return due
+}
@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPopDueReturnsDueLotsInEndDateOrder(t *testing.T) {
+	s := NewLotScheduler()
+	now := time.Now()
+	s.Schedule(1, now.Add(-time.Second))
+	s.Schedule(2, now.Add(time.Hour))
+	s.Schedule(3, now.Add(-time.Minute))
+
+	due := s.popDue(now)
+	if len(due) != 2 || due[0] != 3 || due[1] != 1 {
+		t.Fatalf("due = %v, want [3 1]", due)
+	}
+	if due := s.popDue(now); len(due) != 0 {
+		t.Fatalf("due lots were returned twice: %v", due)
+	}
+}
+
+func TestScheduleMovesKnownLot(t *testing.T) {
+	s := NewLotScheduler()
+	now := time.Now()
+	s.Schedule(1, now.Add(-time.Second))
+	s.Schedule(1, now.Add(time.Hour))
+
+	if due := s.popDue(now); len(due) != 0 {
+		t.Fatalf("extended lot was due: %v", due)
+	}
+	if due := s.popDue(now.Add(2 * time.Hour)); len(due) != 1 || due[0] != 1 {
+		t.Fatalf("due = %v, want the lot once at its new end date", due)
+	}
+}
+
+func TestRunClosesLotAtItsEndDate(t *testing.T) {
+	s := NewLotScheduler()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	closed := make(chan time.Time, 1)
+	go s.Run(ctx, func(lotID uint64) error {
+		closed <- time.Now()
+		return nil
+	})
+
+	endDate := time.Now().Add(100 * time.Millisecond)
+	s.Schedule(1, endDate)
+
+	select {
+	case at := <-closed:
+		if at.Before(endDate) {
+			t.Fatalf("lot closed %s before its end date", endDate.Sub(at))
+		}
+		if late := at.Sub(endDate); late > time.Second {
+			t.Fatalf("lot closed %s after its end date", late)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("lot was not closed")
+	}
+}
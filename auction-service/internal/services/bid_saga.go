@@ -0,0 +1,204 @@
+package services
+
+import (
+	"auction-service/internal/models"
+	"auction-service/internal/repository"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// bidSagaStaleAfter must exceed the longest time a request can spend
+	// between saga steps (two wallet calls with walletClient's timeout), so
+	// recovery never races a request that is still running.
+	bidSagaStaleAfter  = time.Minute
+	bidSagaMaxBackoff  = 5 * time.Minute
+	bidSagaRecoveryCap = 100
+)
+
+var errBidSagaAborted = errors.New("bid placement was aborted by saga recovery")
+
+// startBidSaga persists the saga and freezes its funds. A rejected freeze
+// fails the saga; a freeze with unknown outcome is left for recovery to
+// compensate once any in-flight wallet request has settled.
+func (s *bidService) startBidSaga(saga *models.BidSaga) error {
+	saga.Step = models.BidSagaStepFreeze
+	saga.Status = models.BidSagaStatusRunning
+	saga.NextAttemptAt = time.Now().UTC()
+	if err := s.bidSagaRepository.CreateSaga(saga); err != nil {
+		return fmt.Errorf("failed to start bid saga: %w", err)
+	}
+
+	if saga.FreezeAmount > 0 {
+		if err := holdLotFunds(saga.FreezeUserID, saga.LotModelID, saga.FreezeAmount, saga.Currency, bidSagaDescription(saga), bidSagaKey(saga, "freeze")); err != nil {
+			saga.Attempts++
+			saga.LastError = err.Error()
+			if isWalletRejected(err) {
+				saga.Status = models.BidSagaStatusFailed
+			} else {
+				saga.Status = models.BidSagaStatusCompensating
+			}
+			s.saveBidSaga(saga)
+			return fmt.Errorf("failed to freeze wallet: %w", err)
+		}
+	}
+
+	saga.Step = models.BidSagaStepCommit
+	s.saveBidSaga(saga)
+	return nil
+}
+
+// finishBidSaga runs commit in the same transaction that moves the saga past
+// the commit step, then releases the replaced funds. Once committed the bid
+// stands: a failed release is retried by recovery instead of being reported.
+// commit gets the lot locked and checked to be open; a bid that does not
+// write the lot itself must save it, so the version moves past any
+// completion or cancellation that read the lot before.
+func (s *bidService) finishBidSaga(saga *models.BidSaga, commit func(tx *gorm.DB, lot *models.LotModel) error) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		sagaRepository := s.bidSagaRepository.WithDB(tx)
+		current, err := sagaRepository.LockSaga(saga.ID)
+		if err != nil {
+			return fmt.Errorf("failed to lock bid saga: %w", err)
+		}
+		if current.Status != models.BidSagaStatusRunning {
+			return errBidSagaAborted
+		}
+
+		lot, err := s.lotRepository.WithDB(tx).LockLot(uint64(saga.LotModelID))
+		if err != nil {
+			return fmt.Errorf("failed to lock lot: %w", err)
+		}
+		if lot.Status != models.LotStatusActive || !time.Now().Before(lot.EndDate) {
+			return fmt.Errorf("%w: lot is no longer open for bids", repository.ErrLotVersionConflict)
+		}
+
+		if err := commit(tx, lot); err != nil {
+			return err
+		}
+
+		if saga.ReleaseAmount > 0 {
+			saga.Step = models.BidSagaStepRelease
+		} else {
+			saga.Step = models.BidSagaStepDone
+			saga.Status = models.BidSagaStatusCompleted
+		}
+		return sagaRepository.SaveSaga(saga)
+	})
+	if err != nil {
+		if !errors.Is(err, errBidSagaAborted) {
+			s.compensateBidSaga(saga)
+			s.saveBidSaga(saga)
+		}
+		return err
+	}
+
+	if saga.Step == models.BidSagaStepRelease {
+		s.releaseBidSaga(saga)
+		s.saveBidSaga(saga)
+	}
+	return nil
+}
+
+// RecoverBidSagas drives sagas abandoned by a crash or a failed wallet call to
+// a final state: uncommitted ones are compensated, committed ones released.
+// The sagas are claimed in a short transaction and each one is saved on its
+// own after its wallet calls, so no row lock is held across HTTP requests.
+func (s *bidService) RecoverBidSagas() error {
+	staleBefore := time.Now().UTC().Add(-bidSagaStaleAfter)
+	for {
+		sagas, err := s.bidSagaRepository.ClaimStalled(bidSagaRecoveryCap, staleBefore)
+		if err != nil {
+			return err
+		}
+		for i := range sagas {
+			s.advanceBidSaga(&sagas[i])
+			s.saveBidSaga(&sagas[i])
+		}
+		if len(sagas) < bidSagaRecoveryCap {
+			return nil
+		}
+	}
+}
+
+func (s *bidService) advanceBidSaga(saga *models.BidSaga) {
+	if saga.Status == models.BidSagaStatusRunning && saga.Step == models.BidSagaStepRelease {
+		s.releaseBidSaga(saga)
+		return
+	}
+	s.compensateBidSaga(saga)
+}
+
+func (s *bidService) compensateBidSaga(saga *models.BidSaga) {
+	saga.Status = models.BidSagaStatusCompensating
+	if saga.FreezeAmount == 0 {
+		saga.Status = models.BidSagaStatusCompensated
+		return
+	}
+	if saga.Step == models.BidSagaStepFreeze {
+		// The freeze outcome is unknown. Repeating it under the same key
+		// either replays the original result or applies it now, so the
+		// unfreeze below never releases funds this saga did not freeze.
+		err := holdLotFunds(saga.FreezeUserID, saga.LotModelID, saga.FreezeAmount, saga.Currency, bidSagaDescription(saga), bidSagaKey(saga, "freeze"))
+		if isWalletRejected(err) {
+			saga.Status = models.BidSagaStatusCompensated
+			saga.LastError = err.Error()
+			return
+		}
+		if err != nil {
+			s.settleBidSagaStep(saga, err, models.BidSagaStatusCompensated)
+			return
+		}
+		saga.Step = models.BidSagaStepCommit
+	}
+	err := releaseLotHold(saga.FreezeUserID, saga.LotModelID, saga.FreezeAmount, bidSagaDescription(saga), bidSagaKey(saga, "compensate"))
+	s.settleBidSagaStep(saga, err, models.BidSagaStatusCompensated)
+}
+
+func (s *bidService) releaseBidSaga(saga *models.BidSaga) {
+	err := releaseLotHold(saga.ReleaseUserID, saga.LotModelID, saga.ReleaseAmount, bidSagaDescription(saga), bidSagaKey(saga, "release"))
+	if err == nil {
+		saga.Step = models.BidSagaStepDone
+	}
+	s.settleBidSagaStep(saga, err, models.BidSagaStatusCompleted)
+}
+
+// settleBidSagaStep records the outcome of a wallet call made by the saga. A
+// rejected call will not succeed on retry and needs manual attention; any
+// other error is retried with exponential backoff.
+func (s *bidService) settleBidSagaStep(saga *models.BidSaga, err error, final models.BidSagaStatus) {
+	saga.Attempts++
+	if err == nil {
+		saga.Status = final
+		saga.LastError = ""
+		return
+	}
+
+	saga.LastError = err.Error()
+	if isWalletRejected(err) {
+		log.Printf("WARNING: bid saga %d failed at step %s: %v", saga.ID, saga.Step, err)
+		saga.Status = models.BidSagaStatusFailed
+		return
+	}
+
+	backoff := time.Duration(1<<min(saga.Attempts, 10)) * time.Second
+	saga.NextAttemptAt = time.Now().UTC().Add(min(backoff, bidSagaMaxBackoff))
+}
+
+func bidSagaKey(saga *models.BidSaga, step string) string {
+	return fmt.Sprintf("bid-saga-%d-%s", saga.ID, step)
+}
+
+func bidSagaDescription(saga *models.BidSaga) string {
+	return fmt.Sprintf("Bid on lot #%d", saga.LotModelID)
+}
+
+func (s *bidService) saveBidSaga(saga *models.BidSaga) {
+	if err := s.bidSagaRepository.SaveSaga(saga); err != nil {
+		log.Printf("WARNING: failed to save bid saga %d: %v", saga.ID, err)
+	}
+}
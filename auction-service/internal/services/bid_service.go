@@ -1,114 +1,55 @@
 package services
 
 import (
+	"auction-service/internal/config"
 	"auction-service/internal/kafka"
 	"auction-service/internal/models"
 	"auction-service/internal/repository"
-	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"os"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// ErrBidConflict is returned to the loser of two bids racing on the same lot;
+// its funds are released and it may retry against the new price.
+var ErrBidConflict = errors.New("lot was updated by a concurrent bid, retry with the current price")
+
+var ErrBidCurrencyMismatch = errors.New("bid currency must match the lot currency")
+
 type BidService interface {
 	CreateBid(bidModel *models.Bid) error
 	GetBidByID(id uint64) (*models.Bid, error)
 	GetAllBids() ([]models.Bid, error)
 	GetAllBidsByUser(userID uint64) ([]models.Bid, error)
-	GetAllBidsByLot(lotID uint64) ([]models.Bid, error)
+	GetAllBidsByLot(lotID uint64, viewerID uint64) ([]models.Bid, error)
+	RecoverBidSagas() error
 }
 
 type bidService struct {
-	repository    repository.BidRepository
-	lotRepository repository.LotRepository
-	kafkaProducer *kafka.Producer
+	repository         repository.BidRepository
+	lotRepository      repository.LotRepository
+	proxyBidRepository repository.ProxyBidRepository
+	outboxRepository   repository.OutboxRepository
+	bidSagaRepository  repository.BidSagaRepository
+	db                 *gorm.DB
+	softClose          config.SoftCloseConfig
+	scheduler          LotCloseScheduler
 }
 
-func NewBidService(repository repository.BidRepository, lotRepository repository.LotRepository, kafkaProducer *kafka.Producer) BidService {
+func NewBidService(repository repository.BidRepository, lotRepository repository.LotRepository, proxyBidRepository repository.ProxyBidRepository, outboxRepository repository.OutboxRepository, bidSagaRepository repository.BidSagaRepository, db *gorm.DB, softClose config.SoftCloseConfig, scheduler LotCloseScheduler) BidService {
 	return &bidService{
-		repository:    repository,
-		lotRepository: lotRepository,
-		kafkaProducer: kafkaProducer,
-	}
-}
-
-func (s *bidService) freezeWallet(userID uint, amount int64) error {
-	base := os.Getenv("WALLET_SERVICE_URL")
-	if base == "" {
-		return errors.New("wallet service url is not configured")
-	}
-	url := fmt.Sprintf("%s/api/wallet/freeze", base)
-
-	jsonData, err := json.Marshal(map[string]interface{}{
-		"amount":      amount,
-		"description": "",
-	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+		repository:         repository,
+		lotRepository:      lotRepository,
+		proxyBidRepository: proxyBidRepository,
+		outboxRepository:   outboxRepository,
+		bidSagaRepository:  bidSagaRepository,
+		db:                 db,
+		softClose:          softClose,
+		scheduler:          scheduler,
 	}
-
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to build request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-User-Id", fmt.Sprintf("%d", userID))
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to call wallet service: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("wallet service returned status %d: %s", resp.StatusCode, string(body))
-	}
-
-	return nil
-}
-
-func (s *bidService) unfreezeWallet(userID uint, amount int64) error {
-	base := os.Getenv("WALLET_SERVICE_URL")
-	if base == "" {
-		return errors.New("wallet service url is not configured")
-	}
-	url := fmt.Sprintf("%s/api/wallet/unfreeze", base)
-
-	jsonData, err := json.Marshal(map[string]interface{}{
-		"amount":      amount,
-		"description": "",
-	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to build request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-User-Id", fmt.Sprintf("%d", userID))
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to call wallet service: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("wallet service returned status %d: %s", resp.StatusCode, string(body))
-	}
-
-	return nil
 }
 
 func (s *bidService) CreateBid(bidModel *models.Bid) error {
@@ -120,6 +61,14 @@ func (s *bidService) CreateBid(bidModel *models.Bid) error {
 	if lotModel.Status != models.LotStatusActive {
 		return errors.New("bids can only be placed on active lots")
 	}
+	if lotModel.Type == models.LotTypeDutch {
+		return errors.New("dutch lots do not accept bids, accept the current price instead")
+	}
+	if bidModel.Currency == "" {
+		bidModel.Currency = lotModel.Currency
+	} else if bidModel.Currency != lotModel.Currency {
+		return ErrBidCurrencyMismatch
+	}
 
 	now := time.Now().UTC()
 	bidModel.CreatedAt = now
@@ -134,11 +83,18 @@ func (s *bidService) CreateBid(bidModel *models.Bid) error {
 		return errors.New("bid cannot be created after the lot end date")
 	}
 
+	if lotModel.IsSealed() {
+		return s.placeSealedBid(lotModel, bidModel)
+	}
+
 	minRequiredAmount := lotModel.CurrentPrice + lotModel.MinStep
 	if bidModel.Amount < minRequiredAmount {
 		return fmt.Errorf("bid amount must be at least %d (current price %d + min step %d)",
 			minRequiredAmount, lotModel.CurrentPrice, lotModel.MinStep)
 	}
+	if bidModel.MaxAmount != 0 && bidModel.MaxAmount < bidModel.Amount {
+		return errors.New("max amount must not be less than bid amount")
+	}
 
 	previousBidID := lotModel.CurrentBidID
 	var previousBid *models.Bid
@@ -153,49 +109,330 @@ func (s *bidService) CreateBid(bidModel *models.Bid) error {
 		}
 	}
 
-	err = s.freezeWallet(bidModel.UserID, bidModel.Amount)
-	if err != nil {
-		return fmt.Errorf("failed to freeze wallet: %w", err)
+	if previousBid != nil && previousBid.UserID == bidModel.UserID && bidModel.MaxAmount != 0 {
+		return s.proxyBidRepository.UpsertProxyBid(&models.ProxyBid{
+			LotModelID: bidModel.LotModelID,
+			UserID:     bidModel.UserID,
+			MaxAmount:  bidModel.MaxAmount,
+		})
 	}
 
-	err = s.repository.CreateBid(bidModel)
-	if err != nil {
-		s.unfreezeWallet(bidModel.UserID, bidModel.Amount)
-		return fmt.Errorf("failed to create bid: %w", err)
+	ceiling := bidModel.Amount
+	if bidModel.MaxAmount > ceiling {
+		ceiling = bidModel.MaxAmount
+	}
+
+	var leaderProxy *models.ProxyBid
+	if previousBid != nil && previousBid.UserID != bidModel.UserID {
+		leaderProxy, err = s.proxyBidRepository.GetProxyBid(uint64(bidModel.LotModelID), uint64(previousBid.UserID))
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("WARNING: failed to get proxy bid of user %d on lot %d: %v", previousBid.UserID, bidModel.LotModelID, err)
+			}
+			leaderProxy = nil
+		}
 	}
 
-	if bidModel.ID == 0 {
-		s.unfreezeWallet(bidModel.UserID, bidModel.Amount)
-		return errors.New("failed to create bid: ID not set")
+	if leaderProxy != nil && leaderProxy.MaxAmount >= ceiling {
+		defended, err := s.defendWithProxy(lotModel, previousBid, leaderProxy, bidModel, ceiling)
+		if err != nil {
+			return err
+		}
+		if defended {
+			return nil
+		}
+		leaderProxy = nil
+	}
+
+	var autoBid *models.Bid
+	if leaderProxy != nil {
+		if leaderProxy.MaxAmount > previousBid.Amount {
+			autoBid = &models.Bid{
+				Amount:     leaderProxy.MaxAmount,
+				Currency:   lotModel.Currency,
+				IsProxy:    true,
+				UserID:     previousBid.UserID,
+				LotModelID: bidModel.LotModelID,
+			}
+		}
+		if amount := min(ceiling, leaderProxy.MaxAmount+lotModel.MinStep); amount > bidModel.Amount {
+			bidModel.Amount = amount
+		}
+	}
+
+	saga := &models.BidSaga{
+		LotModelID:   bidModel.LotModelID,
+		Currency:     lotModel.Currency,
+		FreezeUserID: bidModel.UserID,
+		FreezeAmount: bidModel.Amount,
+	}
+	if previousBid != nil {
+		saga.ReleaseUserID = previousBid.UserID
+		saga.ReleaseAmount = previousBid.Amount
+	}
+	if err := s.startBidSaga(saga); err != nil {
+		return err
 	}
 
 	lotModel.CurrentPrice = bidModel.Amount
-	lotModel.CurrentBidID = uint64(bidModel.ID)
-	err = s.lotRepository.UpdateLot(lotModel)
+	previousEndDate, extended := s.applySoftClose(lotModel, now)
+	err = s.finishBidSaga(saga, func(tx *gorm.DB, _ *models.LotModel) error {
+		bidRepository := s.repository.WithDB(tx)
+		if autoBid != nil {
+			if err := bidRepository.CreateBid(autoBid); err != nil {
+				return fmt.Errorf("failed to record proxy bid: %w", err)
+			}
+		}
+		if err := bidRepository.CreateBid(bidModel); err != nil {
+			return fmt.Errorf("failed to create bid: %w", err)
+		}
+		if bidModel.ID == 0 {
+			return errors.New("failed to create bid: ID not set")
+		}
+		saga.BidID = bidModel.ID
+
+		lotModel.CurrentBidID = uint64(bidModel.ID)
+		if err := s.lotRepository.WithDB(tx).UpdateLot(lotModel); err != nil {
+			if errors.Is(err, repository.ErrLotVersionConflict) {
+				return ErrBidConflict
+			}
+			return fmt.Errorf("failed to update lot: %w", err)
+		}
+		// The replaced leader's proxy has been outbid past its maximum.
+		proxyBidRepository := s.proxyBidRepository.WithDB(tx)
+		if leaderProxy != nil {
+			if err := proxyBidRepository.DeactivateProxyBid(uint64(lotModel.ID), uint64(previousBid.UserID)); err != nil {
+				return fmt.Errorf("failed to deactivate proxy bid: %w", err)
+			}
+		}
+		// The new leader's proxy only stands if its bid does.
+		if bidModel.MaxAmount > bidModel.Amount {
+			err := proxyBidRepository.UpsertProxyBid(&models.ProxyBid{
+				LotModelID: bidModel.LotModelID,
+				UserID:     bidModel.UserID,
+				MaxAmount:  bidModel.MaxAmount,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to save proxy bid: %w", err)
+			}
+		}
+
+		outbox := s.outboxRepository.WithDB(tx)
+		if previousBid != nil {
+			event := kafka.BidPlacedEvent{
+				LotID:            uint64(bidModel.LotModelID),
+				PreviousLeaderID: uint64(previousBid.UserID),
+				NewBidAmount:     bidModel.Amount,
+			}
+			if err := outbox.Enqueue("bid_placed", fmt.Sprintf("%d", bidModel.LotModelID), event); err != nil {
+				return err
+			}
+		}
+		if extended {
+			return s.enqueueLotExtended(outbox, lotModel, previousEndDate)
+		}
+		return nil
+	})
 	if err != nil {
-		s.unfreezeWallet(bidModel.UserID, bidModel.Amount)
-		return fmt.Errorf("failed to update lot: %w", err)
+		return err
+	}
+	if extended {
+		s.scheduler.Schedule(uint64(lotModel.ID), lotModel.EndDate)
 	}
 
-	if previousBid != nil {
-		err = s.unfreezeWallet(previousBid.UserID, previousBid.Amount)
-		if err != nil {
-			log.Printf("WARNING: failed to unfreeze wallet for previous bid %d: %v", previousBid.ID, err)
+	return nil
+}
+
+// placeSealedBid stores or replaces the user's single bid on a sealed lot.
+// Every sealed bid keeps its full amount frozen until the lot is completed.
+func (s *bidService) placeSealedBid(lotModel *models.LotModel, bidModel *models.Bid) error {
+	if bidModel.MaxAmount != 0 {
+		return errors.New("sealed lots do not support max amount")
+	}
+	if bidModel.Amount < lotModel.StartPrice {
+		return fmt.Errorf("bid amount must be at least %d", lotModel.StartPrice)
+	}
+
+	existing, err := s.repository.GetBidByLotAndUser(uint64(lotModel.ID), uint64(bidModel.UserID))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to get existing bid: %w", err)
+	}
+
+	if existing == nil {
+		saga := &models.BidSaga{
+			LotModelID:   bidModel.LotModelID,
+			Currency:     lotModel.Currency,
+			FreezeUserID: bidModel.UserID,
+			FreezeAmount: bidModel.Amount,
+		}
+		if err := s.startBidSaga(saga); err != nil {
+			return err
+		}
+		bidModel.Sealed = true
+		return s.finishBidSaga(saga, func(tx *gorm.DB, lot *models.LotModel) error {
+			if err := s.lotRepository.WithDB(tx).UpdateLot(lot); err != nil {
+				return fmt.Errorf("failed to update lot: %w", err)
+			}
+			if err := s.repository.WithDB(tx).CreateBid(bidModel); err != nil {
+				if errors.Is(err, gorm.ErrDuplicatedKey) {
+					return ErrBidConflict
+				}
+				return fmt.Errorf("failed to create bid: %w", err)
+			}
+			saga.BidID = bidModel.ID
+			return nil
+		})
+	}
+
+	// A raised bid freezes only the difference, a lowered one releases it
+	// after the replacement is stored.
+	saga := &models.BidSaga{
+		LotModelID:   bidModel.LotModelID,
+		Currency:     lotModel.Currency,
+		BidID:        existing.ID,
+		FreezeUserID: bidModel.UserID,
+	}
+	if delta := bidModel.Amount - existing.Amount; delta > 0 {
+		saga.FreezeAmount = delta
+	} else if delta < 0 {
+		saga.ReleaseUserID = bidModel.UserID
+		saga.ReleaseAmount = -delta
+	}
+	if err := s.startBidSaga(saga); err != nil {
+		return err
+	}
+
+	previousAmount := existing.Amount
+	existing.Amount = bidModel.Amount
+	err = s.finishBidSaga(saga, func(tx *gorm.DB, lot *models.LotModel) error {
+		if err := s.lotRepository.WithDB(tx).UpdateLot(lot); err != nil {
+			return fmt.Errorf("failed to update lot: %w", err)
+		}
+		if err := s.repository.WithDB(tx).ReplaceBidAmount(existing, previousAmount); err != nil {
+			if errors.Is(err, repository.ErrBidModified) {
+				return ErrBidConflict
+			}
+			return fmt.Errorf("failed to replace bid: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*bidModel = *existing
+	return nil
+}
+
+// defendWithProxy records the challenger's bid and lets the current leader's
+// proxy counter-bid one step above it. It reports false when the leader's
+// wallet cannot cover the counter-bid, in which case the proxy is dropped and
+// the challenger should win the lot as a regular bid.
+func (s *bidService) defendWithProxy(lotModel *models.LotModel, leaderBid *models.Bid, leaderProxy *models.ProxyBid, challenger *models.Bid, ceiling int64) (bool, error) {
+	counterAmount := min(leaderProxy.MaxAmount, ceiling+lotModel.MinStep)
+
+	saga := &models.BidSaga{
+		LotModelID:   challenger.LotModelID,
+		Currency:     lotModel.Currency,
+		FreezeUserID: leaderBid.UserID,
+		FreezeAmount: counterAmount - leaderBid.Amount,
+	}
+	if err := s.startBidSaga(saga); err != nil {
+		log.Printf("WARNING: failed to freeze wallet for proxy bid of user %d on lot %d: %v", leaderBid.UserID, lotModel.ID, err)
+		if err := s.proxyBidRepository.DeactivateProxyBid(uint64(lotModel.ID), uint64(leaderBid.UserID)); err != nil {
+			log.Printf("WARNING: failed to deactivate proxy bid of user %d on lot %d: %v", leaderBid.UserID, lotModel.ID, err)
 		}
+		return false, nil
 	}
 
-	if s.kafkaProducer != nil && previousBid != nil {
+	challenger.Amount = ceiling
+	counterBid := &models.Bid{
+		Amount:     counterAmount,
+		Currency:   lotModel.Currency,
+		IsProxy:    true,
+		UserID:     leaderBid.UserID,
+		LotModelID: challenger.LotModelID,
+	}
+	lotModel.CurrentPrice = counterBid.Amount
+	previousEndDate, extended := s.applySoftClose(lotModel, challenger.CreatedAt)
+
+	err := s.finishBidSaga(saga, func(tx *gorm.DB, _ *models.LotModel) error {
+		bidRepository := s.repository.WithDB(tx)
+		if err := bidRepository.CreateBid(challenger); err != nil {
+			return fmt.Errorf("failed to create bid: %w", err)
+		}
+		if err := bidRepository.CreateBid(counterBid); err != nil {
+			return fmt.Errorf("failed to create proxy bid: %w", err)
+		}
+		saga.BidID = counterBid.ID
+
+		lotModel.CurrentBidID = uint64(counterBid.ID)
+		if err := s.lotRepository.WithDB(tx).UpdateLot(lotModel); err != nil {
+			if errors.Is(err, repository.ErrLotVersionConflict) {
+				return ErrBidConflict
+			}
+			return fmt.Errorf("failed to update lot: %w", err)
+		}
+
+		outbox := s.outboxRepository.WithDB(tx)
 		event := kafka.BidPlacedEvent{
-			LotID:            uint64(bidModel.LotModelID),
-			PreviousLeaderID: uint64(previousBid.UserID),
-			NewBidAmount:     bidModel.Amount,
+			LotID:            uint64(challenger.LotModelID),
+			PreviousLeaderID: uint64(challenger.UserID),
+			NewBidAmount:     counterBid.Amount,
+		}
+		if err := outbox.Enqueue("bid_placed", fmt.Sprintf("%d", challenger.LotModelID), event); err != nil {
+			return err
 		}
-		if err := s.kafkaProducer.SendMessage("bid_placed", fmt.Sprintf("%d", bidModel.LotModelID), event); err != nil {
-			log.Printf("WARNING: failed to send bid_placed event to Kafka: %v", err)
+		if extended {
+			return s.enqueueLotExtended(outbox, lotModel, previousEndDate)
 		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if extended {
+		s.scheduler.Schedule(uint64(lotModel.ID), lotModel.EndDate)
 	}
 
-	return nil
+	return true, nil
+}
+
+// applySoftClose pushes the lot end date out when a bid lands inside the
+// soft close window, capped by the maximum total extension.
+func (s *bidService) applySoftClose(lotModel *models.LotModel, bidTime time.Time) (time.Time, bool) {
+	previousEndDate := lotModel.EndDate
+	if s.softClose.Window <= 0 || s.softClose.Extension <= 0 {
+		return previousEndDate, false
+	}
+	if lotModel.EndDate.Sub(bidTime) > s.softClose.Window {
+		return previousEndDate, false
+	}
+
+	originalEndDate := lotModel.EndDate
+	if lotModel.OriginalEndDate != nil {
+		originalEndDate = *lotModel.OriginalEndDate
+	}
+
+	newEndDate := bidTime.Add(s.softClose.Extension)
+	if limit := originalEndDate.Add(s.softClose.MaxExtension); newEndDate.After(limit) {
+		newEndDate = limit
+	}
+	if !newEndDate.After(lotModel.EndDate) {
+		return previousEndDate, false
+	}
+
+	lotModel.OriginalEndDate = &originalEndDate
+	lotModel.EndDate = newEndDate
+	return previousEndDate, true
+}
+
+func (s *bidService) enqueueLotExtended(outbox repository.OutboxRepository, lotModel *models.LotModel, previousEndDate time.Time) error {
+	event := kafka.LotExtendedEvent{
+		LotID:           uint64(lotModel.ID),
+		PreviousEndDate: previousEndDate,
+		NewEndDate:      lotModel.EndDate,
+	}
+	return outbox.Enqueue("lot_extended", fmt.Sprintf("%d", lotModel.ID), event)
 }
 
 func (s *bidService) GetBidByID(id uint64) (*models.Bid, error) {
@@ -210,6 +447,15 @@ func (s *bidService) GetAllBidsByUser(userID uint64) ([]models.Bid, error) {
 	return s.repository.GetAllBidsByUser(userID)
 }
 
-func (s *bidService) GetAllBidsByLot(lotID uint64) ([]models.Bid, error) {
-	return s.repository.GetAllBidsByLot(lotID)
+func (s *bidService) GetAllBidsByLot(lotID uint64, viewerID uint64) ([]models.Bid, error) {
+	bids, err := s.repository.GetAllBidsByLot(lotID)
+	if err != nil {
+		return nil, err
+	}
+	lotModel, err := s.lotRepository.GetLotByID(lotID)
+	if err != nil {
+		return nil, err
+	}
+	models.MaskSealedBids(lotModel, bids, viewerID)
+	return bids, nil
 }
@@ -0,0 +1,439 @@
+package services
+
+import (
+	"auction-service/internal/config"
+	"auction-service/internal/models"
+	"auction-service/internal/repository"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestCreateBidLosesToConcurrentBid(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+
+	var concurrentErr error
+	wallet.interleaveOnce(func() {
+		concurrentErr = svc.bids.CreateBid(&models.Bid{Amount: 300, UserID: 3, LotModelID: lot.ID})
+	})
+	err := svc.bids.CreateBid(&models.Bid{Amount: 200, UserID: 2, LotModelID: lot.ID})
+
+	if concurrentErr != nil {
+		t.Fatalf("concurrent bid: %v", concurrentErr)
+	}
+	if !errors.Is(err, ErrBidConflict) {
+		t.Fatalf("expected ErrBidConflict, got %v", err)
+	}
+	if got := reloadLot(t, db, lot.ID).CurrentPrice; got != 300 {
+		t.Fatalf("current price = %d, want 300", got)
+	}
+	if bids := lotBids(t, db, lot.ID); len(bids) != 1 || bids[0].UserID != 3 {
+		t.Fatalf("bids = %+v, want only the bid of user 3", bids)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 0 {
+		t.Fatalf("loser still has %d on hold", held)
+	}
+	if held := wallet.heldBy(3, lot.ID); held != 300 {
+		t.Fatalf("winner has %d on hold, want 300", held)
+	}
+}
+
+func TestSealedBidConcurrentFirstBidsOfOneUser(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{Type: models.LotTypeSealedFirstPrice, StartPrice: 100, MinStep: 10})
+
+	var concurrentErr error
+	wallet.interleaveOnce(func() {
+		concurrentErr = svc.bids.CreateBid(&models.Bid{Amount: 700, UserID: 2, LotModelID: lot.ID})
+	})
+	err := svc.bids.CreateBid(&models.Bid{Amount: 500, UserID: 2, LotModelID: lot.ID})
+
+	if concurrentErr != nil {
+		t.Fatalf("concurrent bid: %v", concurrentErr)
+	}
+	if !errors.Is(err, ErrBidConflict) {
+		t.Fatalf("expected ErrBidConflict, got %v", err)
+	}
+	bids := lotBids(t, db, lot.ID)
+	if len(bids) != 1 || bids[0].Amount != 700 {
+		t.Fatalf("bids = %+v, want a single bid of 700", bids)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 700 {
+		t.Fatalf("user has %d on hold, want 700", held)
+	}
+}
+
+func TestSealedBidConcurrentReplacements(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{Type: models.LotTypeSealedSecondPrice, StartPrice: 100, MinStep: 10})
+
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 500, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("first bid: %v", err)
+	}
+
+	var concurrentErr error
+	wallet.interleaveOnce(func() {
+		concurrentErr = svc.bids.CreateBid(&models.Bid{Amount: 400, UserID: 2, LotModelID: lot.ID})
+	})
+	err := svc.bids.CreateBid(&models.Bid{Amount: 800, UserID: 2, LotModelID: lot.ID})
+
+	if concurrentErr != nil {
+		t.Fatalf("concurrent replacement: %v", concurrentErr)
+	}
+	if !errors.Is(err, ErrBidConflict) {
+		t.Fatalf("expected ErrBidConflict, got %v", err)
+	}
+	bids := lotBids(t, db, lot.ID)
+	if len(bids) != 1 || bids[0].Amount != 400 {
+		t.Fatalf("bids = %+v, want a single bid of 400", bids)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 400 {
+		t.Fatalf("user has %d on hold, want 400", held)
+	}
+}
+
+func TestSealedBidsOfDifferentUsersAreAllKept(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{Type: models.LotTypeSealedFirstPrice, StartPrice: 100, MinStep: 10})
+
+	for _, userID := range []uint{2, 3} {
+		if err := svc.bids.CreateBid(&models.Bid{Amount: 200, UserID: userID, LotModelID: lot.ID}); err != nil {
+			t.Fatalf("bid of user %d: %v", userID, err)
+		}
+	}
+	if bids := lotBids(t, db, lot.ID); len(bids) != 2 {
+		t.Fatalf("got %d bids, want 2", len(bids))
+	}
+}
+
+func TestProxyBidDefendsLeader(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 150, MaxAmount: 400, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("proxy bid: %v", err)
+	}
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 200, UserID: 3, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("challenger bid: %v", err)
+	}
+
+	current := reloadLot(t, db, lot.ID)
+	if current.CurrentPrice != 210 {
+		t.Fatalf("current price = %d, want the counter-bid of 210", current.CurrentPrice)
+	}
+	bids := lotBids(t, db, lot.ID)
+	if leader := bids[len(bids)-1]; uint64(leader.ID) != current.CurrentBidID || leader.UserID != 2 || !leader.IsProxy {
+		t.Fatalf("leading bid = %+v, want the proxy bid of user 2", leader)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 210 {
+		t.Fatalf("leader has %d on hold, want 210", held)
+	}
+	if held := wallet.heldBy(3, lot.ID); held != 0 {
+		t.Fatalf("outbid challenger has %d on hold", held)
+	}
+}
+
+func TestProxyBidOutbidPastItsMaximum(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 150, MaxAmount: 300, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("first proxy bid: %v", err)
+	}
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 200, MaxAmount: 500, UserID: 3, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("second proxy bid: %v", err)
+	}
+
+	if got := reloadLot(t, db, lot.ID).CurrentPrice; got != 310 {
+		t.Fatalf("current price = %d, want one step above the outbid maximum", got)
+	}
+	if proxyBid := activeProxyBid(t, db, lot.ID, 2); proxyBid != nil {
+		t.Fatalf("outbid proxy is still active: %+v", proxyBid)
+	}
+	if proxyBid := activeProxyBid(t, db, lot.ID, 3); proxyBid == nil || proxyBid.MaxAmount != 500 {
+		t.Fatalf("leader proxy = %+v, want an active maximum of 500", proxyBid)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 0 {
+		t.Fatalf("outbid user still has %d on hold", held)
+	}
+	if held := wallet.heldBy(3, lot.ID); held != 310 {
+		t.Fatalf("leader has %d on hold, want 310", held)
+	}
+}
+
+func TestProxyBidNotSavedWhenFreezeFails(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	wallet.rejectHolds = map[uint]bool{2: true}
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 150, MaxAmount: 400, UserID: 2, LotModelID: lot.ID}); err == nil {
+		t.Fatal("expected the bid to fail")
+	}
+	if proxyBid := activeProxyBid(t, db, lot.ID, 2); proxyBid != nil {
+		t.Fatalf("proxy of a rejected bid is active: %+v", proxyBid)
+	}
+}
+
+func TestProxyBidNotSavedWhenBidLosesRace(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+
+	var concurrentErr error
+	wallet.interleaveOnce(func() {
+		concurrentErr = svc.bids.CreateBid(&models.Bid{Amount: 300, UserID: 3, LotModelID: lot.ID})
+	})
+	err := svc.bids.CreateBid(&models.Bid{Amount: 200, MaxAmount: 600, UserID: 2, LotModelID: lot.ID})
+
+	if concurrentErr != nil {
+		t.Fatalf("concurrent bid: %v", concurrentErr)
+	}
+	if !errors.Is(err, ErrBidConflict) {
+		t.Fatalf("expected ErrBidConflict, got %v", err)
+	}
+	if proxyBid := activeProxyBid(t, db, lot.ID, 2); proxyBid != nil {
+		t.Fatalf("proxy of the losing bid is active: %+v", proxyBid)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 0 {
+		t.Fatalf("losing user still has %d on hold", held)
+	}
+}
+
+func newSoftCloseBidService(db *gorm.DB, scheduler LotCloseScheduler) BidService {
+	softClose := config.SoftCloseConfig{Window: 5 * time.Minute, Extension: 10 * time.Minute, MaxExtension: 15 * time.Minute}
+	return NewBidService(repository.NewBidRepository(db), repository.NewLotRepository(db), repository.NewProxyBidRepository(db),
+		repository.NewOutboxRepository(db), repository.NewBidSagaRepository(db), db, softClose, scheduler)
+}
+
+func endLotIn(t *testing.T, db *gorm.DB, lot *models.LotModel, d time.Duration) time.Time {
+	t.Helper()
+	endDate := time.Now().UTC().Add(d)
+	if err := db.Model(lot).Update("end_date", endDate).Error; err != nil {
+		t.Fatalf("move end date: %v", err)
+	}
+	return endDate
+}
+
+func TestSoftCloseExtendsLateBid(t *testing.T) {
+	db := newTestDB(t)
+	newFakeWallet(t)
+	scheduler := &recordingScheduler{}
+	bids := newSoftCloseBidService(db, scheduler)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+	originalEndDate := endLotIn(t, db, lot, time.Minute)
+
+	if err := bids.CreateBid(&models.Bid{Amount: 200, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("late bid: %v", err)
+	}
+
+	extended := reloadLot(t, db, lot.ID)
+	if extended.EndDate.Sub(time.Now()) < 9*time.Minute {
+		t.Fatalf("end date %s was not pushed out by the extension", extended.EndDate)
+	}
+	if extended.OriginalEndDate == nil || !extended.OriginalEndDate.Equal(originalEndDate) {
+		t.Fatalf("original end date = %v, want %s", extended.OriginalEndDate, originalEndDate)
+	}
+	if endDate, ok := scheduler.scheduled(lot.ID); !ok || !endDate.Equal(extended.EndDate) {
+		t.Fatalf("scheduled end date = %s, want %s", endDate, extended.EndDate)
+	}
+	if topics := outboxTopics(t, db, lot.ID); len(topics) != 1 || topics[0] != "lot_extended" {
+		t.Fatalf("outbox topics = %v, want a single lot_extended", topics)
+	}
+}
+
+func TestSoftCloseExtensionIsCapped(t *testing.T) {
+	db := newTestDB(t)
+	newFakeWallet(t)
+	bids := newSoftCloseBidService(db, &recordingScheduler{})
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+	// Extended twice already: 12 of the 15 allowed minutes are used up.
+	originalEndDate := time.Now().UTC().Add(-11 * time.Minute)
+	endLotIn(t, db, lot, time.Minute)
+	if err := db.Model(lot).Update("original_end_date", originalEndDate).Error; err != nil {
+		t.Fatalf("extend lot: %v", err)
+	}
+
+	if err := bids.CreateBid(&models.Bid{Amount: 200, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("late bid: %v", err)
+	}
+
+	limit := originalEndDate.Add(15 * time.Minute)
+	if got := reloadLot(t, db, lot.ID).EndDate; !got.Equal(limit) {
+		t.Fatalf("end date = %s, want the cap %s", got, limit)
+	}
+}
+
+func TestSoftCloseIgnoresEarlyBid(t *testing.T) {
+	db := newTestDB(t)
+	newFakeWallet(t)
+	scheduler := &recordingScheduler{}
+	bids := newSoftCloseBidService(db, scheduler)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+
+	if err := bids.CreateBid(&models.Bid{Amount: 200, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("bid: %v", err)
+	}
+
+	current := reloadLot(t, db, lot.ID)
+	if current.OriginalEndDate != nil || !current.EndDate.Equal(lot.EndDate) {
+		t.Fatalf("end date moved from %s to %s", lot.EndDate, current.EndDate)
+	}
+	if _, ok := scheduler.scheduled(lot.ID); ok {
+		t.Fatal("lot was rescheduled without an extension")
+	}
+}
+
+func TestSealedBidRejectedAfterCompletion(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{Type: models.LotTypeSealedFirstPrice, StartPrice: 100, MinStep: 10})
+
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 300, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("first bid: %v", err)
+	}
+	var completeErr error
+	wallet.interleaveOnce(func() {
+		endLotIn(t, db, lot, -time.Second)
+		completeErr = svc.lots.CompleteLotIfDue(uint64(lot.ID))
+	})
+	err := svc.bids.CreateBid(&models.Bid{Amount: 400, UserID: 3, LotModelID: lot.ID})
+
+	if completeErr != nil {
+		t.Fatalf("complete: %v", completeErr)
+	}
+	if !errors.Is(err, repository.ErrLotVersionConflict) {
+		t.Fatalf("expected ErrLotVersionConflict, got %v", err)
+	}
+	if held := wallet.heldBy(3, lot.ID); held != 0 {
+		t.Fatalf("late bidder still has %d on hold", held)
+	}
+	if bids := lotBids(t, db, lot.ID); len(bids) != 1 || bids[0].UserID != 2 {
+		t.Fatalf("bids = %+v, want only the bid of user 2", bids)
+	}
+	if completed := reloadLot(t, db, lot.ID); completed.WinnerID != 2 {
+		t.Fatalf("winner = %d, want 2", completed.WinnerID)
+	}
+}
+
+// racingBidRepository places a bid right after the completion of a lot has
+// read its bids.
+type racingBidRepository struct {
+	repository.BidRepository
+	concurrent func()
+	started    atomic.Bool
+}
+
+func (r *racingBidRepository) GetAllBidsByLot(lotID uint64) ([]models.Bid, error) {
+	bids, err := r.BidRepository.GetAllBidsByLot(lotID)
+	if r.started.CompareAndSwap(false, true) {
+		r.concurrent()
+	}
+	return bids, err
+}
+
+func TestSealedBidDuringCompletionFailsCompletion(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{Type: models.LotTypeSealedFirstPrice, StartPrice: 100, MinStep: 10})
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 300, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("first bid: %v", err)
+	}
+
+	bidRepository := &racingBidRepository{BidRepository: repository.NewBidRepository(db)}
+	lots := NewLotService(newTestLotRepository(db), bidRepository, repository.NewProxyBidRepository(db), repository.NewOutboxRepository(db), repository.NewLotSettlementRepository(db), db, noopScheduler{})
+	var concurrentErr error
+	bidRepository.concurrent = func() {
+		concurrentErr = svc.bids.CreateBid(&models.Bid{Amount: 400, UserID: 3, LotModelID: lot.ID})
+	}
+	err := lots.ForceCompleteLot(uint64(lot.ID))
+
+	if concurrentErr != nil {
+		t.Fatalf("concurrent bid: %v", concurrentErr)
+	}
+	if !errors.Is(err, repository.ErrLotVersionConflict) {
+		t.Fatalf("expected ErrLotVersionConflict, got %v", err)
+	}
+	if status := reloadLot(t, db, lot.ID).Status; status != models.LotStatusActive {
+		t.Fatalf("lot status = %s, want it still active", status)
+	}
+
+	if err := svc.lots.ForceCompleteLot(uint64(lot.ID)); err != nil {
+		t.Fatalf("complete again: %v", err)
+	}
+	if completed := reloadLot(t, db, lot.ID); completed.WinnerID != 3 || completed.CurrentPrice != 400 {
+		t.Fatalf("winner %d at %d, want 3 at 400", completed.WinnerID, completed.CurrentPrice)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 0 {
+		t.Fatalf("losing bidder still has %d on hold", held)
+	}
+	if paid := wallet.paidBy(3); paid != 400 {
+		t.Fatalf("winner paid %d, want 400", paid)
+	}
+}
+
+func TestBidRejectedWhenLotEndsDuringFreeze(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+
+	wallet.interleaveOnce(func() {
+		endLotIn(t, db, lot, -time.Second)
+	})
+	err := svc.bids.CreateBid(&models.Bid{Amount: 200, UserID: 2, LotModelID: lot.ID})
+
+	if !errors.Is(err, repository.ErrLotVersionConflict) {
+		t.Fatalf("expected ErrLotVersionConflict, got %v", err)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 0 {
+		t.Fatalf("late bidder still has %d on hold", held)
+	}
+	if current := reloadLot(t, db, lot.ID); current.CurrentBidID != 0 || current.CurrentPrice != 100 {
+		t.Fatalf("lot took the late bid: %+v", current)
+	}
+}
+
+func TestCreateBidInLotCurrency(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{Currency: "USD", StartPrice: 100, MinStep: 10})
+
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 200, Currency: "EUR", UserID: 2, LotModelID: lot.ID}); !errors.Is(err, ErrBidCurrencyMismatch) {
+		t.Fatalf("bid in another currency: expected ErrBidCurrencyMismatch, got %v", err)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 0 {
+		t.Fatalf("rejected bid froze %d", held)
+	}
+
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 200, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("bid without currency: %v", err)
+	}
+	if bids := lotBids(t, db, lot.ID); len(bids) != 1 || bids[0].Currency != "USD" {
+		t.Fatalf("bids = %+v, want one bid in USD", bids)
+	}
+	if currency := wallet.holdCurrency(2, lot.ID); currency != "USD" {
+		t.Fatalf("hold currency = %q, want USD", currency)
+	}
+}
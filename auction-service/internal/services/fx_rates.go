@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"math"
+
+	"auction-service/internal/models"
+)
+
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// FXRates converts prices between currencies for display.
+type FXRates interface {
+	Supports(currency string) bool
+	Convert(amount int64, from, to string) (int64, error)
+}
+
+// staticFXRates converts through a fixed table of rates against a common base
+// currency.
+type staticFXRates struct {
+	rates map[string]float64
+}
+
+func NewStaticFXRates(rates map[string]float64) FXRates {
+	return &staticFXRates{rates: rates}
+}
+
+func (r *staticFXRates) Supports(currency string) bool {
+	_, ok := r.rates[currency]
+	return ok
+}
+
+func (r *staticFXRates) Convert(amount int64, from, to string) (int64, error) {
+	fromRate, ok := r.rates[from]
+	if !ok {
+		return 0, ErrUnsupportedCurrency
+	}
+	toRate, ok := r.rates[to]
+	if !ok {
+		return 0, ErrUnsupportedCurrency
+	}
+	if from == to {
+		return amount, nil
+	}
+	return int64(math.Round(float64(amount) * fromRate / toRate)), nil
+}
+
+// ConvertLotPrices fills the lots' DisplayPrice in currency. Lots whose
+// currency has no rate are left without one.
+func ConvertLotPrices(fx FXRates, lots []models.LotModel, currency string) {
+	for i := range lots {
+		lot := &lots[i]
+		price := &models.ConvertedPrice{Currency: currency}
+		var err error
+		if price.StartPrice, err = fx.Convert(lot.StartPrice, lot.Currency, currency); err != nil {
+			continue
+		}
+		if price.CurrentPrice, err = fx.Convert(lot.CurrentPrice, lot.Currency, currency); err != nil {
+			continue
+		}
+		if price.BuyNowPrice, err = fx.Convert(lot.BuyNowPrice, lot.Currency, currency); err != nil {
+			continue
+		}
+		lot.DisplayPrice = price
+	}
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"auction-service/internal/config"
+	"auction-service/internal/models"
+	"auction-service/internal/repository"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens a fresh SQLite database with the auction schema. SQLite
+// ignores FOR UPDATE and serializes writers, so the tests below interleave
+// concurrent requests deterministically (from inside wallet calls) and rely
+// on the version checks and unique indexes rather than on row locks.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "auction.db") + "?_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{TranslateError: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.LotModel{}, &models.Bid{}, &models.ProxyBid{}, &models.OutboxEvent{}, &models.BidSaga{}, &models.LotSettlement{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+type noopScheduler struct{}
+
+func (noopScheduler) Schedule(uint64, time.Time) {}
+
+// recordingScheduler remembers the last end date scheduled for each lot.
+type recordingScheduler struct {
+	mu       sync.Mutex
+	endDates map[uint64]time.Time
+}
+
+func (s *recordingScheduler) Schedule(lotID uint64, endDate time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.endDates == nil {
+		s.endDates = map[uint64]time.Time{}
+	}
+	s.endDates[lotID] = endDate
+}
+
+func (s *recordingScheduler) scheduled(lotID uint) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	endDate, ok := s.endDates[uint64(lotID)]
+	return endDate, ok
+}
+
+type testServices struct {
+	lots LotService
+	bids BidService
+}
+
+// localLockLotRepository stands in for the Postgres advisory lock, which
+// SQLite does not have, with an in-process one.
+type localLockLotRepository struct {
+	repository.LotRepository
+	mu     sync.Mutex
+	locked map[uint64]bool
+}
+
+func (r *localLockLotRepository) TryLockLot(lotID uint64, fn func() error) (bool, error) {
+	r.mu.Lock()
+	if r.locked[lotID] {
+		r.mu.Unlock()
+		return false, nil
+	}
+	r.locked[lotID] = true
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.locked, lotID)
+		r.mu.Unlock()
+	}()
+	return true, fn()
+}
+
+func newTestLotRepository(db *gorm.DB) repository.LotRepository {
+	return &localLockLotRepository{LotRepository: repository.NewLotRepository(db), locked: map[uint64]bool{}}
+}
+
+func newTestServices(t *testing.T, db *gorm.DB) *testServices {
+	t.Helper()
+	lotRepository := newTestLotRepository(db)
+	bidRepository := repository.NewBidRepository(db)
+	proxyBidRepository := repository.NewProxyBidRepository(db)
+	outboxRepository := repository.NewOutboxRepository(db)
+	return &testServices{
+		lots: NewLotService(lotRepository, bidRepository, proxyBidRepository, outboxRepository, repository.NewLotSettlementRepository(db), db, noopScheduler{}),
+		bids: NewBidService(bidRepository, lotRepository, proxyBidRepository, outboxRepository, repository.NewBidSagaRepository(db), db, config.SoftCloseConfig{}, noopScheduler{}),
+	}
+}
+
+func createActiveLot(t *testing.T, db *gorm.DB, lot models.LotModel) *models.LotModel {
+	t.Helper()
+	now := time.Now().UTC()
+	lot.Title = "lot"
+	lot.Description = "lot"
+	lot.Status = models.LotStatusActive
+	if lot.Currency == "" {
+		lot.Currency = models.DefaultCurrency
+	}
+	lot.StartDate = now.Add(-time.Minute)
+	lot.EndDate = now.Add(time.Hour)
+	lot.CurrentPrice = lot.StartPrice
+	lot.SellerID = 1
+	if err := db.Create(&lot).Error; err != nil {
+		t.Fatalf("create lot: %v", err)
+	}
+	return &lot
+}
+
+// fakeWallet is the hold API of the wallet service. It keeps the amount each
+// user has on hold per lot and replays operations repeated under the same
+// idempotency key, like the real service.
+type fakeWallet struct {
+	mu         sync.Mutex
+	held       map[string]int64
+	currencies map[string]string
+	paidOut    map[uint]int64
+	seen       map[string]bool
+
+	// beforeHold, if set, runs before a hold is applied and outside the lock,
+	// so a test can slip a concurrent request in while one is in flight.
+	beforeHold func(userID uint, key string)
+
+	// rejectHolds lists users whose holds are refused as if their wallet
+	// could not cover them.
+	rejectHolds map[uint]bool
+
+	// down drops every request without an answer, like a wallet outage.
+	down atomic.Bool
+}
+
+func newFakeWallet(t *testing.T) *fakeWallet {
+	t.Helper()
+	w := &fakeWallet{held: map[string]int64{}, currencies: map[string]string{}, paidOut: map[uint]int64{}, seen: map[string]bool{}}
+	server := httptest.NewServer(http.HandlerFunc(w.serve))
+	t.Cleanup(server.Close)
+	t.Setenv("WALLET_SERVICE_URL", server.URL)
+	return w
+}
+
+func (w *fakeWallet) serve(rw http.ResponseWriter, r *http.Request) {
+	if w.down.Load() {
+		conn, _, err := rw.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	var req struct {
+		ReferenceID string `json:"reference_id"`
+		Amount      int64  `json:"amount"`
+		Currency    string `json:"currency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, _ := strconv.ParseUint(r.Header.Get("X-User-Id"), 10, 64)
+	// Idempotency keys are unique per user, as in the wallet service.
+	key := r.Header.Get("X-User-Id") + "/" + r.Header.Get("Idempotency-Key")
+	operation := strings.TrimPrefix(r.URL.Path, "/internal/wallet/")
+
+	if operation == "holds" && w.beforeHold != nil {
+		w.beforeHold(uint(userID), key)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.seen[key] {
+		return
+	}
+	w.seen[key] = true
+
+	if operation == "holds" && w.rejectHolds[uint(userID)] {
+		http.Error(rw, "insufficient available balance", http.StatusConflict)
+		return
+	}
+
+	hold := fmt.Sprintf("%d/%s", userID, req.ReferenceID)
+	switch operation {
+	case "holds":
+		w.held[hold] += req.Amount
+		w.currencies[hold] = req.Currency
+	case "holds/release":
+		if req.Amount == 0 || req.Amount > w.held[hold] {
+			req.Amount = w.held[hold]
+		}
+		w.held[hold] -= req.Amount
+	case "holds/payout":
+		w.paidOut[uint(userID)] += req.Amount
+		w.held[hold] = 0
+	default:
+		http.Error(rw, "unknown operation", http.StatusNotFound)
+	}
+}
+
+func (w *fakeWallet) heldBy(userID, lotID uint) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.held[fmt.Sprintf("%d/%d", userID, lotID)]
+}
+
+func (w *fakeWallet) holdCurrency(userID, lotID uint) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.currencies[fmt.Sprintf("%d/%d", userID, lotID)]
+}
+
+func (w *fakeWallet) paidBy(userID uint) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.paidOut[userID]
+}
+
+// interleaveOnce runs fn the first time any hold is requested, while the
+// request that asked for it waits for the wallet. Holds requested by fn
+// itself go through directly.
+func (w *fakeWallet) interleaveOnce(fn func()) {
+	var started atomic.Bool
+	w.beforeHold = func(uint, string) {
+		if started.CompareAndSwap(false, true) {
+			fn()
+		}
+	}
+}
+
+func lotBids(t *testing.T, db *gorm.DB, lotID uint) []models.Bid {
+	t.Helper()
+	var bids []models.Bid
+	if err := db.Where("lot_model_id = ?", lotID).Order("id").Find(&bids).Error; err != nil {
+		t.Fatalf("load bids: %v", err)
+	}
+	return bids
+}
+
+func activeProxyBid(t *testing.T, db *gorm.DB, lotID, userID uint) *models.ProxyBid {
+	t.Helper()
+	var proxyBids []models.ProxyBid
+	if err := db.Where("lot_model_id = ? AND user_id = ? AND active", lotID, userID).Find(&proxyBids).Error; err != nil {
+		t.Fatalf("load proxy bids: %v", err)
+	}
+	if len(proxyBids) == 0 {
+		return nil
+	}
+	return &proxyBids[0]
+}
+
+func outboxTopics(t *testing.T, db *gorm.DB, lotID uint) []string {
+	t.Helper()
+	var topics []string
+	if err := db.Model(&models.OutboxEvent{}).Where("key = ?", strconv.FormatUint(uint64(lotID), 10)).Order("id").Pluck("topic", &topics).Error; err != nil {
+		t.Fatalf("load outbox: %v", err)
+	}
+	return topics
+}
+
+func lotSettlements(t *testing.T, db *gorm.DB, lotID uint) []models.LotSettlement {
+	t.Helper()
+	var settlements []models.LotSettlement
+	if err := db.Where("lot_model_id = ?", lotID).Order("id").Find(&settlements).Error; err != nil {
+		t.Fatalf("load settlements: %v", err)
+	}
+	return settlements
+}
+
+// makeSettlementsDue skips the backoff of every pending settlement.
+func makeSettlementsDue(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	err := db.Model(&models.LotSettlement{}).Where("status = ?", models.LotSettlementStatusPending).
+		Update("next_attempt_at", time.Now().UTC().Add(-time.Second)).Error
+	if err != nil {
+		t.Fatalf("make settlements due: %v", err)
+	}
+}
+
+func reloadLot(t *testing.T, db *gorm.DB, lotID uint) *models.LotModel {
+	t.Helper()
+	var lot models.LotModel
+	if err := db.First(&lot, lotID).Error; err != nil {
+		t.Fatalf("load lot: %v", err)
+	}
+	return &lot
+}
@@ -4,17 +4,32 @@ import (
 	"auction-service/internal/kafka"
 	"auction-service/internal/models"
 	"auction-service/internal/repository"
-	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"os"
+	"sort"
 	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrLotCancelForbidden = errors.New("only the seller can cancel the lot")
+	ErrLotHasBids         = errors.New("lot already has bids, only an admin can cancel it")
+
+	ErrLotNotActive = errors.New("only active lots can be completed")
+	ErrLotBusy      = errors.New("lot is being completed, retry later")
+
+	// ErrPurchaseUnavailable wraps every reason a lot cannot be bought right
+	// now, as opposed to a failure to settle the purchase.
+	ErrPurchaseUnavailable = errors.New("purchase unavailable")
 )
 
+// LotCloseScheduler is told about every lot end date that becomes known or moves.
+type LotCloseScheduler interface {
+	Schedule(lotID uint64, endDate time.Time)
+}
+
 type LotService interface {
 	CreateLot(lotModel *models.LotModel) error
 	PublishLot(id uint64) error
@@ -23,25 +38,84 @@ type LotService interface {
 	UpdateLot(lotModel *models.LotModel) error
 	GetAllLotsByUser(userID uint64) ([]models.LotModel, error)
 	CompleteExpiredLots() error
+	CompleteLotIfDue(id uint64) error
+	ScheduleOpenLots() error
 	ForceCompleteLot(id uint64) error
+	BuyNow(id uint64, buyerID uint) error
+	AcceptDutchPrice(id uint64, buyerID uint) error
+	TickDutchLots() error
+	StartScheduledLots() error
+	CancelLot(id uint64, requesterID uint64, isAdmin bool) error
+	RetryLotSettlements() error
 }
 
 type lotService struct {
-	repository    repository.LotRepository
-	bidRepository repository.BidRepository
-	kafkaProducer *kafka.Producer
+	repository           repository.LotRepository
+	bidRepository        repository.BidRepository
+	proxyBidRepository   repository.ProxyBidRepository
+	outboxRepository     repository.OutboxRepository
+	settlementRepository repository.LotSettlementRepository
+	db                   *gorm.DB
+	scheduler            LotCloseScheduler
 }
 
-func NewLotService(repository repository.LotRepository, bidRepository repository.BidRepository, kafkaProducer *kafka.Producer) LotService {
+func NewLotService(repository repository.LotRepository, bidRepository repository.BidRepository, proxyBidRepository repository.ProxyBidRepository, outboxRepository repository.OutboxRepository, settlementRepository repository.LotSettlementRepository, db *gorm.DB, scheduler LotCloseScheduler) LotService {
 	return &lotService{
-		repository:    repository,
-		bidRepository: bidRepository,
-		kafkaProducer: kafkaProducer,
+		repository:           repository,
+		bidRepository:        bidRepository,
+		proxyBidRepository:   proxyBidRepository,
+		outboxRepository:     outboxRepository,
+		settlementRepository: settlementRepository,
+		db:                   db,
+		scheduler:            scheduler,
 	}
 }
 
+// saveLotWithEvent persists the lot and queues its Kafka event in the outbox
+// within a single transaction.
+func (s *lotService) saveLotWithEvent(lot *models.LotModel, topic string, event any) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.repository.WithDB(tx).UpdateLot(lot); err != nil {
+			return err
+		}
+		return s.outboxRepository.WithDB(tx).Enqueue(topic, fmt.Sprintf("%d", lot.ID), event)
+	})
+}
+
+// closeLotWithEvent is saveLotWithEvent for a lot that has been completed or
+// canceled: its proxy bids stop bidding and the wallet settlements are queued
+// in the same transaction. A purchase bid, if given, is stored in it too and
+// becomes the lot's current bid, so a purchase that loses a race with another
+// one leaves no bid behind.
+func (s *lotService) closeLotWithEvent(lot *models.LotModel, purchase *models.Bid, settlements []models.LotSettlement, topic string, event any) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if purchase != nil {
+			if err := s.bidRepository.WithDB(tx).CreateBid(purchase); err != nil {
+				return fmt.Errorf("failed to create bid: %w", err)
+			}
+			lot.CurrentBidID = uint64(purchase.ID)
+		}
+		if err := s.repository.WithDB(tx).UpdateLot(lot); err != nil {
+			return err
+		}
+		if err := s.proxyBidRepository.WithDB(tx).DeactivateLotProxyBids(uint64(lot.ID)); err != nil {
+			return err
+		}
+		if err := s.settlementRepository.WithDB(tx).CreateSettlements(settlements); err != nil {
+			return err
+		}
+		return s.outboxRepository.WithDB(tx).Enqueue(topic, fmt.Sprintf("%d", lot.ID), event)
+	})
+}
+
 func (s *lotService) CreateLot(lotModel *models.LotModel) error {
 	lotModel.Status = models.LotStatusDraft
+	if lotModel.Type == "" {
+		lotModel.Type = models.LotTypeEnglish
+	}
+	if lotModel.Currency == "" {
+		lotModel.Currency = models.DefaultCurrency
+	}
 	nowUTC := time.Now().UTC()
 	if lotModel.StartDate.IsZero() {
 		lotModel.StartDate = nowUTC
@@ -61,6 +135,10 @@ func (s *lotService) CreateLot(lotModel *models.LotModel) error {
 		return errors.New("start date cannot be in the past")
 	}
 
+	if err := validatePrices(lotModel); err != nil {
+		return err
+	}
+
 	lotModel.CurrentPrice = lotModel.StartPrice
 	lotModel.WinnerID = 0
 	lotModel.CurrentBidID = 0
@@ -68,6 +146,36 @@ func (s *lotService) CreateLot(lotModel *models.LotModel) error {
 	return s.repository.CreateLot(lotModel)
 }
 
+func validatePrices(lotModel *models.LotModel) error {
+	if lotModel.Type == models.LotTypeDutch {
+		if lotModel.DropIntervalSeconds <= 0 {
+			return errors.New("dutch lots require a positive drop interval")
+		}
+		if lotModel.FloorPrice >= lotModel.StartPrice {
+			return errors.New("floor price must be less than start price")
+		}
+		if lotModel.ReservePrice != 0 || lotModel.BuyNowPrice != 0 {
+			return errors.New("dutch lots cannot have reserve or buy now price")
+		}
+		return nil
+	}
+	if lotModel.IsSealed() && lotModel.BuyNowPrice != 0 {
+		return errors.New("sealed lots cannot have buy now price")
+	}
+	if lotModel.ReservePrice != 0 && lotModel.ReservePrice < lotModel.StartPrice {
+		return errors.New("reserve price must not be less than start price")
+	}
+	if lotModel.BuyNowPrice != 0 {
+		if lotModel.BuyNowPrice <= lotModel.StartPrice {
+			return errors.New("buy now price must be greater than start price")
+		}
+		if lotModel.BuyNowPrice < lotModel.ReservePrice {
+			return errors.New("buy now price must not be less than reserve price")
+		}
+	}
+	return nil
+}
+
 func (s *lotService) PublishLot(id uint64) error {
 	lotModel, err := s.repository.GetLotByID(id)
 	if err != nil {
@@ -76,17 +184,60 @@ func (s *lotService) PublishLot(id uint64) error {
 	if lotModel.Status != models.LotStatusDraft {
 		return errors.New("only draft lots can be published")
 	}
+	now := time.Now().UTC()
+	if !lotModel.EndDate.After(now) {
+		return errors.New("lot end date has already passed")
+	}
 	lotModel.Status = models.LotStatusActive
+	if lotModel.StartDate.After(now) {
+		lotModel.Status = models.LotStatusScheduled
+	}
 	if lotModel.CurrentPrice == 0 {
 		lotModel.CurrentPrice = lotModel.StartPrice
 	}
-	if err := s.repository.UpdateLot(lotModel); err != nil {
+	if lotModel.Status == models.LotStatusActive {
+		err = s.saveLotWithEvent(lotModel, "lot_started", lotStartedEvent(lotModel))
+	} else {
+		err = s.repository.UpdateLot(lotModel)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to publish lot: %w", err)
 	}
+	s.scheduler.Schedule(uint64(lotModel.ID), lotModel.EndDate)
 
 	return nil
 }
 
+func (s *lotService) StartScheduledLots() error {
+	lots, err := s.repository.GetDueScheduledLots()
+	if err != nil {
+		return err
+	}
+
+	// One lot failing to start does not hold back the others; it is picked up
+	// again by the next run while it stays scheduled.
+	for i := range lots {
+		lots[i].Status = models.LotStatusActive
+		if err := s.saveLotWithEvent(&lots[i], "lot_started", lotStartedEvent(&lots[i])); err != nil {
+			if errors.Is(err, repository.ErrLotVersionConflict) {
+				log.Printf("scheduled lot %d changed before start, skipping", lots[i].ID)
+				continue
+			}
+			log.Printf("WARNING: failed to start scheduled lot %d: %v", lots[i].ID, err)
+		}
+	}
+	return nil
+}
+
+func lotStartedEvent(lot *models.LotModel) kafka.LotStartedEvent {
+	return kafka.LotStartedEvent{
+		LotID:     uint64(lot.ID),
+		SellerID:  lot.SellerID,
+		StartDate: lot.StartDate,
+		EndDate:   lot.EndDate,
+	}
+}
+
 func (s *lotService) GetLotByID(id uint64) (*models.LotModel, error) {
 	return s.repository.GetLotByID(uint64(id))
 }
@@ -121,6 +272,9 @@ func (s *lotService) UpdateLot(lotModel *models.LotModel) error {
 	if lotModel.EndDate.Before(time.Now()) {
 		return errors.New("lot end date cannot be in the past")
 	}
+	if err := validatePrices(lotModel); err != nil {
+		return err
+	}
 
 	return s.repository.UpdateLot(lotModel)
 }
@@ -136,107 +290,385 @@ func (s *lotService) CompleteExpiredLots() error {
 	}
 
 	for _, lot := range expiredLots {
-		lot.Status = models.LotStatusCompleted
-		if lot.CurrentBidID != 0 {
-			bid, err := s.bidRepository.GetBidByID(lot.CurrentBidID)
-			if err == nil && bid != nil {
-				lot.WinnerID = uint64(bid.UserID)
-			}
+		if err := s.CompleteLotIfDue(uint64(lot.ID)); err != nil {
+			return err
 		}
-		if err := s.repository.UpdateLot(&lot); err != nil {
+	}
+	return nil
+}
+
+// CompleteLotIfDue completes an active lot whose end date has passed. The lot
+// is re-read under an advisory lock, so concurrent replicas firing for the same
+// lot complete it only once, and a lot extended meanwhile is rescheduled.
+func (s *lotService) CompleteLotIfDue(id uint64) error {
+	acquired, err := s.repository.TryLockLot(id, func() error {
+		lot, err := s.repository.GetLotByID(id)
+		if err != nil {
 			return err
 		}
+		if lot.Status != models.LotStatusActive {
+			return nil
+		}
+		if lot.EndDate.After(time.Now()) {
+			s.scheduler.Schedule(id, lot.EndDate)
+			return nil
+		}
+		return s.completeLot(lot, nil)
+	})
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		log.Printf("lot %d is being completed by another replica", id)
+	}
+	return nil
+}
 
-		if lot.WinnerID != 0 && lot.CurrentPrice > 0 {
-			if err := s.chargeWallet(uint(lot.WinnerID), lot.CurrentPrice, fmt.Sprintf("Auction payment for lot #%d", lot.ID)); err != nil {
-				log.Printf("WARNING: failed to charge winner wallet for lot %d: %v", lot.ID, err)
-			}
+// ScheduleOpenLots loads the end dates of all scheduled and active lots into
+// the scheduler. It runs on startup and periodically to pick up lots published
+// or extended through other replicas.
+func (s *lotService) ScheduleOpenLots() error {
+	lots, err := s.repository.GetOpenLots()
+	if err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		s.scheduler.Schedule(uint64(lot.ID), lot.EndDate)
+	}
+	return nil
+}
+
+// completeLot closes the lot and settles it with the wallet service. A
+// purchase is the buy-now or dutch bid that completes the lot; it is stored
+// together with the completed lot, and the leader it replaces is released.
+func (s *lotService) completeLot(lot *models.LotModel, purchase *models.Bid) error {
+	lot.Status = models.LotStatusCompleted
+
+	var replacedBid *models.Bid
+	if purchase != nil && lot.CurrentBidID != 0 {
+		bid, err := s.bidRepository.GetBidByID(lot.CurrentBidID)
+		if err != nil {
+			return fmt.Errorf("failed to get previous bid %d: %w", lot.CurrentBidID, err)
 		}
+		replacedBid = bid
+	}
 
-		if s.kafkaProducer != nil {
-			event := kafka.LotCompletedEvent{
-				LotID:      uint64(lot.ID),
-				Winner:     lot.WinnerID,
-				FinalPrice: lot.CurrentPrice,
-				LoserIDs:   nil,
-			}
+	var sealedBids []models.Bid
+	if lot.IsSealed() {
+		bids, err := s.bidRepository.GetAllBidsByLot(uint64(lot.ID))
+		if err != nil {
+			return err
+		}
+		sealedBids = bids
+		resolveSealedBids(lot, sealedBids)
+	}
 
-			if err := s.kafkaProducer.SendMessage("lot_completed", fmt.Sprintf("%d", lot.ID), event); err != nil {
-				log.Printf("WARNING: failed to send lot_completed event to Kafka: %v", err)
-			}
+	topBid := purchase
+	if topBid == nil && lot.CurrentBidID != 0 {
+		bid, err := s.bidRepository.GetBidByID(lot.CurrentBidID)
+		if err == nil && bid != nil {
+			topBid = bid
+		}
+	}
+
+	switch {
+	case topBid == nil:
+		lot.Outcome = models.LotOutcomeNoBids
+	case purchase == nil && !lot.IsReserveMet():
+		lot.Outcome = models.LotOutcomeReserveNotMet
+	default:
+		lot.Outcome = models.LotOutcomeSold
+		lot.WinnerID = uint64(topBid.UserID)
+	}
+
+	event := kafka.LotCompletedEvent{
+		LotID:      uint64(lot.ID),
+		Winner:     lot.WinnerID,
+		FinalPrice: lot.CurrentPrice,
+		LoserIDs:   nil,
+		Outcome:    string(lot.Outcome),
+		SellerID:   lot.SellerID,
+	}
+	if topBid != nil {
+		event.TopBidderID = uint64(topBid.UserID)
+	}
+
+	var settlements []models.LotSettlement
+	switch lot.Outcome {
+	case models.LotOutcomeSold:
+		if lot.CurrentPrice > 0 {
+			settlements = append(settlements, newLotSettlement(models.LotSettlementPayout, lot, uint(lot.WinnerID), lot.CurrentPrice,
+				fmt.Sprintf("Auction payment for lot #%d", lot.ID), fmt.Sprintf("lot-%d-payout", lot.ID)))
 		}
+	case models.LotOutcomeReserveNotMet:
+		settlements = append(settlements, lotReleaseSettlement(lot, topBid.UserID, fmt.Sprintf("Reserve not met for lot #%d", lot.ID)))
+	}
+	if lot.IsSealed() {
+		settlements = append(settlements, sealedBidReleases(lot, sealedBids)...)
+	}
+	// A buyer who was leading has the rest of their hold released by the
+	// payout.
+	if replacedBid != nil && replacedBid.UserID != purchase.UserID {
+		settlements = append(settlements, lotReleaseSettlement(lot, replacedBid.UserID, fmt.Sprintf("Lot #%d was bought", lot.ID)))
 	}
+
+	if err := s.closeLotWithEvent(lot, purchase, settlements, "lot_completed", event); err != nil {
+		return err
+	}
+	s.runSettlements(settlements)
 	return nil
 }
 
-func (s *lotService) chargeWallet(userID uint, amount int64, description string) error {
-	base := os.Getenv("WALLET_SERVICE_URL")
-	if base == "" {
-		return errors.New("wallet service url is not configured")
+// resolveSealedBids picks the highest sealed bid (earliest wins ties) as the
+// current bid and sets the clearing price: the winner's own amount for
+// first-price lots, the second-highest amount for second-price lots.
+func resolveSealedBids(lot *models.LotModel, bids []models.Bid) {
+	if len(bids) == 0 {
+		return
 	}
-	url := fmt.Sprintf("%s/api/wallet/charge", base)
+	ranked := make([]models.Bid, len(bids))
+	copy(ranked, bids)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Amount != ranked[j].Amount {
+			return ranked[i].Amount > ranked[j].Amount
+		}
+		return ranked[i].CreatedAt.Before(ranked[j].CreatedAt)
+	})
+
+	top := ranked[0]
+	lot.CurrentBidID = uint64(top.ID)
+	lot.CurrentPrice = top.Amount
 
-	payload := map[string]any{
-		"amount":      amount,
-		"description": description,
+	if lot.Type != models.LotTypeSealedSecondPrice {
+		return
 	}
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal charge payload: %w", err)
+	price := lot.StartPrice
+	if len(ranked) > 1 && ranked[1].Amount > price {
+		price = ranked[1].Amount
 	}
+	if lot.ReservePrice > price && top.Amount >= lot.ReservePrice {
+		price = lot.ReservePrice
+	}
+	if price < top.Amount {
+		lot.CurrentPrice = price
+	}
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+// sealedBidReleases releases the holds of every losing sealed bid. The
+// winner's hold is settled by completeLot: capturing the clearing price also
+// releases the part of the bid above it.
+func sealedBidReleases(lot *models.LotModel, bids []models.Bid) []models.LotSettlement {
+	description := fmt.Sprintf("Sealed bid release for lot #%d", lot.ID)
+	var settlements []models.LotSettlement
+	for _, bid := range bids {
+		if uint64(bid.ID) == lot.CurrentBidID {
+			continue
+		}
+		settlements = append(settlements, lotReleaseSettlement(lot, bid.UserID, description))
+	}
+	return settlements
+}
+
+// ForceCompleteLot completes an active lot before its end date. It takes the
+// advisory lock of CompleteLotIfDue, so it never runs alongside the scheduler
+// closing the same lot.
+func (s *lotService) ForceCompleteLot(id uint64) error {
+	acquired, err := s.repository.TryLockLot(id, func() error {
+		lot, err := s.repository.GetLotByID(id)
+		if err != nil {
+			return err
+		}
+		if lot.Status != models.LotStatusActive {
+			return fmt.Errorf("%w: lot is %s", ErrLotNotActive, lot.Status)
+		}
+		return s.completeLot(lot, nil)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to build charge request: %w", err)
+		return err
+	}
+	if !acquired {
+		return ErrLotBusy
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-User-Id", fmt.Sprintf("%d", userID))
+	return nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+func (s *lotService) BuyNow(id uint64, buyerID uint) error {
+	lot, err := s.repository.GetLotByID(id)
 	if err != nil {
-		return fmt.Errorf("failed to call wallet charge: %w", err)
+		return fmt.Errorf("failed to get lot: %w", err)
+	}
+	if lot.Status != models.LotStatusActive {
+		return fmt.Errorf("%w: buy now is only available on active lots", ErrPurchaseUnavailable)
+	}
+	if lot.Type == models.LotTypeDutch || lot.BuyNowPrice == 0 {
+		return fmt.Errorf("%w: lot has no buy now price", ErrPurchaseUnavailable)
+	}
+	if lot.CurrentPrice >= lot.BuyNowPrice {
+		return fmt.Errorf("%w: bids reached the buy now price", ErrPurchaseUnavailable)
+	}
+	if uint64(buyerID) == lot.SellerID {
+		return fmt.Errorf("%w: seller cannot buy own lot", ErrPurchaseUnavailable)
+	}
+	now := time.Now().UTC()
+	if now.Before(lot.StartDate.UTC()) || now.After(lot.EndDate.UTC()) {
+		return fmt.Errorf("%w: lot is not open for buying", ErrPurchaseUnavailable)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("wallet charge returned %d: %s", resp.StatusCode, string(b))
+
+	return s.settlePurchase(lot, buyerID, lot.BuyNowPrice, fmt.Sprintf("Buy now for lot #%d", lot.ID))
+}
+
+// settlePurchase closes an active lot immediately at the given price: the
+// buyer's funds are frozen and charged, and the current leader is released.
+// If the lot cannot be completed the buyer's hold is released again, retried
+// like the other settlements.
+func (s *lotService) settlePurchase(lot *models.LotModel, buyerID uint, price int64, description string) error {
+	purchaseKey := lotPurchaseKey(lot, buyerID)
+	if err := holdLotFunds(buyerID, lot.ID, price, lot.Currency, description, purchaseKey); err != nil {
+		return fmt.Errorf("failed to freeze wallet: %w", err)
+	}
+
+	bid := &models.Bid{
+		Amount:     price,
+		Currency:   lot.Currency,
+		UserID:     buyerID,
+		LotModelID: lot.ID,
+	}
+	lot.CurrentPrice = bid.Amount
+	if err := s.completeLot(lot, bid); err != nil {
+		s.queueSettlements([]models.LotSettlement{
+			newLotSettlement(models.LotSettlementRelease, lot, buyerID, price, description, purchaseKey+"-revert"),
+		})
+		return fmt.Errorf("failed to complete lot: %w", err)
 	}
 	return nil
 }
 
-func (s *lotService) ForceCompleteLot(id uint64) error {
+// lotPurchaseKey identifies the buyer's purchase hold on the lot as read. A
+// request retried against the same lot replays the hold instead of freezing
+// twice. Every write to the lot bumps its version, so after a purchase lost a
+// race (or a dutch price drop) a new attempt holds afresh rather than
+// replaying the hold that was already reverted.
+func lotPurchaseKey(lot *models.LotModel, buyerID uint) string {
+	return fmt.Sprintf("lot-%d-purchase-%d-v%d", lot.ID, buyerID, lot.Version)
+}
+
+// lotReleaseKey identifies the final release of a user's hold on a lot; it
+// happens at most once, whichever way the lot ends.
+func lotReleaseKey(lot *models.LotModel) string {
+	return fmt.Sprintf("lot-%d-release", lot.ID)
+}
+
+// dutchPriceAt returns the scheduled price of a dutch lot at the given moment.
+func dutchPriceAt(lot *models.LotModel, at time.Time) int64 {
+	if lot.DropIntervalSeconds <= 0 || !at.After(lot.StartDate) {
+		return lot.StartPrice
+	}
+	drops := int64(at.Sub(lot.StartDate) / (time.Duration(lot.DropIntervalSeconds) * time.Second))
+	price := lot.StartPrice - drops*lot.MinStep
+	if price < lot.FloorPrice {
+		price = lot.FloorPrice
+	}
+	return price
+}
+
+func (s *lotService) AcceptDutchPrice(id uint64, buyerID uint) error {
 	lot, err := s.repository.GetLotByID(id)
+	if err != nil {
+		return fmt.Errorf("failed to get lot: %w", err)
+	}
+	if lot.Type != models.LotTypeDutch {
+		return fmt.Errorf("%w: only dutch lots accept the current price", ErrPurchaseUnavailable)
+	}
+	if lot.Status != models.LotStatusActive {
+		return fmt.Errorf("%w: lot is not active", ErrPurchaseUnavailable)
+	}
+	if uint64(buyerID) == lot.SellerID {
+		return fmt.Errorf("%w: seller cannot buy own lot", ErrPurchaseUnavailable)
+	}
+	now := time.Now().UTC()
+	if now.Before(lot.StartDate.UTC()) || now.After(lot.EndDate.UTC()) {
+		return fmt.Errorf("%w: lot is not open for buying", ErrPurchaseUnavailable)
+	}
+
+	price := dutchPriceAt(lot, now)
+	return s.settlePurchase(lot, buyerID, price, fmt.Sprintf("Dutch auction purchase for lot #%d", lot.ID))
+}
+
+func (s *lotService) TickDutchLots() error {
+	lots, err := s.repository.GetActiveLotsByType(models.LotTypeDutch)
 	if err != nil {
 		return err
 	}
 
-	lot.Status = models.LotStatusCompleted
-	if lot.CurrentBidID != 0 {
-		bid, err := s.bidRepository.GetBidByID(lot.CurrentBidID)
-		if err == nil && bid != nil {
-			lot.WinnerID = uint64(bid.UserID)
+	now := time.Now().UTC()
+	for i := range lots {
+		price := dutchPriceAt(&lots[i], now)
+		if price == lots[i].CurrentPrice {
+			continue
+		}
+		lots[i].CurrentPrice = price
+		if err := s.repository.UpdateLot(&lots[i]); err != nil {
+			// The lot was bought or closed since it was read; the next tick
+			// sees its new state.
+			if errors.Is(err, repository.ErrLotVersionConflict) {
+				log.Printf("dutch lot %d changed during price tick, skipping", lots[i].ID)
+				continue
+			}
+			return err
 		}
 	}
-	if err := s.repository.UpdateLot(lot); err != nil {
-		return err
+	return nil
+}
+
+func (s *lotService) CancelLot(id uint64, requesterID uint64, isAdmin bool) error {
+	lot, err := s.repository.GetLotByID(id)
+	if err != nil {
+		return fmt.Errorf("failed to get lot: %w", err)
+	}
+	if !isAdmin && lot.SellerID != requesterID {
+		return ErrLotCancelForbidden
+	}
+	switch lot.Status {
+	case models.LotStatusDraft, models.LotStatusScheduled, models.LotStatusActive:
+	default:
+		return fmt.Errorf("lot in status %s cannot be canceled", lot.Status)
+	}
+	if len(lot.Bids) > 0 && !isAdmin {
+		return ErrLotHasBids
 	}
 
-	if lot.WinnerID != 0 && lot.CurrentPrice > 0 {
-		if err := s.chargeWallet(uint(lot.WinnerID), lot.CurrentPrice, fmt.Sprintf("Auction payment for lot #%d", lot.ID)); err != nil {
-			log.Printf("WARNING: failed to charge winner wallet for lot %d: %v", lot.ID, err)
+	var frozenBids []models.Bid
+	if lot.IsSealed() {
+		frozenBids = lot.Bids
+	} else if lot.CurrentBidID != 0 {
+		bid, err := s.bidRepository.GetBidByID(lot.CurrentBidID)
+		if err != nil {
+			log.Printf("WARNING: failed to get current bid %d: %v", lot.CurrentBidID, err)
+		} else {
+			frozenBids = []models.Bid{*bid}
 		}
 	}
 
-	if s.kafkaProducer != nil {
-		event := kafka.LotCompletedEvent{
-			LotID:      uint64(lot.ID),
-			Winner:     lot.WinnerID,
-			FinalPrice: lot.CurrentPrice,
-			LoserIDs:   nil,
-		}
-		if err := s.kafkaProducer.SendMessage("lot_completed", fmt.Sprintf("%d", lot.ID), event); err != nil {
-			log.Printf("WARNING: failed to send lot_completed event to Kafka: %v", err)
-		}
+	event := kafka.LotCanceledEvent{
+		LotID:      uint64(lot.ID),
+		SellerID:   lot.SellerID,
+		CanceledBy: requesterID,
+	}
+	for _, bid := range frozenBids {
+		event.BidderIDs = append(event.BidderIDs, uint64(bid.UserID))
+	}
+
+	description := fmt.Sprintf("Lot #%d canceled", lot.ID)
+	var settlements []models.LotSettlement
+	for _, bid := range frozenBids {
+		settlements = append(settlements, lotReleaseSettlement(lot, bid.UserID, description))
+	}
+
+	lot.Status = models.LotStatusCanceled
+	lot.Bids = nil
+	if err := s.closeLotWithEvent(lot, nil, settlements, "lot_canceled", event); err != nil {
+		return fmt.Errorf("failed to cancel lot: %w", err)
 	}
+	s.runSettlements(settlements)
 	return nil
 }
@@ -0,0 +1,486 @@
+package services
+
+import (
+	"auction-service/internal/models"
+	"auction-service/internal/repository"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestAcceptDutchPriceConcurrentBuyers(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{Type: models.LotTypeDutch, StartPrice: 1000, MinStep: 10, FloorPrice: 100, DropIntervalSeconds: 3600})
+
+	var concurrentErr error
+	wallet.interleaveOnce(func() {
+		concurrentErr = svc.lots.AcceptDutchPrice(uint64(lot.ID), 3)
+	})
+	err := svc.lots.AcceptDutchPrice(uint64(lot.ID), 2)
+
+	if concurrentErr != nil {
+		t.Fatalf("concurrent accept: %v", concurrentErr)
+	}
+	if !errors.Is(err, repository.ErrLotVersionConflict) {
+		t.Fatalf("expected ErrLotVersionConflict, got %v", err)
+	}
+	completed := reloadLot(t, db, lot.ID)
+	if completed.Status != models.LotStatusCompleted || completed.WinnerID != 3 {
+		t.Fatalf("lot status %s winner %d, want completed by 3", completed.Status, completed.WinnerID)
+	}
+	if bids := lotBids(t, db, lot.ID); len(bids) != 1 || bids[0].UserID != 3 {
+		t.Fatalf("bids = %+v, want only the purchase of user 3", bids)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 0 {
+		t.Fatalf("losing buyer still has %d on hold", held)
+	}
+	if paid := wallet.paidBy(3); paid != 1000 {
+		t.Fatalf("winner paid %d, want 1000", paid)
+	}
+	if paid := wallet.paidBy(2); paid != 0 {
+		t.Fatalf("losing buyer paid %d", paid)
+	}
+}
+
+func TestAcceptDutchPriceRejectsClosedLot(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{Type: models.LotTypeDutch, StartPrice: 1000, MinStep: 10, FloorPrice: 100, DropIntervalSeconds: 3600})
+
+	if err := svc.lots.AcceptDutchPrice(uint64(lot.ID), 2); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	if err := svc.lots.AcceptDutchPrice(uint64(lot.ID), 3); !errors.Is(err, ErrPurchaseUnavailable) {
+		t.Fatalf("expected ErrPurchaseUnavailable, got %v", err)
+	}
+}
+
+// conflictingLotRepository fails the update of one lot as if it had been
+// bought between the read and the write.
+type conflictingLotRepository struct {
+	repository.LotRepository
+	lotID uint
+}
+
+func (r *conflictingLotRepository) UpdateLot(lot *models.LotModel) error {
+	if lot.ID == r.lotID {
+		return repository.ErrLotVersionConflict
+	}
+	return r.LotRepository.UpdateLot(lot)
+}
+
+func (r *conflictingLotRepository) WithDB(db *gorm.DB) repository.LotRepository {
+	return &conflictingLotRepository{LotRepository: r.LotRepository.WithDB(db), lotID: r.lotID}
+}
+
+func TestTickDutchLotsSkipsConflictingLot(t *testing.T) {
+	db := newTestDB(t)
+	first := createActiveLot(t, db, models.LotModel{Type: models.LotTypeDutch, StartPrice: 1000, MinStep: 10, FloorPrice: 100, DropIntervalSeconds: 1})
+	second := createActiveLot(t, db, models.LotModel{Type: models.LotTypeDutch, StartPrice: 1000, MinStep: 10, FloorPrice: 100, DropIntervalSeconds: 1})
+
+	lotRepository := &conflictingLotRepository{LotRepository: repository.NewLotRepository(db), lotID: first.ID}
+	svc := NewLotService(lotRepository, repository.NewBidRepository(db), repository.NewProxyBidRepository(db), repository.NewOutboxRepository(db), repository.NewLotSettlementRepository(db), db, noopScheduler{})
+
+	if err := svc.TickDutchLots(); err != nil {
+		t.Fatalf("tick: %v", err)
+	}
+	if got := reloadLot(t, db, second.ID).CurrentPrice; got >= 1000 {
+		t.Fatalf("second lot price = %d, want it to have dropped", got)
+	}
+}
+
+func completeNow(t *testing.T, db *gorm.DB, svc *testServices, lot *models.LotModel) *models.LotModel {
+	t.Helper()
+	endLotIn(t, db, lot, -time.Second)
+	if err := svc.lots.CompleteLotIfDue(uint64(lot.ID)); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	return reloadLot(t, db, lot.ID)
+}
+
+func TestCreateLotRejectsReserveBelowStartPrice(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+
+	lot := &models.LotModel{Title: "lot", Description: "lot", StartPrice: 100, MinStep: 10, ReservePrice: 50, SellerID: 1}
+	if err := svc.lots.CreateLot(lot); err == nil {
+		t.Fatal("expected a reserve below the start price to be rejected")
+	}
+}
+
+func TestCompleteLotReserveNotMet(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10, ReservePrice: 500})
+
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 200, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("bid: %v", err)
+	}
+	if reloadLot(t, db, lot.ID).ReserveMet {
+		t.Fatal("reserve reported as met below the reserve price")
+	}
+
+	completed := completeNow(t, db, svc, lot)
+	if completed.Status != models.LotStatusCompleted || completed.Outcome != models.LotOutcomeReserveNotMet {
+		t.Fatalf("lot status %s outcome %s, want completed with reserve not met", completed.Status, completed.Outcome)
+	}
+	if completed.WinnerID != 0 {
+		t.Fatalf("winner = %d, want none", completed.WinnerID)
+	}
+	if paid := wallet.paidBy(2); paid != 0 {
+		t.Fatalf("top bidder paid %d below the reserve", paid)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 0 {
+		t.Fatalf("top bidder still has %d on hold", held)
+	}
+}
+
+func TestCompleteLotReserveMet(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10, ReservePrice: 500})
+
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 500, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("bid: %v", err)
+	}
+
+	completed := completeNow(t, db, svc, lot)
+	if completed.Outcome != models.LotOutcomeSold || completed.WinnerID != 2 {
+		t.Fatalf("outcome %s winner %d, want sold to 2", completed.Outcome, completed.WinnerID)
+	}
+	if paid := wallet.paidBy(2); paid != 500 {
+		t.Fatalf("winner paid %d, want 500", paid)
+	}
+}
+
+func TestCompleteLotWithoutBids(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10, ReservePrice: 500})
+
+	completed := completeNow(t, db, svc, lot)
+	if completed.Status != models.LotStatusCompleted || completed.Outcome != models.LotOutcomeNoBids || completed.WinnerID != 0 {
+		t.Fatalf("lot status %s outcome %s winner %d, want completed with no bids", completed.Status, completed.Outcome, completed.WinnerID)
+	}
+	if topics := outboxTopics(t, db, lot.ID); len(topics) != 1 || topics[0] != "lot_completed" {
+		t.Fatalf("outbox topics = %v, want a single lot_completed", topics)
+	}
+}
+
+func TestCreateLotValidatesBuyNowPrice(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+
+	cases := map[string]models.LotModel{
+		"not above start price": {StartPrice: 100, BuyNowPrice: 100},
+		"below reserve price":   {StartPrice: 100, ReservePrice: 500, BuyNowPrice: 400},
+		"on a sealed lot":       {Type: models.LotTypeSealedFirstPrice, StartPrice: 100, BuyNowPrice: 500},
+		"on a dutch lot":        {Type: models.LotTypeDutch, StartPrice: 100, FloorPrice: 10, DropIntervalSeconds: 60, BuyNowPrice: 500},
+	}
+	for name, lot := range cases {
+		lot.Title, lot.Description, lot.MinStep, lot.SellerID = "lot", "lot", 10, 1
+		if err := svc.lots.CreateLot(&lot); err == nil {
+			t.Errorf("buy now price %s was accepted", name)
+		}
+	}
+}
+
+func TestBuyNowClosesLotAndReleasesLeader(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10, BuyNowPrice: 1000})
+
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 200, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("bid: %v", err)
+	}
+	if err := svc.lots.BuyNow(uint64(lot.ID), 3); err != nil {
+		t.Fatalf("buy now: %v", err)
+	}
+
+	completed := reloadLot(t, db, lot.ID)
+	if completed.Status != models.LotStatusCompleted || completed.Outcome != models.LotOutcomeSold || completed.WinnerID != 3 {
+		t.Fatalf("lot status %s outcome %s winner %d, want sold to 3", completed.Status, completed.Outcome, completed.WinnerID)
+	}
+	if completed.CurrentPrice != 1000 {
+		t.Fatalf("final price = %d, want the buy now price", completed.CurrentPrice)
+	}
+	if paid := wallet.paidBy(3); paid != 1000 {
+		t.Fatalf("buyer paid %d, want 1000", paid)
+	}
+	if held := wallet.heldBy(2, lot.ID); held != 0 {
+		t.Fatalf("previous leader still has %d on hold", held)
+	}
+}
+
+func TestBuyNowUnavailable(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	newFakeWallet(t)
+
+	withoutPrice := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+	if err := svc.lots.BuyNow(uint64(withoutPrice.ID), 2); !errors.Is(err, ErrPurchaseUnavailable) {
+		t.Fatalf("lot without buy now price: expected ErrPurchaseUnavailable, got %v", err)
+	}
+
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10, BuyNowPrice: 300})
+	if err := svc.lots.BuyNow(uint64(lot.ID), uint(lot.SellerID)); !errors.Is(err, ErrPurchaseUnavailable) {
+		t.Fatalf("seller buying own lot: expected ErrPurchaseUnavailable, got %v", err)
+	}
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 300, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("bid: %v", err)
+	}
+	if err := svc.lots.BuyNow(uint64(lot.ID), 3); !errors.Is(err, ErrPurchaseUnavailable) {
+		t.Fatalf("bids at the buy now price: expected ErrPurchaseUnavailable, got %v", err)
+	}
+}
+
+func createScheduledLot(t *testing.T, db *gorm.DB) *models.LotModel {
+	t.Helper()
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+	if err := db.Model(lot).Update("status", models.LotStatusScheduled).Error; err != nil {
+		t.Fatalf("schedule lot: %v", err)
+	}
+	return lot
+}
+
+func TestStartScheduledLotsSkipsConflictingLot(t *testing.T) {
+	db := newTestDB(t)
+	first := createScheduledLot(t, db)
+	second := createScheduledLot(t, db)
+
+	lotRepository := &conflictingLotRepository{LotRepository: repository.NewLotRepository(db), lotID: first.ID}
+	svc := NewLotService(lotRepository, repository.NewBidRepository(db), repository.NewProxyBidRepository(db), repository.NewOutboxRepository(db), repository.NewLotSettlementRepository(db), db, noopScheduler{})
+
+	if err := svc.StartScheduledLots(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if status := reloadLot(t, db, first.ID).Status; status != models.LotStatusScheduled {
+		t.Fatalf("conflicting lot status = %s, want it left scheduled", status)
+	}
+	if status := reloadLot(t, db, second.ID).Status; status != models.LotStatusActive {
+		t.Fatalf("second lot status = %s, want active", status)
+	}
+	if topics := outboxTopics(t, db, second.ID); len(topics) != 1 || topics[0] != "lot_started" {
+		t.Fatalf("outbox topics = %v, want a single lot_started", topics)
+	}
+}
+
+func TestForceCompleteLotRequiresActiveLot(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 200, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("bid: %v", err)
+	}
+
+	if err := svc.lots.ForceCompleteLot(uint64(lot.ID)); err != nil {
+		t.Fatalf("force complete: %v", err)
+	}
+	if err := svc.lots.ForceCompleteLot(uint64(lot.ID)); !errors.Is(err, ErrLotNotActive) {
+		t.Fatalf("completing twice: expected ErrLotNotActive, got %v", err)
+	}
+	if paid := wallet.paidBy(2); paid != 200 {
+		t.Fatalf("winner paid %d, want a single payout of 200", paid)
+	}
+	if topics := outboxTopics(t, db, lot.ID); len(topics) != 1 || topics[0] != "lot_completed" {
+		t.Fatalf("outbox topics = %v, want a single lot_completed", topics)
+	}
+
+	for _, status := range []models.LotStatus{models.LotStatusDraft, models.LotStatusCanceled} {
+		other := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+		if err := db.Model(other).Update("status", status).Error; err != nil {
+			t.Fatalf("set status: %v", err)
+		}
+		if err := svc.lots.ForceCompleteLot(uint64(other.ID)); !errors.Is(err, ErrLotNotActive) {
+			t.Fatalf("%s lot: expected ErrLotNotActive, got %v", status, err)
+		}
+		if got := reloadLot(t, db, other.ID).Status; got != status {
+			t.Fatalf("%s lot became %s", status, got)
+		}
+	}
+}
+
+func TestForceCompleteLotWhileSchedulerHoldsLock(t *testing.T) {
+	db := newTestDB(t)
+	lotRepository := newTestLotRepository(db)
+	svc := NewLotService(lotRepository, repository.NewBidRepository(db), repository.NewProxyBidRepository(db), repository.NewOutboxRepository(db), repository.NewLotSettlementRepository(db), db, noopScheduler{})
+	newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+
+	var forceErr error
+	acquired, err := lotRepository.TryLockLot(uint64(lot.ID), func() error {
+		forceErr = svc.ForceCompleteLot(uint64(lot.ID))
+		return nil
+	})
+	if err != nil || !acquired {
+		t.Fatalf("lock: acquired %v, err %v", acquired, err)
+	}
+	if !errors.Is(forceErr, ErrLotBusy) {
+		t.Fatalf("expected ErrLotBusy, got %v", forceErr)
+	}
+	if status := reloadLot(t, db, lot.ID).Status; status != models.LotStatusActive {
+		t.Fatalf("lot status = %s, want active", status)
+	}
+}
+
+func TestCompleteLotIfDueReschedulesExtendedLot(t *testing.T) {
+	db := newTestDB(t)
+	scheduler := &recordingScheduler{}
+	svc := NewLotService(newTestLotRepository(db), repository.NewBidRepository(db), repository.NewProxyBidRepository(db), repository.NewOutboxRepository(db), repository.NewLotSettlementRepository(db), db, scheduler)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+
+	if err := svc.CompleteLotIfDue(uint64(lot.ID)); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if status := reloadLot(t, db, lot.ID).Status; status != models.LotStatusActive {
+		t.Fatalf("lot status = %s, want active until its end date", status)
+	}
+	if endDate, ok := scheduler.scheduled(lot.ID); !ok || !endDate.Equal(lot.EndDate) {
+		t.Fatalf("scheduled end date = %s, want %s", endDate, lot.EndDate)
+	}
+}
+
+func TestCompleteLotIfDueSkipsLockedLot(t *testing.T) {
+	db := newTestDB(t)
+	lotRepository := newTestLotRepository(db)
+	svc := NewLotService(lotRepository, repository.NewBidRepository(db), repository.NewProxyBidRepository(db), repository.NewOutboxRepository(db), repository.NewLotSettlementRepository(db), db, noopScheduler{})
+	newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+	endLotIn(t, db, lot, -time.Second)
+
+	var replicaErr error
+	if _, err := lotRepository.TryLockLot(uint64(lot.ID), func() error {
+		replicaErr = svc.CompleteLotIfDue(uint64(lot.ID))
+		return nil
+	}); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if replicaErr != nil {
+		t.Fatalf("second replica: %v", replicaErr)
+	}
+	if status := reloadLot(t, db, lot.ID).Status; status != models.LotStatusActive {
+		t.Fatalf("lot status = %s, want it left to the replica holding the lock", status)
+	}
+
+	if err := svc.CompleteLotIfDue(uint64(lot.ID)); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if completed := reloadLot(t, db, lot.ID); completed.Status != models.LotStatusCompleted || completed.Outcome != models.LotOutcomeNoBids {
+		t.Fatalf("lot status %s outcome %s, want completed without bids", completed.Status, completed.Outcome)
+	}
+}
+
+func TestPayoutRetriedAfterWalletOutage(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{StartPrice: 100, MinStep: 10})
+	if err := svc.bids.CreateBid(&models.Bid{Amount: 200, UserID: 2, LotModelID: lot.ID}); err != nil {
+		t.Fatalf("bid: %v", err)
+	}
+
+	wallet.down.Store(true)
+	completed := completeNow(t, db, svc, lot)
+	if completed.Status != models.LotStatusCompleted || completed.WinnerID != 2 {
+		t.Fatalf("lot status %s winner %d, want completed by 2", completed.Status, completed.WinnerID)
+	}
+	settlements := lotSettlements(t, db, lot.ID)
+	if len(settlements) != 1 || settlements[0].Action != models.LotSettlementPayout || settlements[0].Status != models.LotSettlementStatusPending || settlements[0].LastError == "" {
+		t.Fatalf("settlements = %+v, want a pending payout with the failure", settlements)
+	}
+
+	wallet.down.Store(false)
+	if err := svc.lots.RetryLotSettlements(); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if paid := wallet.paidBy(2); paid != 0 {
+		t.Fatalf("payout retried before its backoff, paid %d", paid)
+	}
+
+	makeSettlementsDue(t, db)
+	if err := svc.lots.RetryLotSettlements(); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if paid := wallet.paidBy(2); paid != 200 {
+		t.Fatalf("winner paid %d, want 200", paid)
+	}
+	if status := lotSettlements(t, db, lot.ID)[0].Status; status != models.LotSettlementStatusDone {
+		t.Fatalf("payout status = %s, want done", status)
+	}
+}
+
+func TestCancelReleaseRetriedAfterWalletOutage(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{Type: models.LotTypeSealedFirstPrice, StartPrice: 100, MinStep: 10})
+	for _, userID := range []uint{2, 3} {
+		if err := svc.bids.CreateBid(&models.Bid{Amount: 200, UserID: userID, LotModelID: lot.ID}); err != nil {
+			t.Fatalf("bid of user %d: %v", userID, err)
+		}
+	}
+
+	wallet.down.Store(true)
+	if err := svc.lots.CancelLot(uint64(lot.ID), 99, true); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if status := reloadLot(t, db, lot.ID).Status; status != models.LotStatusCanceled {
+		t.Fatalf("lot status = %s, want canceled", status)
+	}
+
+	wallet.down.Store(false)
+	makeSettlementsDue(t, db)
+	if err := svc.lots.RetryLotSettlements(); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	for _, userID := range []uint{2, 3} {
+		if held := wallet.heldBy(userID, lot.ID); held != 0 {
+			t.Fatalf("user %d still has %d on hold", userID, held)
+		}
+	}
+	for _, settlement := range lotSettlements(t, db, lot.ID) {
+		if settlement.Status != models.LotSettlementStatusDone {
+			t.Fatalf("settlement %+v, want done", settlement)
+		}
+	}
+}
+
+func TestCreateLotDefaultsCurrency(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+
+	lot := &models.LotModel{Title: "lot", Description: "lot", StartPrice: 100, MinStep: 10, SellerID: 1}
+	if err := svc.lots.CreateLot(lot); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if currency := reloadLot(t, db, lot.ID).Currency; currency != models.DefaultCurrency {
+		t.Fatalf("currency = %q, want %s", currency, models.DefaultCurrency)
+	}
+}
+
+func TestBuyNowHoldsInLotCurrency(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestServices(t, db)
+	wallet := newFakeWallet(t)
+	lot := createActiveLot(t, db, models.LotModel{Currency: "EUR", StartPrice: 100, MinStep: 10, BuyNowPrice: 500})
+
+	if err := svc.lots.BuyNow(uint64(lot.ID), 2); err != nil {
+		t.Fatalf("buy now: %v", err)
+	}
+	if currency := wallet.holdCurrency(2, lot.ID); currency != "EUR" {
+		t.Fatalf("hold currency = %q, want EUR", currency)
+	}
+	if bids := lotBids(t, db, lot.ID); len(bids) != 1 || bids[0].Currency != "EUR" {
+		t.Fatalf("bids = %+v, want the purchase in EUR", bids)
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"auction-service/internal/models"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// lotSettlementLease must exceed the time a wallet call can take (see
+	// walletClient's timeout), so a settlement is never retried while the
+	// request that closed the lot is still making it.
+	lotSettlementLease      = time.Minute
+	lotSettlementMaxBackoff = 5 * time.Minute
+	lotSettlementRetryCap   = 100
+)
+
+func newLotSettlement(action models.LotSettlementAction, lot *models.LotModel, userID uint, amount int64, description, idempotencyKey string) models.LotSettlement {
+	return models.LotSettlement{
+		LotModelID:     lot.ID,
+		Action:         action,
+		UserID:         userID,
+		Amount:         amount,
+		SellerID:       lot.SellerID,
+		Description:    description,
+		IdempotencyKey: idempotencyKey,
+		Status:         models.LotSettlementStatusPending,
+		NextAttemptAt:  time.Now().UTC().Add(lotSettlementLease),
+	}
+}
+
+// lotReleaseSettlement releases the user's whole hold on the lot; it happens
+// at most once per user, whichever way the lot ends.
+func lotReleaseSettlement(lot *models.LotModel, userID uint, description string) models.LotSettlement {
+	return newLotSettlement(models.LotSettlementRelease, lot, userID, 0, description, lotReleaseKey(lot))
+}
+
+// queueSettlements persists settlements outside of a lot change and runs
+// them. If they cannot be stored they are still run once, and saved with the
+// outcome.
+func (s *lotService) queueSettlements(settlements []models.LotSettlement) {
+	if err := s.settlementRepository.CreateSettlements(settlements); err != nil {
+		log.Printf("WARNING: failed to queue lot settlements: %v", err)
+	}
+	s.runSettlements(settlements)
+}
+
+// runSettlements makes the wallet calls of settlements committed with the
+// lot change; failed ones are left to RetryLotSettlements.
+func (s *lotService) runSettlements(settlements []models.LotSettlement) {
+	for i := range settlements {
+		s.runSettlement(&settlements[i])
+	}
+}
+
+// RetryLotSettlements runs the settlements whose wallet call failed or was
+// never made because the process stopped after closing the lot.
+func (s *lotService) RetryLotSettlements() error {
+	for {
+		settlements, err := s.settlementRepository.ClaimDue(lotSettlementRetryCap, time.Now().UTC().Add(lotSettlementLease))
+		if err != nil {
+			return err
+		}
+		s.runSettlements(settlements)
+		if len(settlements) < lotSettlementRetryCap {
+			return nil
+		}
+	}
+}
+
+// runSettlement makes the wallet call and records its outcome. The call is
+// idempotent, so repeating one whose outcome was lost applies it at most
+// once. A rejected call will not succeed on retry and needs manual attention;
+// any other error is retried with exponential backoff.
+func (s *lotService) runSettlement(settlement *models.LotSettlement) {
+	var err error
+	switch settlement.Action {
+	case models.LotSettlementPayout:
+		err = payoutLotHold(settlement.UserID, settlement.LotModelID, settlement.Amount, settlement.SellerID, settlement.Description, settlement.IdempotencyKey)
+	case models.LotSettlementRelease:
+		err = releaseLotHold(settlement.UserID, settlement.LotModelID, settlement.Amount, settlement.Description, settlement.IdempotencyKey)
+	default:
+		err = fmt.Errorf("unknown lot settlement action %q", settlement.Action)
+	}
+
+	settlement.Attempts++
+	switch {
+	case err == nil:
+		settlement.Status = models.LotSettlementStatusDone
+		settlement.LastError = ""
+	case isWalletRejected(err):
+		log.Printf("WARNING: %s of user %d on lot %d was rejected: %v", settlement.Action, settlement.UserID, settlement.LotModelID, err)
+		settlement.Status = models.LotSettlementStatusFailed
+		settlement.LastError = err.Error()
+	default:
+		log.Printf("WARNING: %s of user %d on lot %d failed, retrying later: %v", settlement.Action, settlement.UserID, settlement.LotModelID, err)
+		settlement.LastError = err.Error()
+		backoff := time.Duration(1<<min(settlement.Attempts, 10)) * time.Second
+		settlement.NextAttemptAt = time.Now().UTC().Add(min(backoff, lotSettlementMaxBackoff))
+	}
+
+	if err := s.settlementRepository.SaveSettlement(settlement); err != nil {
+		log.Printf("WARNING: failed to save lot settlement %d: %v", settlement.ID, err)
+	}
+}
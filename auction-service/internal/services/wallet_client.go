@@ -0,0 +1,109 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// walletClient has a timeout so that a hung wallet call cannot outlive the
+// window after which stalled bid sagas are recovered.
+var walletClient = &http.Client{Timeout: 10 * time.Second}
+
+// walletRejectedError is returned when the wallet service answered with a
+// non-200 status. Wallet operations are transactional, so a rejection means
+// nothing was changed, unlike a transport error whose outcome is unknown.
+type walletRejectedError struct {
+	operation  string
+	statusCode int
+	body       string
+}
+
+func (e *walletRejectedError) Error() string {
+	return fmt.Sprintf("wallet %s returned %d: %s", e.operation, e.statusCode, e.body)
+}
+
+func isWalletRejected(err error) bool {
+	var rejected *walletRejectedError
+	return errors.As(err, &rejected)
+}
+
+// lotHoldReference is the wallet hold reference type used for lots: each
+// bidder has one hold per lot covering everything they have at stake on it.
+const lotHoldReference = "lot"
+
+// holdLotFunds freezes amount in the user's wallet of the lot's currency. The
+// wallet rejects it if the user's hold on the lot is in another currency.
+func holdLotFunds(userID, lotID uint, amount int64, currency, description, idempotencyKey string) error {
+	payload := lotHoldPayload(lotID, amount, description)
+	payload["currency"] = currency
+	return callWallet("holds", userID, payload, idempotencyKey)
+}
+
+// releaseLotHold releases amount from the user's hold on the lot, or the whole
+// hold when amount is zero.
+func releaseLotHold(userID, lotID uint, amount int64, description, idempotencyKey string) error {
+	return callWallet("holds/release", userID, lotHoldPayload(lotID, amount, description), idempotencyKey)
+}
+
+// payoutLotHold charges amount from the buyer's hold on the lot, releases the
+// rest of it and credits the seller, less the platform commission.
+func payoutLotHold(buyerID, lotID uint, amount int64, sellerID uint64, description, idempotencyKey string) error {
+	payload := lotHoldPayload(lotID, amount, description)
+	payload["seller_id"] = sellerID
+	return callWallet("holds/payout", buyerID, payload, idempotencyKey)
+}
+
+func lotHoldPayload(lotID uint, amount int64, description string) map[string]any {
+	payload := map[string]any{
+		"reference_type": lotHoldReference,
+		"reference_id":   strconv.FormatUint(uint64(lotID), 10),
+		"description":    description,
+	}
+	if amount > 0 {
+		payload["amount"] = amount
+	}
+	return payload
+}
+
+// callWallet sends a hold operation to the wallet's internal API under an
+// idempotency key derived from the bid or lot it belongs to, so retrying it
+// never applies it twice.
+func callWallet(operation string, userID uint, payload map[string]any, idempotencyKey string) error {
+	base := os.Getenv("WALLET_SERVICE_URL")
+	if base == "" {
+		return errors.New("wallet service url is not configured")
+	}
+	url := fmt.Sprintf("%s/internal/wallet/%s", base, operation)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s payload: %w", operation, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to build %s request: %w", operation, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-Id", fmt.Sprintf("%d", userID))
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	req.Header.Set("X-Internal-Token", os.Getenv("INTERNAL_API_TOKEN"))
+
+	resp, err := walletClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call wallet %s: %w", operation, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return &walletRejectedError{operation: operation, statusCode: resp.StatusCode, body: string(b)}
+	}
+	return nil
+}
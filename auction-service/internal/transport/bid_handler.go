@@ -2,7 +2,9 @@ package transport
 
 import (
 	"auction-service/internal/models"
+	"auction-service/internal/repository"
 	"auction-service/internal/services"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -44,7 +46,14 @@ func (h *BidHandler) CreateBid(c *gin.Context) {
 
 	err = h.service.CreateBid(&bidModel)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrBidConflict), errors.Is(err, repository.ErrLotVersionConflict):
+			status = http.StatusConflict
+		case errors.Is(err, services.ErrBidCurrencyMismatch):
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -73,7 +82,13 @@ func (h *BidHandler) GetAllBids(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lot id"})
 		return
 	}
-	bids, err := h.service.GetAllBidsByLot(lotIDUint)
+	var viewerID uint64
+	if uidStr := c.GetHeader("X-User-Id"); uidStr != "" {
+		if parsed, err := strconv.ParseUint(uidStr, 10, 64); err == nil {
+			viewerID = parsed
+		}
+	}
+	bids, err := h.service.GetAllBidsByLot(lotIDUint, viewerID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
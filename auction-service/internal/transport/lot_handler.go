@@ -4,19 +4,23 @@ import (
 	"auction-service/internal/models"
 	"auction-service/internal/repository"
 	"auction-service/internal/services"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type LotHandler struct {
 	service services.LotService
+	fx      services.FXRates
 }
 
-func NewLotHandler(service services.LotService) *LotHandler {
-	return &LotHandler{service: service}
+func NewLotHandler(service services.LotService, fx services.FXRates) *LotHandler {
+	return &LotHandler{service: service, fx: fx}
 }
 
 func (h *LotHandler) CreateLot(c *gin.Context) {
@@ -33,6 +37,36 @@ func (h *LotHandler) CreateLot(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Lot created successfully"})
 }
 
+func viewerID(c *gin.Context) uint64 {
+	if uidStr := c.GetHeader("X-User-Id"); uidStr != "" {
+		if parsed, err := strconv.ParseUint(uidStr, 10, 64); err == nil {
+			return parsed
+		}
+	}
+	return 0
+}
+
+// hideSecrets strips the reserve price from lots the viewer does not own
+// (everyone else only sees reserve_met) and masks other users' sealed bids.
+func hideSecrets(lots []models.LotModel, viewer uint64) {
+	for i := range lots {
+		if lots[i].SellerID != viewer {
+			lots[i].ReservePrice = 0
+		}
+		models.MaskSealedBids(&lots[i], lots[i].Bids, viewer)
+	}
+}
+
+// displayCurrency reads the optional display_currency the viewer wants lot
+// prices converted to.
+func (h *LotHandler) displayCurrency(c *gin.Context) (string, error) {
+	currency := strings.ToUpper(c.Query("display_currency"))
+	if currency != "" && !h.fx.Supports(currency) {
+		return "", services.ErrUnsupportedCurrency
+	}
+	return currency, nil
+}
+
 func paginationParams(c *gin.Context) (page int, limit int) {
 	page = 1
 	limit = 10
@@ -55,11 +89,25 @@ func parseFilters(c *gin.Context) *repository.LotFilters {
 
 	if statusStr := c.Query("status"); statusStr != "" {
 		status := models.LotStatus(statusStr)
-		if status == models.LotStatusDraft || status == models.LotStatusActive || status == models.LotStatusCompleted {
+		switch status {
+		case models.LotStatusDraft, models.LotStatusScheduled, models.LotStatusActive, models.LotStatusCompleted, models.LotStatusCanceled:
 			filters.Status = &status
 		}
 	}
 
+	if typeStr := c.Query("type"); typeStr != "" {
+		lotType := models.LotType(typeStr)
+		switch lotType {
+		case models.LotTypeEnglish, models.LotTypeDutch, models.LotTypeSealedFirstPrice, models.LotTypeSealedSecondPrice:
+			filters.Type = &lotType
+		}
+	}
+
+	if currency := c.Query("currency"); currency != "" {
+		currency = strings.ToUpper(currency)
+		filters.Currency = &currency
+	}
+
 	if minPriceStr := c.Query("min_price"); minPriceStr != "" {
 		if minPrice, err := strconv.ParseInt(minPriceStr, 10, 64); err == nil {
 			if minPrice > 0 {
@@ -88,7 +136,7 @@ func parseFilters(c *gin.Context) *repository.LotFilters {
 		}
 	}
 
-	if filters.Status == nil && filters.MinPrice == nil && filters.MaxPrice == nil && filters.MinEndDate == nil && filters.MaxEndDate == nil {
+	if filters.Status == nil && filters.Type == nil && filters.Currency == nil && filters.MinPrice == nil && filters.MaxPrice == nil && filters.MinEndDate == nil && filters.MaxEndDate == nil {
 		return nil
 	}
 
@@ -99,11 +147,20 @@ func (h *LotHandler) GetAllLots(c *gin.Context) {
 	page, limit := paginationParams(c)
 	offset := (page - 1) * limit
 	filters := parseFilters(c)
+	currency, err := h.displayCurrency(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	lots, err := h.service.GetAllLots(offset, limit, filters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	hideSecrets(lots, viewerID(c))
+	if currency != "" {
+		services.ConvertLotPrices(h.fx, lots, currency)
+	}
 	c.JSON(http.StatusOK, lots)
 }
 
@@ -139,6 +196,18 @@ func (h *LotHandler) UpdateLot(c *gin.Context) {
 	if updateReq.MinStep != nil {
 		lot.MinStep = *updateReq.MinStep
 	}
+	if updateReq.ReservePrice != nil {
+		lot.ReservePrice = *updateReq.ReservePrice
+	}
+	if updateReq.BuyNowPrice != nil {
+		lot.BuyNowPrice = *updateReq.BuyNowPrice
+	}
+	if updateReq.FloorPrice != nil {
+		lot.FloorPrice = *updateReq.FloorPrice
+	}
+	if updateReq.DropIntervalSeconds != nil {
+		lot.DropIntervalSeconds = *updateReq.DropIntervalSeconds
+	}
 	if updateReq.EndDate != nil {
 		lot.EndDate = *updateReq.EndDate
 	}
@@ -171,12 +240,22 @@ func (h *LotHandler) GetLotByID(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	currency, err := h.displayCurrency(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	lotModel, err := h.service.GetLotByID(idUint)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, lotModel)
+	lots := []models.LotModel{*lotModel}
+	hideSecrets(lots, viewerID(c))
+	if currency != "" {
+		services.ConvertLotPrices(h.fx, lots, currency)
+	}
+	c.JSON(http.StatusOK, lots[0])
 }
 
 func (h *LotHandler) GetAllLotsByUser(c *gin.Context) {
@@ -204,6 +283,7 @@ func (h *LotHandler) GetAllLotsByUser(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
+	hideSecrets(lots, viewerID(c))
 	c.JSON(http.StatusOK, lots)
 }
 
@@ -223,8 +303,94 @@ func (h *LotHandler) ForceComplete(c *gin.Context) {
 		return
 	}
 	if err := h.service.ForceCompleteLot(idUint); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrLotNotActive), errors.Is(err, services.ErrLotBusy), errors.Is(err, repository.ErrLotVersionConflict):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Lot forcibly completed"})
 }
+
+func (h *LotHandler) BuyNow(c *gin.Context) {
+	id := c.Param("id")
+	idUint, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lot id"})
+		return
+	}
+	buyerID := viewerID(c)
+	if buyerID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if err := h.service.BuyNow(idUint, uint(buyerID)); err != nil {
+		c.JSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Lot bought successfully"})
+}
+
+// purchaseErrorStatus maps the errors of buy-now and dutch acceptance: a lot
+// that cannot be bought or was changed concurrently is a conflict.
+func purchaseErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrPurchaseUnavailable), errors.Is(err, repository.ErrLotVersionConflict):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *LotHandler) AcceptDutchPrice(c *gin.Context) {
+	id := c.Param("id")
+	idUint, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lot id"})
+		return
+	}
+	buyerID := viewerID(c)
+	if buyerID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if err := h.service.AcceptDutchPrice(idUint, uint(buyerID)); err != nil {
+		c.JSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Price accepted successfully"})
+}
+
+func (h *LotHandler) CancelLot(c *gin.Context) {
+	id := c.Param("id")
+	idUint, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lot id"})
+		return
+	}
+	requesterID := viewerID(c)
+	if requesterID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	isAdmin := c.GetHeader("X-User-Role") == "admin"
+
+	if err := h.service.CancelLot(idUint, requesterID, isAdmin); err != nil {
+		switch {
+		case errors.Is(err, services.ErrLotCancelForbidden):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrLotHasBids):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Lot canceled successfully"})
+}
@@ -0,0 +1,19 @@
+package transport
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireVerifiedEmail lets through only users whose email is confirmed,
+// as reported by the gateway from the access token.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("X-User-Email-Verified") != "true" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "email is not verified"})
+			return
+		}
+		c.Next()
+	}
+}
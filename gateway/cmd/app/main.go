@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"gateway/internal/config"
 	"gateway/internal/middleware"
 	"gateway/internal/proxy"
 	"os"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -23,21 +25,37 @@ func main() {
 	walletProxy := proxy.NewReverseProxy(os.Getenv("WALLET_SERVICE_URL"), logger)
 	notificationProxy := proxy.NewReverseProxy(os.Getenv("NOTIFICATION_SERVICE_URL"), logger)
 
+	keys := middleware.NewJWKSCache(os.Getenv("AUTH_SERVICE_URL"), logger)
+	go keys.Run(context.Background(), 5*time.Minute)
+	revocations := middleware.NewRevocationList(os.Getenv("AUTH_SERVICE_URL"), os.Getenv("INTERNAL_API_TOKEN"), logger)
+	go revocations.Run(context.Background(), 5*time.Second)
+
 	r := gin.Default()
+	// The gateway is the edge: the client IP is the peer address.
+	if err := r.SetTrustedProxies(nil); err != nil {
+		logger.Error("failed to set trusted proxies", "err", err.Error())
+	}
 
 	r.Use(cors.Default())
 	r.Use(middleware.TimeoutMiddleware())
+	r.Use(middleware.ForwardedForMiddleware())
 
-	r.Any("/api/auth/*path", proxy.MakeProxyHandler(authProxy))
+	r.Any("/api/auth/*path", middleware.AuthRateLimitMiddleware(), proxy.MakeProxyHandler(authProxy))
+	r.GET("/.well-known/jwks.json", proxy.MakeProxyHandler(authProxy))
+	// Payment provider callbacks are authenticated by their signature.
+	r.POST("/api/webhooks/payments", proxy.MakeProxyHandler(walletProxy))
 
 	protected := r.Group("/")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(keys, revocations))
 	protected.Use(middleware.UserRateLimitMiddleware())
 	protected.Use(middleware.BidRateLimitMiddleware())
 
 	protected.Any("/api/users/:id/bids", proxy.MakeProxyHandler(auctionProxy))
 	protected.Any("/api/users/:id/lots", proxy.MakeProxyHandler(auctionProxy))
 	protected.Any("/api/users/me", proxy.MakeProxyHandler(authProxy))
+	protected.Any("/api/users/me/*path", proxy.MakeProxyHandler(authProxy))
+	protected.POST("/api/users/:id/unlock", proxy.MakeProxyHandler(authProxy))
+	protected.GET("/api/users/:id/login-attempts", proxy.MakeProxyHandler(authProxy))
 
 	protected.Any("/api/lots", proxy.MakeProxyHandler(auctionProxy))
 	protected.Any("/api/lots/*path", proxy.MakeProxyHandler(auctionProxy))
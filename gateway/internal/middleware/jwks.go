@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksMinRefetch limits how often a token with an unknown kid can trigger a
+// JWKS fetch.
+const jwksMinRefetch = 30 * time.Second
+
+type verificationKey struct {
+	alg string
+	key any
+}
+
+// JWKSCache holds the public keys of the auth service, which is the only
+// holder of the private signing keys. Keys are refreshed periodically and
+// on demand when a token names a kid that is not cached yet, e.g. right
+// after a key rotation.
+type JWKSCache struct {
+	url    string
+	client *http.Client
+	logger *slog.Logger
+
+	mutex       sync.RWMutex
+	keys        map[string]verificationKey
+	lastAttempt time.Time
+}
+
+func NewJWKSCache(authServiceURL string, logger *slog.Logger) *JWKSCache {
+	return &JWKSCache{
+		url:    strings.TrimRight(authServiceURL, "/") + "/.well-known/jwks.json",
+		client: &http.Client{Timeout: 2 * time.Second},
+		logger: logger,
+		keys:   map[string]verificationKey{},
+	}
+}
+
+// Run refreshes the keys until ctx is done. On a failed fetch the previous
+// keys are kept.
+func (j *JWKSCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.refresh(ctx); err != nil {
+			j.logger.Warn("failed to refresh jwks", "err", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Key returns the public key for kid, checking that alg is the algorithm the
+// key is published for.
+func (j *JWKSCache) Key(ctx context.Context, kid, alg string) (any, error) {
+	j.mutex.RLock()
+	key, ok := j.keys[kid]
+	canRefetch := time.Since(j.lastAttempt) >= jwksMinRefetch
+	j.mutex.RUnlock()
+
+	if !ok && canRefetch {
+		if err := j.refresh(ctx); err != nil {
+			j.logger.Warn("failed to refresh jwks", "err", err.Error())
+		}
+		j.mutex.RLock()
+		key, ok = j.keys[kid]
+		j.mutex.RUnlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if key.alg != alg {
+		return nil, fmt.Errorf("unexpected signing method: %v", alg)
+	}
+	return key.key, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+func (j *JWKSCache) refresh(ctx context.Context) error {
+	j.mutex.Lock()
+	j.lastAttempt = time.Now()
+	j.mutex.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+
+	keys := make(map[string]verificationKey, len(body.Keys))
+	for _, k := range body.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJSONWebKey(k)
+		if err != nil {
+			j.logger.Warn("skipping jwks key", "kid", k.Kid, "err", err.Error())
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	j.mutex.Lock()
+	j.keys = keys
+	j.mutex.Unlock()
+	return nil
+}
+
+func parseJSONWebKey(k jsonWebKey) (verificationKey, error) {
+	switch {
+	case k.Kty == "RSA" && k.Alg == "RS256":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return verificationKey{}, errors.New("invalid rsa exponent")
+		}
+		return verificationKey{alg: k.Alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}}, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519" && k.Alg == "EdDSA":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return verificationKey{}, errors.New("invalid ed25519 key size")
+		}
+		return verificationKey{alg: k.Alg, key: ed25519.PublicKey(x)}, nil
+	default:
+		return verificationKey{}, fmt.Errorf("unsupported key type %s/%s", k.Kty, k.Alg)
+	}
+}
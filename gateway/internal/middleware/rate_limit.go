@@ -48,8 +48,45 @@ func (tb *TokenBucket) Allow() bool {
 	return false
 }
 
+// full reports whether the bucket has been idle long enough to refill
+// completely, i.e. whether it is no different from a new one.
+func (tb *TokenBucket) full(now time.Time) bool {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	refilled := tb.tokens + now.Sub(tb.lastRefill).Seconds()*tb.refillRate
+	return refilled >= float64(tb.capacity)
+}
+
 var userBucket sync.Map
 var bidBucket sync.Map
+var authBucket sync.Map
+
+// authBucket is keyed by client IP, so it is swept of full buckets at most
+// once per authSweepInterval to keep it from growing with every address seen.
+const authSweepInterval = time.Minute
+
+var (
+	authSweepMu   sync.Mutex
+	authLastSweep time.Time
+)
+
+func sweepAuthBuckets(now time.Time) {
+	authSweepMu.Lock()
+	if now.Sub(authLastSweep) < authSweepInterval {
+		authSweepMu.Unlock()
+		return
+	}
+	authLastSweep = now
+	authSweepMu.Unlock()
+
+	authBucket.Range(func(key, value any) bool {
+		if value.(*TokenBucket).full(now) {
+			authBucket.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
 
 func getOrCreateBucket(store *sync.Map, userID uint64, capacity int, refillRate float64) *TokenBucket {
 	actual, _ := store.LoadOrStore(userID, NewTokenBucket(capacity, refillRate))
@@ -101,3 +138,30 @@ func BidRateLimitMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AuthRateLimitMiddleware limits unauthenticated auth requests (login,
+// password reset, ...) per client IP; the auth service additionally backs
+// off failed logins per email and IP.
+func AuthRateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sweepAuthBuckets(time.Now())
+		actual, _ := authBucket.LoadOrStore(c.ClientIP(), NewTokenBucket(20, 20.0/60.0))
+		if !actual.(*TokenBucket).Allow() {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": "too many requests",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ForwardedForMiddleware replaces a client supplied X-Forwarded-For with the
+// client IP, so upstream services can rely on it. The reverse proxy appends
+// the address of the hop itself.
+func ForwardedForMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Header.Set("X-Forwarded-For", c.ClientIP())
+		c.Next()
+	}
+}
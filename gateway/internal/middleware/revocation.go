@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RevocationList mirrors the access tokens the auth service revoked before
+// their expiry (logout, refresh token reuse). It is refreshed by polling, so
+// a revoked token is accepted for at most one poll interval.
+type RevocationList struct {
+	url    string
+	token  string
+	client *http.Client
+	logger *slog.Logger
+
+	mutex   sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewRevocationList polls the auth service's internal API, authenticating
+// with the shared INTERNAL_API_TOKEN.
+func NewRevocationList(authServiceURL, internalToken string, logger *slog.Logger) *RevocationList {
+	return &RevocationList{
+		url:     strings.TrimRight(authServiceURL, "/") + "/internal/revocations",
+		token:   internalToken,
+		client:  &http.Client{Timeout: 2 * time.Second},
+		logger:  logger,
+		revoked: map[string]time.Time{},
+	}
+}
+
+func (l *RevocationList) IsRevoked(jti string) bool {
+	if jti == "" {
+		return false
+	}
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	expiresAt, ok := l.revoked[jti]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// Run polls the auth service until ctx is done. On a failed poll the previous
+// list is kept.
+func (l *RevocationList) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := l.refresh(ctx); err != nil {
+			l.logger.Warn("failed to refresh token revocations", "err", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *RevocationList) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Internal-Token", l.token)
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Revocations []struct {
+			JTI       string    `json:"jti"`
+			ExpiresAt time.Time `json:"expires_at"`
+		} `json:"revocations"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+
+	revoked := make(map[string]time.Time, len(body.Revocations))
+	for _, r := range body.Revocations {
+		revoked[r.JTI] = r.ExpiresAt
+	}
+
+	l.mutex.Lock()
+	l.revoked = revoked
+	l.mutex.Unlock()
+	return nil
+}
@@ -5,10 +5,17 @@ import (
 )
 
 const (
-	NotificationTypeBidOutbid    = "bid_outbid"
-	NotificationTypeAuctionWon   = "auction_won"
-	NotificationTypeAuctionLost  = "auction_lost"
-	NotificationTypeAuctionEnded = "auction_ended"
+	NotificationTypeBidOutbid     = "bid_outbid"
+	NotificationTypeAuctionWon    = "auction_won"
+	NotificationTypeAuctionLost   = "auction_lost"
+	NotificationTypeAuctionEnded  = "auction_ended"
+	NotificationTypeReserveNotMet = "reserve_not_met"
+)
+
+const (
+	LotOutcomeSold          = "sold"
+	LotOutcomeReserveNotMet = "reserve_not_met"
+	LotOutcomeNoBids        = "no_bids"
 )
 
 type Notification struct {
@@ -29,10 +36,13 @@ type BidPlacedEvent struct {
 }
 
 type LotCompletedEvent struct {
-	LotID      uint64   `json:"lot_id"`
-	WinnerID   uint64   `json:"winner"`
-	FinalPrice int64    `json:"final_price"`
-	LoserIDs   []uint64 `json:"loser_ids"`
+	LotID       uint64   `json:"lot_id"`
+	WinnerID    uint64   `json:"winner"`
+	FinalPrice  int64    `json:"final_price"`
+	LoserIDs    []uint64 `json:"loser_ids"`
+	Outcome     string   `json:"outcome"`
+	SellerID    uint64   `json:"seller_id"`
+	TopBidderID uint64   `json:"top_bidder_id"`
 }
 
 type FilterNotification struct {
@@ -33,6 +33,13 @@ func (s *notificationService) Create(req *models.Notification) error {
 }
 
 func (s *notificationService) CreateWinnerLoserNotification(event *models.LotCompletedEvent) error {
+	if event.Outcome == models.LotOutcomeReserveNotMet {
+		return s.createReserveNotMetNotifications(event)
+	}
+	if event.WinnerID == 0 {
+		s.logger.Info("skip auction_won notification: no winner", "lot_id", event.LotID, "outcome", event.Outcome)
+		return nil
+	}
 
 	winnerNotif := &models.Notification{
 		UserID:  event.WinnerID,
@@ -64,6 +71,39 @@ func (s *notificationService) CreateWinnerLoserNotification(event *models.LotCom
 	return nil
 }
 
+func (s *notificationService) createReserveNotMetNotifications(event *models.LotCompletedEvent) error {
+	if event.SellerID != 0 {
+		sellerNotif := &models.Notification{
+			UserID:  event.SellerID,
+			LotID:   event.LotID,
+			Type:    models.NotificationTypeReserveNotMet,
+			Title:   "Резервная цена не достигнута",
+			Message: fmt.Sprintf("Аукцион завершён без победителя: последняя ставка %d ниже резервной цены", event.FinalPrice),
+		}
+		if err := s.repo.CreateNotification(sellerNotif); err != nil {
+			s.logger.Error("create notification failed", "err", err.Error(), "user_id", sellerNotif.UserID)
+			return err
+		}
+		s.logger.Info("seller notification created", "id", sellerNotif.ID, "user_id", sellerNotif.UserID)
+	}
+
+	if event.TopBidderID != 0 {
+		bidderNotif := &models.Notification{
+			UserID:  event.TopBidderID,
+			LotID:   event.LotID,
+			Type:    models.NotificationTypeReserveNotMet,
+			Title:   "Резервная цена не достигнута",
+			Message: fmt.Sprintf("Ваша ставка %d не достигла резервной цены, средства разморожены", event.FinalPrice),
+		}
+		if err := s.repo.CreateNotification(bidderNotif); err != nil {
+			s.logger.Error("create notification failed", "err", err.Error(), "user_id", bidderNotif.UserID)
+			return err
+		}
+		s.logger.Info("top bidder notification created", "id", bidderNotif.ID, "user_id", bidderNotif.UserID)
+	}
+	return nil
+}
+
 func (s *notificationService) CreateBidPlacedNotification(event *models.BidPlacedEvent) error {
 	if event.PreviousLeaderID == 0 {
 		s.logger.Info("skip bid_outbid notification: no previous leader", "lot_id", event.LotID)
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"log/slog"
 	"os"
+	"time"
 
 	"user-service/internal/config"
 	"user-service/internal/repository"
@@ -17,15 +19,42 @@ func main() {
 
 	userRepo := repository.NewUserRepository(db, logger)
 	walletRepo := repository.NewWalletRepository(db, logger)
+	sessionRepo := repository.NewSessionRepository(db, logger)
+	userTokenRepo := repository.NewUserTokenRepository(db, logger)
+	mfaRepo := repository.NewMFARepository(db, logger)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(db, logger)
 
-	jwt := services.NewJWTService()
-	userSvc := services.NewUserService(userRepo, jwt, logger)
-	walletSvc := services.NewWalletService(walletRepo, db, logger)
+	jwt := services.NewJWTService(config.LoadSigningKeys())
+	sessionSvc := services.NewSessionService(sessionRepo, userRepo, jwt, db, config.LoadSessionConfig(), logger)
+	mailCfg := config.LoadMailConfig()
+	loginGuard := services.NewLoginGuard(loginAttemptRepo, db, config.LoadLoginGuardConfig(), logger)
+	userSvc := services.NewUserService(
+		userRepo, userTokenRepo, mfaRepo, sessionSvc, loginGuard,
+		newMailSender(mailCfg, logger), mailCfg, config.LoadMFAConfig(), db, logger,
+	)
+	payments := services.NewMockPaymentProvider(os.Getenv("PAYMENT_WEBHOOK_SECRET"))
+	walletSvc := services.NewWalletService(walletRepo, db, config.LoadSettlementConfig(), payments, services.NewFakePayoutProvider(), logger)
 
-	authHandler := transport.NewAuthHandler(userSvc, jwt, logger)
+	if err := walletSvc.OpenLedgerBalances(); err != nil {
+		logger.Error("failed to open ledger balances", "err", err.Error())
+	}
+
+	go processWithdrawals(walletSvc, logger)
+	go purgeExpired(sessionSvc, loginGuard, logger)
+
+	authHandler := transport.NewAuthHandler(userSvc, sessionSvc, jwt, logger)
 	walletHandler := transport.NewWalletHandler(userSvc, walletSvc, logger)
 
-	r := transport.SetupRouter(logger, authHandler, jwt, walletHandler)
+	internalToken := os.Getenv("INTERNAL_API_TOKEN")
+	if internalToken == "" {
+		logger.Warn("INTERNAL_API_TOKEN is not set, internal routes are disabled")
+	}
+
+	r := transport.SetupRouter(logger, authHandler, jwt, walletHandler, internalToken)
+	if err := r.SetTrustedProxies(config.LoadTrustedProxies()); err != nil {
+		logger.Error("invalid TRUSTED_PROXIES", "err", err.Error())
+		os.Exit(1)
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -38,3 +67,52 @@ func main() {
 		logger.Error("failed to run service", "err", err.Error())
 	}
 }
+
+// newMailSender writes emails to MAIL_OUTBOX_DIR when it is set and keeps
+// them in memory otherwise.
+func newMailSender(cfg config.MailConfig, logger *slog.Logger) services.MailSender {
+	if cfg.OutboxDir == "" {
+		logger.Warn("MAIL_OUTBOX_DIR is not set, emails are not delivered")
+		return services.NewMemoryMailSender()
+	}
+	sender, err := services.NewFileMailSender(cfg.OutboxDir)
+	if err != nil {
+		logger.Error("failed to open mail outbox", "dir", cfg.OutboxDir, "err", err.Error())
+		os.Exit(1)
+	}
+	return sender
+}
+
+// processWithdrawals retries withdrawals the payout provider has not settled
+// yet, e.g. after a provider outage or a restart.
+func processWithdrawals(walletSvc services.WalletService, logger *slog.Logger) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := walletSvc.ProcessPendingWithdrawals(); err != nil {
+			logger.Error("failed to process pending withdrawals", "err", err.Error())
+		}
+	}
+}
+
+// purgeExpired deletes records that are no longer needed: revocations of
+// access tokens that have expired anyway, old login attempts and idle login
+// throttles.
+func purgeExpired(sessionSvc services.SessionService, loginGuard services.LoginGuard, logger *slog.Logger) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if deleted, err := sessionSvc.PurgeExpiredRevocations(); err != nil {
+			logger.Error("failed to purge expired revocations", "err", err.Error())
+		} else {
+			logger.Info("purged expired revocations", "count", deleted)
+		}
+		if deleted, err := loginGuard.Purge(); err != nil {
+			logger.Error("failed to purge login attempts", "err", err.Error())
+		} else {
+			logger.Info("purged login attempts and throttles", "count", deleted)
+		}
+	}
+}
@@ -16,12 +16,55 @@ func InitDatabase() *gorm.DB {
 		dsl = "host=localhost user=adamgowz password=9555 dbname=auction/wallet port=5432 sslmode=disable"
 	}
 
-	db, err := gorm.Open(postgres.Open(dsl), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsl), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := db.AutoMigrate(&models.User{}, &models.Wallet{}, &models.Transaction{}); err != nil {
+	// Users registered before email verification existed are treated as
+	// verified, so the cut-over does not lock them out of bidding.
+	backfillEmailVerified := db.Migrator().HasTable(&models.User{}) && !db.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
+
+	if err := db.AutoMigrate(&models.User{}, &models.Wallet{}, &models.Transaction{}, &models.Hold{},
+		&models.LedgerAccount{}, &models.LedgerEntry{}, &models.LedgerPosting{}, &models.Withdrawal{}, &models.PaymentIntent{},
+		&models.RefreshToken{}, &models.RevokedToken{}, &models.UserToken{}, &models.MFARecoveryCode{},
+		&models.LoginAttempt{}, &models.LoginThrottle{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if backfillEmailVerified {
+		if err := db.Model(&models.User{}).Where("email_verified_at IS NULL").Update("email_verified_at", gorm.Expr("created_at")).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Transaction history is paged by (created_at, id) per user.
+	if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_transactions_history ON transactions (user_id, created_at DESC, id DESC)`).Error; err != nil {
+		log.Fatal(err)
+	}
+
+	// Ledger accounts used to be unique per owner regardless of currency.
+	if db.Migrator().HasIndex(&models.LedgerAccount{}, "idx_ledger_accounts_owner") {
+		if err := db.Migrator().DropIndex(&models.LedgerAccount{}, "idx_ledger_accounts_owner"); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Frozen balances predating holds are moved into a "legacy" hold per
+	// wallet, since FrozenBalance is now recomputed from active holds.
+	if err := db.Exec(`
+		INSERT INTO holds (created_at, updated_at, wallet_id, user_id, reference_type, reference_id, currency, amount, status)
+		SELECT now(), now(), w.id, w.user_id, 'legacy', w.id::text, w.currency,
+			w.frozen_balance - COALESCE((SELECT SUM(h.amount) FROM holds h WHERE h.wallet_id = w.id AND h.status = ?), 0), ?
+		FROM wallets w
+		WHERE w.deleted_at IS NULL
+			AND w.frozen_balance > COALESCE((SELECT SUM(h.amount) FROM holds h WHERE h.wallet_id = w.id AND h.status = ?), 0)
+		ON CONFLICT DO NOTHING`,
+		models.HoldActive, models.HoldActive, models.HoldActive,
+	).Error; err != nil {
+		log.Fatal(err)
+	}
+	if err := assignLegacyHolds(db); err != nil {
 		log.Fatal(err)
 	}
 
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SigningKey is a private key access tokens are signed with, identified in
+// the token header and in the JWKS by KID.
+type SigningKey struct {
+	KID string
+	Key crypto.Signer
+}
+
+// SigningKeys holds every key that is still published for verification;
+// tokens are signed with the one named ActiveKID. To rotate, add a new key
+// file and make it active, then remove the old file once the access tokens
+// it signed have expired.
+type SigningKeys struct {
+	Keys      []SigningKey
+	ActiveKID string
+}
+
+// LoadSigningKeys reads PEM encoded RSA (2048 bits or more) or Ed25519
+// private keys from JWT_KEYS_DIR, one "<kid>.pem" file per key. JWT_ACTIVE_KID
+// selects the signing key, by default the last kid in lexical order. Without
+// JWT_KEYS_DIR an ephemeral Ed25519 key is generated, which is only suitable
+// for local development: tokens do not survive a restart.
+func LoadSigningKeys() SigningKeys {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		log.Print("JWT_KEYS_DIR is not set, signing tokens with an ephemeral key")
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return SigningKeys{Keys: []SigningKey{{KID: "ephemeral", Key: key}}, ActiveKID: "ephemeral"}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(files)
+
+	var keys SigningKeys
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		key, err := parseSigningKey(raw)
+		if err != nil {
+			log.Fatalf("invalid signing key %s: %v", file, err)
+		}
+		keys.Keys = append(keys.Keys, SigningKey{KID: kid, Key: key})
+	}
+	if len(keys.Keys) == 0 {
+		log.Fatalf("no signing keys (*.pem) in JWT_KEYS_DIR %q", dir)
+	}
+
+	keys.ActiveKID = keys.Keys[len(keys.Keys)-1].KID
+	if active := os.Getenv("JWT_ACTIVE_KID"); active != "" {
+		found := false
+		for _, k := range keys.Keys {
+			found = found || k.KID == active
+		}
+		if !found {
+			log.Fatalf("invalid JWT_ACTIVE_KID %q: no such key in %s", active, dir)
+		}
+		keys.ActiveKID = active
+	}
+	return keys
+}
+
+func parseSigningKey(raw []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	var key any
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA key shorter than 2048 bits")
+		}
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+}
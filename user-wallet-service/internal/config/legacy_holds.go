@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+
+	models "user-service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// legacyLotHold is the bid of an active lot's leader, frozen before holds
+// existed: back then only the leading bid of a lot stayed frozen.
+type legacyLotHold struct {
+	LotID  uint
+	UserID uint
+	Amount int64
+}
+
+// assignLegacyHolds moves the frozen funds of bids placed before holds from
+// the wallet's legacy hold to a hold referencing their lot, so auction-service
+// can release or pay them out by lot like any other bid. It reads the auction
+// tables, which share the database, and does nothing if they are absent. A
+// lot that already has a hold for its leader is left alone, which makes the
+// step safe to repeat on every start.
+func assignLegacyHolds(db *gorm.DB) error {
+	if !db.Migrator().HasTable("lot_models") || !db.Migrator().HasTable("bids") {
+		return nil
+	}
+
+	var bids []legacyLotHold
+	if err := db.Raw(`
+		SELECT l.id AS lot_id, b.user_id, b.amount
+		FROM lot_models l
+		JOIN bids b ON b.id = l.current_bid_id
+		WHERE l.status = 'active' AND l.deleted_at IS NULL
+		ORDER BY l.id`).Scan(&bids).Error; err != nil {
+		return err
+	}
+
+	for _, bid := range bids {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var legacy models.Hold
+			err := tx.Where("user_id = ? AND reference_type = ? AND status = ? AND amount > 0", bid.UserID, models.HoldReferenceLegacy, models.HoldActive).
+				Order("id").First(&legacy).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			lotID := strconv.FormatUint(uint64(bid.LotID), 10)
+			var existing int64
+			if err := tx.Model(&models.Hold{}).
+				Where("user_id = ? AND reference_type = ? AND reference_id = ?", bid.UserID, "lot", lotID).
+				Count(&existing).Error; err != nil {
+				return err
+			}
+			if existing > 0 {
+				return nil
+			}
+
+			moved := min(bid.Amount, legacy.Amount)
+			legacy.Amount -= moved
+			if legacy.Amount == 0 {
+				legacy.Status = models.HoldReleased
+			}
+			if err := tx.Save(&legacy).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.Hold{
+				WalletID:      legacy.WalletID,
+				UserID:        bid.UserID,
+				ReferenceType: "lot",
+				ReferenceID:   lotID,
+				Currency:      legacy.Currency,
+				Amount:        moved,
+				Status:        models.HoldActive,
+			}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// LoginGuardConfig limits password guessing. Failed logins are counted per
+// email and per client IP; after FreeAttempts failures every further one
+// delays the next login by BaseDelay, doubled each time up to MaxDelay, and
+// LockoutAttempts failures lock the key for LockoutDuration. Counters reset
+// after FailureWindow without failures, and for an email on a successful
+// login.
+type LoginGuardConfig struct {
+	EmailFreeAttempts    int
+	EmailLockoutAttempts int
+	// A single IP serves many users behind NAT, so it gets more attempts.
+	IPFreeAttempts    int
+	IPLockoutAttempts int
+	BaseDelay         time.Duration
+	MaxDelay          time.Duration
+	LockoutDuration   time.Duration
+	FailureWindow     time.Duration
+	// AttemptRetention is how long the audit log of login attempts is kept.
+	AttemptRetention time.Duration
+}
+
+func LoadLoginGuardConfig() LoginGuardConfig {
+	cfg := LoginGuardConfig{
+		EmailFreeAttempts:    3,
+		EmailLockoutAttempts: 10,
+		IPFreeAttempts:       10,
+		IPLockoutAttempts:    100,
+		BaseDelay:            time.Second,
+		MaxDelay:             5 * time.Minute,
+		LockoutDuration:      durationFromEnv("LOGIN_LOCKOUT_DURATION", 30*time.Minute),
+		FailureWindow:        time.Hour,
+		AttemptRetention:     durationFromEnv("LOGIN_ATTEMPT_RETENTION", 90*24*time.Hour),
+	}
+	if raw := os.Getenv("LOGIN_LOCKOUT_ATTEMPTS"); raw != "" {
+		attempts, err := strconv.Atoi(raw)
+		if err != nil || attempts <= cfg.EmailFreeAttempts {
+			log.Fatalf("invalid LOGIN_LOCKOUT_ATTEMPTS %q: must be greater than %d", raw, cfg.EmailFreeAttempts)
+		}
+		cfg.EmailLockoutAttempts = attempts
+	}
+	return cfg
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// MailConfig controls the emails sent for password reset and email
+// verification. Links in them point to AppBaseURL.
+type MailConfig struct {
+	// OutboxDir makes the service write emails as files instead of keeping
+	// them in memory; there is no SMTP sender yet.
+	OutboxDir            string
+	AppBaseURL           string
+	PasswordResetTTL     time.Duration
+	EmailVerificationTTL time.Duration
+}
+
+func LoadMailConfig() MailConfig {
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	return MailConfig{
+		OutboxDir:            os.Getenv("MAIL_OUTBOX_DIR"),
+		AppBaseURL:           strings.TrimRight(baseURL, "/"),
+		PasswordResetTTL:     durationFromEnv("PASSWORD_RESET_TTL", time.Hour),
+		EmailVerificationTTL: durationFromEnv("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+	}
+}
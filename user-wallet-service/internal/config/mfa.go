@@ -0,0 +1,32 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// MFAConfig controls TOTP two factor authentication.
+type MFAConfig struct {
+	// Issuer is the account name prefix shown by authenticator apps.
+	Issuer string
+	// ChallengeTTL bounds the time between the password and the code step
+	// of a login.
+	ChallengeTTL time.Duration
+	// LockoutAttempts wrong codes in a row block code checks for
+	// LockoutDuration.
+	LockoutAttempts int
+	LockoutDuration time.Duration
+}
+
+func LoadMFAConfig() MFAConfig {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "Auction"
+	}
+	return MFAConfig{
+		Issuer:          issuer,
+		ChallengeTTL:    durationFromEnv("MFA_CHALLENGE_TTL", 5*time.Minute),
+		LockoutAttempts: 5,
+		LockoutDuration: durationFromEnv("MFA_LOCKOUT_DURATION", 15*time.Minute),
+	}
+}
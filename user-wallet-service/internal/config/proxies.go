@@ -0,0 +1,19 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// LoadTrustedProxies reads TRUSTED_PROXIES, the comma separated IPs or CIDRs
+// of the gateway. Only requests from them may set the client IP through
+// X-Forwarded-For; without it the client IP is always the peer address.
+func LoadTrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
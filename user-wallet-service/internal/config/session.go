@@ -0,0 +1,33 @@
+package config
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// SessionConfig sets the lifetime of issued tokens. Access tokens are kept
+// short because the gateway only learns about revocations by polling.
+type SessionConfig struct {
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+func LoadSessionConfig() SessionConfig {
+	return SessionConfig{
+		AccessTTL:  durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTTL: durationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+	}
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil || parsed <= 0 {
+		log.Fatalf("invalid %s %q: must be a positive duration", key, raw)
+	}
+	return parsed
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// SettlementConfig controls how a captured lot payment is split between the
+// seller and the platform. The commission is booked to the platform_revenue
+// ledger account; there is no platform wallet any more, so PLATFORM_USER_ID
+// is ignored.
+type SettlementConfig struct {
+	// CommissionBPS is the platform commission in basis points (1/100 of a
+	// percent) of the final price.
+	CommissionBPS int64
+}
+
+func LoadSettlementConfig() SettlementConfig {
+	var cfg SettlementConfig
+	if raw := os.Getenv("PLATFORM_COMMISSION_BPS"); raw != "" {
+		bps, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || bps < 0 || bps > 10000 {
+			log.Fatalf("invalid PLATFORM_COMMISSION_BPS %q: must be between 0 and 10000", raw)
+		}
+		cfg.CommissionBPS = bps
+	}
+	if os.Getenv("PLATFORM_USER_ID") != "" {
+		log.Print("PLATFORM_USER_ID is ignored: the platform commission is booked to the platform_revenue ledger account")
+	}
+	return cfg
+}
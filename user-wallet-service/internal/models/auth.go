@@ -3,7 +3,6 @@ package models
 type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=6"`
-	Role     Role   `json:"role"`
 }
 
 type LoginRequest struct {
@@ -11,9 +10,35 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+// AuthResponse carries a short-lived access token in Token, valid for
+// ExpiresIn seconds, and the refresh token that renews it.
 type AuthResponse struct {
-	Token string     `json:"token"`
-	User  SimpleUser `json:"user"`
+	Token        string     `json:"token"`
+	RefreshToken string     `json:"refresh_token"`
+	ExpiresIn    int64      `json:"expires_in"`
+	User         SimpleUser `json:"user"`
 }
 
 type SimpleUser struct {
@@ -21,4 +46,7 @@ type SimpleUser struct {
 	FullName string `json:"full_name"`
 	Email    string `json:"email"`
 	Role     Role   `json:"role"`
+
+	EmailVerified    bool `json:"email_verified"`
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
 }
@@ -0,0 +1,67 @@
+package models
+
+import "strconv"
+
+type HoldStatus string
+
+const (
+	HoldActive   HoldStatus = "active"
+	HoldReleased HoldStatus = "released"
+	HoldCaptured HoldStatus = "captured"
+)
+
+// Hold is money frozen for a specific purpose, e.g. a bid on a lot. A user
+// has at most one hold per reference; the wallet's FrozenBalance is the sum of
+// the user's active holds in that wallet. A hold keeps the currency it was
+// opened in.
+type Hold struct {
+	Base
+
+	WalletID uint    `json:"wallet_id" gorm:"index;not null"`
+	Wallet   *Wallet `json:"-" gorm:"foreignKey:WalletID;references:ID"`
+
+	UserID        uint   `json:"user_id" gorm:"not null;uniqueIndex:idx_holds_reference,priority:1"`
+	ReferenceType string `json:"reference_type" gorm:"size:32;not null;uniqueIndex:idx_holds_reference,priority:2"`
+	ReferenceID   string `json:"reference_id" gorm:"size:64;not null;uniqueIndex:idx_holds_reference,priority:3"`
+
+	Currency       string     `json:"currency" gorm:"size:3;not null;default:RUB"`
+	Amount         int64      `json:"amount" gorm:"not null"`
+	CapturedAmount int64      `json:"captured_amount" gorm:"not null;default:0"`
+	Status         HoldStatus `json:"status" gorm:"type:varchar(16);not null;index"`
+}
+
+type HoldReference struct {
+	ReferenceType string `json:"reference_type" binding:"required,max=32"`
+	ReferenceID   string `json:"reference_id" binding:"required,max=64"`
+}
+
+// HoldReferenceLegacy is the reference type of the per-wallet hold holding
+// funds frozen before holds existed and through the deprecated /freeze API.
+const HoldReferenceLegacy = "legacy"
+
+func LegacyHoldReference(walletID uint) HoldReference {
+	return HoldReference{ReferenceType: HoldReferenceLegacy, ReferenceID: strconv.FormatUint(uint64(walletID), 10)}
+}
+
+type HoldForRequest struct {
+	HoldReference
+	Amount      int64  `json:"amount" binding:"required,gt=0"`
+	Currency    string `json:"currency" binding:"omitempty,iso4217"`
+	Description string `json:"description"`
+}
+
+// HoldPayoutForRequest captures a buyer's hold and pays it out to the seller.
+type HoldPayoutForRequest struct {
+	HoldReference
+	Amount      int64  `json:"amount" binding:"required,gt=0"`
+	SellerID    uint   `json:"seller_id" binding:"required"`
+	Description string `json:"description"`
+}
+
+// HoldSettleForRequest releases or captures a hold; without amount the whole
+// held amount is used.
+type HoldSettleForRequest struct {
+	HoldReference
+	Amount      int64  `json:"amount" binding:"omitempty,gt=0"`
+	Description string `json:"description"`
+}
@@ -0,0 +1,18 @@
+package models
+
+// JSONWebKey is a public verification key as published in the JWKS (RFC 7517).
+// N and E are set for RSA keys, Crv and X for Ed25519 (OKP) keys.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JSONWebKey `json:"keys"`
+}
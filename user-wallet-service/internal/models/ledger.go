@@ -0,0 +1,83 @@
+package models
+
+type LedgerAccountType string
+
+const (
+	// LedgerUserAvailable and LedgerUserHeld belong to a user; together they
+	// are the ledger side of Wallet.Balance, LedgerUserHeld alone of
+	// Wallet.FrozenBalance.
+	LedgerUserAvailable LedgerAccountType = "user_available"
+	LedgerUserHeld      LedgerAccountType = "user_held"
+	// LedgerPlatformRevenue and LedgerExternalCash are system accounts
+	// (UserID 0). External cash is the contra account of money entering or
+	// leaving the platform, so it carries the negative of all user funds.
+	LedgerPlatformRevenue LedgerAccountType = "platform_revenue"
+	LedgerExternalCash    LedgerAccountType = "external_cash"
+	// LedgerPayoutsPending holds withdrawals sent to the payout provider until
+	// it reports them completed or failed.
+	LedgerPayoutsPending LedgerAccountType = "payouts_pending"
+)
+
+// LedgerEntryOpening is the kind of the entry that carries a wallet's balance
+// from before the ledger existed.
+const LedgerEntryOpening TransactionType = "opening_balance"
+
+// LedgerAccount is kept per currency: each user and system account exists
+// once for every currency it has seen, and an entry never mixes currencies.
+type LedgerAccount struct {
+	Base
+	Type     LedgerAccountType `json:"type" gorm:"type:varchar(32);not null;uniqueIndex:idx_ledger_accounts_owner_currency,priority:1"`
+	UserID   uint              `json:"user_id" gorm:"not null;default:0;uniqueIndex:idx_ledger_accounts_owner_currency,priority:2"`
+	Currency string            `json:"currency" gorm:"size:3;not null;default:RUB;uniqueIndex:idx_ledger_accounts_owner_currency,priority:3"`
+}
+
+// LedgerEntry is one balanced operation: the amounts of its postings sum to
+// zero. TransactionID points at the wallet transaction it was written for.
+type LedgerEntry struct {
+	Base
+	Kind          TransactionType `json:"kind" gorm:"type:varchar(32);not null"`
+	TransactionID uint            `json:"transaction_id" gorm:"index"`
+	Description   string          `json:"description" gorm:"size:512"`
+	Postings      []LedgerPosting `json:"postings" gorm:"foreignKey:EntryID"`
+}
+
+// LedgerPosting changes an account balance by the signed Amount. Balances
+// are kept from the point of view of the account owner, so a user's money is
+// positive and external cash, its counterpart, negative.
+type LedgerPosting struct {
+	Base
+	EntryID   uint  `json:"entry_id" gorm:"index;not null"`
+	AccountID uint  `json:"account_id" gorm:"index;not null"`
+	Amount    int64 `json:"amount" gorm:"not null"`
+}
+
+// LedgerBalance is the sum of postings of one account.
+type LedgerBalance struct {
+	Type     LedgerAccountType `json:"type"`
+	UserID   uint              `json:"user_id"`
+	Currency string            `json:"currency"`
+	Balance  int64             `json:"balance"`
+}
+
+type ReconciliationMismatch struct {
+	UserID        uint   `json:"user_id"`
+	Currency      string `json:"currency"`
+	Balance       int64  `json:"balance"`
+	LedgerBalance int64  `json:"ledger_balance"`
+	FrozenBalance int64  `json:"frozen_balance"`
+	LedgerHeld    int64  `json:"ledger_held"`
+}
+
+// ReconciliationReport proves that wallets, as projections, match the
+// ledger: every entry is balanced and every wallet equals its accounts.
+// Totals are keyed by currency.
+type ReconciliationReport struct {
+	Balanced          bool                     `json:"balanced"`
+	WalletsChecked    int                      `json:"wallets_checked"`
+	PostingsTotal     map[string]int64         `json:"postings_total"`
+	PlatformRevenue   map[string]int64         `json:"platform_revenue"`
+	ExternalCash      map[string]int64         `json:"external_cash"`
+	PayoutsPending    map[string]int64         `json:"payouts_pending"`
+	UnbalancedEntries []uint                   `json:"unbalanced_entries"`
+	Mismatches        []ReconciliationMismatch `json:"mismatches"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+type LoginResult string
+
+const (
+	LoginSucceeded          LoginResult = "success"
+	LoginMFARequired        LoginResult = "mfa_required"
+	LoginInvalidCredentials LoginResult = "invalid_credentials"
+	LoginInvalidMFACode     LoginResult = "invalid_mfa_code"
+	LoginLocked             LoginResult = "locked"
+)
+
+// LoginAttempt is the audit log entry of one login or MFA step. UserID is
+// empty when the email is not registered.
+type LoginAttempt struct {
+	Base
+	Email  string      `json:"email" gorm:"size:255;not null;index"`
+	IP     string      `json:"ip" gorm:"size:64;not null;index"`
+	UserID *uint       `json:"user_id,omitempty"`
+	Result LoginResult `json:"result" gorm:"type:varchar(32);not null"`
+}
+
+// LoginThrottle counts recent failed logins for one key, an email
+// ("email:<address>") or a client IP ("ip:<address>"). While LockedUntil is
+// in the future logins for the key are rejected without checking the
+// password.
+type LoginThrottle struct {
+	Base
+	Key           string     `json:"key" gorm:"size:300;not null;uniqueIndex"`
+	Failures      int        `json:"failures" gorm:"not null;default:0"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// MFARecoveryCode is a one-time code that replaces a TOTP code when the
+// authenticator is lost; only its hash is stored.
+type MFARecoveryCode struct {
+	Base
+	UserID   uint       `json:"user_id" gorm:"not null;index"`
+	CodeHash string     `json:"-" gorm:"size:64;not null"`
+	UsedAt   *time.Time `json:"used_at,omitempty"`
+}
+
+type MFAChallenge struct {
+	Token     string
+	ExpiresIn int64
+}
+
+type MFASetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// MFACodeRequest carries a TOTP code or a recovery code.
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type LoginMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
@@ -0,0 +1,38 @@
+package models
+
+type PaymentIntentStatus string
+
+const (
+	PaymentIntentPending   PaymentIntentStatus = "pending"
+	PaymentIntentSucceeded PaymentIntentStatus = "succeeded"
+	PaymentIntentFailed    PaymentIntentStatus = "failed"
+)
+
+// PaymentIntent is a deposit the user is paying through the payment
+// provider. The wallet is credited only when a signed provider webhook
+// reports it succeeded.
+type PaymentIntent struct {
+	Base
+
+	UserID uint `json:"user_id" gorm:"not null;index;uniqueIndex:idx_payment_intents_user_idempotency_key,priority:1"`
+
+	Amount      int64               `json:"amount" gorm:"not null"`
+	Currency    string              `json:"currency" gorm:"size:3;not null;default:RUB"`
+	Description string              `json:"description" gorm:"size:512"`
+	Status      PaymentIntentStatus `json:"status" gorm:"type:varchar(16);not null;index"`
+
+	// Reference identifies the intent towards the provider and in webhooks.
+	Reference     string `json:"reference" gorm:"size:64;not null;uniqueIndex"`
+	CheckoutURL   string `json:"checkout_url" gorm:"size:512"`
+	FailureReason string `json:"failure_reason,omitempty" gorm:"size:512"`
+
+	TransactionID  *uint   `json:"transaction_id,omitempty"`
+	IdempotencyKey *string `json:"-" gorm:"size:128;uniqueIndex:idx_payment_intents_user_idempotency_key,priority:2"`
+}
+
+// PaymentWebhookEvent is the verified body of a payment provider webhook.
+type PaymentWebhookEvent struct {
+	Reference string              `json:"reference"`
+	Status    PaymentIntentStatus `json:"status"`
+	Reason    string              `json:"reason"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// RefreshToken is one link of a session's refresh token chain; only its hash
+// is stored. Each refresh marks the presented token used and issues the next
+// one in the same family. A used token presented again has leaked, so the
+// whole family is revoked.
+type RefreshToken struct {
+	Base
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	FamilyID  string     `json:"family_id" gorm:"size:64;not null;index"`
+	TokenHash string     `json:"-" gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	// AccessJTI identifies the access token issued together with this
+	// refresh token; it is revoked along with the family.
+	AccessJTI       string    `json:"-" gorm:"size:64;not null"`
+	AccessExpiresAt time.Time `json:"-" gorm:"not null"`
+}
+
+// RevokedToken is an access token rejected before it expires. An entry is
+// only needed until ExpiresAt, after which the token is invalid anyway.
+type RevokedToken struct {
+	Base
+	JTI       string    `json:"jti" gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index"`
+}
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type TransactionType string
 
 const (
@@ -7,6 +9,13 @@ const (
 	TransactionFreeze   TransactionType = "freeze"
 	TransactionUnfreeze TransactionType = "unfreeze"
 	TransactionCharge   TransactionType = "charge"
+	// TransactionPayout credits the seller with a captured lot payment, net of
+	// the platform commission.
+	TransactionPayout TransactionType = "payout"
+	// TransactionWithdraw debits a withdrawal; TransactionRefund returns it
+	// when the payout provider fails it.
+	TransactionWithdraw TransactionType = "withdraw"
+	TransactionRefund   TransactionType = "refund"
 )
 
 type Transaction struct {
@@ -15,11 +24,12 @@ type Transaction struct {
 	WalletID uint    `json:"wallet_id" gorm:"index;not null"`
 	Wallet   *Wallet `json:"-" gorm:"foreignKey:WalletID;references:ID"`
 
-	UserID uint  `json:"user_id" gorm:"index;not null"`
+	UserID uint  `json:"user_id" gorm:"index;not null;uniqueIndex:idx_transactions_user_idempotency_key,priority:1"`
 	User   *User `json:"-" gorm:"foreignKey:UserID;references:ID"`
 
-	Type   TransactionType `json:"type" gorm:"type:varchar(32);not null"`
-	Amount int64           `json:"amount" gorm:"not null"`
+	Type     TransactionType `json:"type" gorm:"type:varchar(32);not null"`
+	Amount   int64           `json:"amount" gorm:"not null"`
+	Currency string          `json:"currency" gorm:"size:3;not null;default:RUB"`
 
 	BalanceBefore int64 `json:"balance_before" gorm:"not null"`
 	BalanceAfter  int64 `json:"balance_after" gorm:"not null"`
@@ -28,9 +38,48 @@ type Transaction struct {
 	FrozenAfter  int64 `json:"frozen_after" gorm:"not null"`
 
 	Description string `json:"description" gorm:"size:512"`
+
+	HoldID *uint `json:"hold_id,omitempty" gorm:"index"`
+
+	// IdempotencyKey is the client supplied Idempotency-Key header; a repeated
+	// key returns this transaction instead of applying the operation again.
+	IdempotencyKey *string `json:"idempotency_key,omitempty" gorm:"size:128;uniqueIndex:idx_transactions_user_idempotency_key,priority:2"`
 }
 
 type TransactionForRequest struct {
 	Amount      int64  `json:"amount" binding:"required,gt=0"`
+	Currency    string `json:"currency" binding:"omitempty,iso4217"`
 	Description string `json:"description"`
 }
+
+// TransactionFilter narrows a user's transaction history; empty fields match
+// every transaction. ReferenceType and ReferenceID match the hold the
+// transaction belongs to, e.g. lot/42.
+type TransactionFilter struct {
+	Types         []TransactionType `form:"type" binding:"dive,oneof=deposit freeze unfreeze charge payout withdraw refund"`
+	Currency      string            `form:"currency" binding:"omitempty,iso4217"`
+	From          *time.Time        `form:"from"`
+	To            *time.Time        `form:"to"`
+	MinAmount     *int64            `form:"min_amount"`
+	MaxAmount     *int64            `form:"max_amount"`
+	ReferenceType string            `form:"reference_type" binding:"max=32"`
+	ReferenceID   string            `form:"reference_id" binding:"max=64"`
+}
+
+// TransactionCursor is the position of the last transaction of a page in the
+// history ordered by (created_at, id) descending.
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+type CursorPagination struct {
+	PageSize   int    `json:"page_size"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+type TransactionPage struct {
+	Data       []Transaction    `json:"data"`
+	Pagination CursorPagination `json:"pagination"`
+}
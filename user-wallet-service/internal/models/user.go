@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Role string
 
 const (
@@ -16,6 +18,29 @@ type User struct {
 	Email        string `gorm:"uniqueIndex;size:255;not null" json:"email"`
 	PasswordHash string `gorm:"size:255;not null" json:"-"`
 	Role         Role   `gorm:"type:varchar(16);not null;default:buyer" json:"role"`
+	// EmailVerifiedAt is set once the user confirms the email address;
+	// changing the email clears it.
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+
+	// TOTPSecret is the base32 TOTP secret. It is set on enrollment, but two
+	// factor authentication is only on after the first code confirms it.
+	TOTPSecret    string     `gorm:"size:64" json:"-"`
+	TOTPEnabledAt *time.Time `json:"-"`
+	// TOTPLastStep is the time step of the last accepted code, so a code
+	// cannot be used twice.
+	TOTPLastStep int64 `gorm:"not null;default:0" json:"-"`
+	// MFAFailures counts wrong codes since the last accepted one, outside of
+	// logins; too many set MFALockedUntil, which blocks code checks.
+	MFAFailures    int        `gorm:"not null;default:0" json:"-"`
+	MFALockedUntil *time.Time `json:"-"`
+}
+
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
 }
 
 type UpdateMeRequest struct {
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+type UserTokenPurpose string
+
+const (
+	UserTokenPasswordReset     UserTokenPurpose = "password_reset"
+	UserTokenEmailVerification UserTokenPurpose = "email_verification"
+	// UserTokenMFAChallenge is returned by login instead of a session when
+	// the user has two factor authentication on.
+	UserTokenMFAChallenge UserTokenPurpose = "mfa_challenge"
+)
+
+// UserToken is a single-use token mailed to the user to reset the password
+// or confirm the email address, or handed out as an MFA challenge; only its
+// hash is stored.
+type UserToken struct {
+	Base
+	UserID    uint             `json:"user_id" gorm:"not null;index"`
+	Purpose   UserTokenPurpose `json:"purpose" gorm:"type:varchar(32);not null"`
+	TokenHash string           `json:"-" gorm:"size:64;not null;uniqueIndex"`
+	// Email is the address a verification token confirms; it no longer
+	// applies once the user changes the email.
+	Email     string     `json:"email" gorm:"size:255"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	// Attempts counts wrong codes entered against an MFA challenge.
+	Attempts int `json:"-" gorm:"not null;default:0"`
+}
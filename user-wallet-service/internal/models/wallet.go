@@ -1,8 +1,19 @@
 package models
 
+// DefaultCurrency is the currency of wallets created before wallets had one
+// and of requests that do not name a currency.
+const DefaultCurrency = "RUB"
+
+// Wallet holds a user's money in one currency; a user has at most one wallet
+// per currency.
 type Wallet struct {
 	Base
-	UserID        uint  `json:"user_id" gorm:"not null;index"`
-	Balance       int64 `json:"balance" gorm:"not null;default:0"`
-	FrozenBalance int64 `json:"frozen_balance" gorm:"not null;default:0"`
-}
\ No newline at end of file
+	UserID        uint   `json:"user_id" gorm:"not null;index;uniqueIndex:idx_wallets_user_currency,priority:1"`
+	Currency      string `json:"currency" gorm:"size:3;not null;default:RUB;uniqueIndex:idx_wallets_user_currency,priority:2"`
+	Balance       int64  `json:"balance" gorm:"not null;default:0"`
+	FrozenBalance int64  `json:"frozen_balance" gorm:"not null;default:0"`
+}
+
+type WalletForRequest struct {
+	Currency string `form:"currency" binding:"omitempty,iso4217"`
+}
@@ -0,0 +1,39 @@
+package models
+
+type WithdrawalStatus string
+
+const (
+	WithdrawalPending   WithdrawalStatus = "pending"
+	WithdrawalCompleted WithdrawalStatus = "completed"
+	WithdrawalFailed    WithdrawalStatus = "failed"
+)
+
+// Withdrawal is money leaving the platform through the payout provider. The
+// amount is debited when the withdrawal is created and refunded if the
+// provider reports a failure.
+type Withdrawal struct {
+	Base
+
+	WalletID uint `json:"wallet_id" gorm:"index;not null"`
+	UserID   uint `json:"user_id" gorm:"index;not null"`
+
+	Amount      int64            `json:"amount" gorm:"not null"`
+	Currency    string           `json:"currency" gorm:"size:3;not null;default:RUB"`
+	Destination string           `json:"destination" gorm:"size:255;not null"`
+	Status      WithdrawalStatus `json:"status" gorm:"type:varchar(16);not null;index"`
+
+	ProviderReference string `json:"provider_reference,omitempty" gorm:"size:128"`
+	FailureReason     string `json:"failure_reason,omitempty" gorm:"size:512"`
+
+	// TransactionID is the withdraw transaction; RefundTransactionID is set
+	// once a failed withdrawal has been refunded.
+	TransactionID       uint  `json:"transaction_id" gorm:"uniqueIndex;not null"`
+	RefundTransactionID *uint `json:"refund_transaction_id,omitempty"`
+}
+
+type WithdrawalForRequest struct {
+	Amount      int64  `json:"amount" binding:"required,gt=0"`
+	Currency    string `json:"currency" binding:"omitempty,iso4217"`
+	Destination string `json:"destination" binding:"required,max=255"`
+	Description string `json:"description"`
+}
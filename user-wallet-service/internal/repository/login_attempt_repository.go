@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"log/slog"
+	"time"
+
+	models "user-service/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LoginAttemptRepository interface {
+	CreateLoginAttempt(attempt *models.LoginAttempt) error
+	ListLoginAttempts(email string, limit int) ([]models.LoginAttempt, error)
+	ListLoginThrottles(keys []string) ([]models.LoginThrottle, error)
+	// GetLoginThrottleWithLock returns the throttle of the key, creating an
+	// empty one first if there is none.
+	GetLoginThrottleWithLock(key string) (*models.LoginThrottle, error)
+	SaveLoginThrottle(throttle *models.LoginThrottle) error
+	DeleteLoginAttemptsBefore(before time.Time) (int64, error)
+	// DeleteIdleLoginThrottles deletes throttles that are not locked at now
+	// and have no failures left or none since failedBefore.
+	DeleteIdleLoginThrottles(now, failedBefore time.Time) (int64, error)
+	WithDB(db *gorm.DB) LoginAttemptRepository
+}
+
+type loginAttemptRepository struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+func NewLoginAttemptRepository(db *gorm.DB, logger *slog.Logger) LoginAttemptRepository {
+	return &loginAttemptRepository{db: db, logger: logger}
+}
+
+func (r *loginAttemptRepository) WithDB(db *gorm.DB) LoginAttemptRepository {
+	return &loginAttemptRepository{db: db, logger: r.logger}
+}
+
+func (r *loginAttemptRepository) CreateLoginAttempt(attempt *models.LoginAttempt) error {
+	return r.db.Create(attempt).Error
+}
+
+func (r *loginAttemptRepository) ListLoginAttempts(email string, limit int) ([]models.LoginAttempt, error) {
+	var attempts []models.LoginAttempt
+	err := r.db.Where("email = ?", email).Order("created_at desc, id desc").Limit(limit).Find(&attempts).Error
+	if err != nil {
+		r.logger.Error("db list login attempts failed", "err", err.Error())
+		return nil, err
+	}
+	return attempts, nil
+}
+
+func (r *loginAttemptRepository) ListLoginThrottles(keys []string) ([]models.LoginThrottle, error) {
+	var throttles []models.LoginThrottle
+	if err := r.db.Where("key IN ?", keys).Find(&throttles).Error; err != nil {
+		r.logger.Error("db list login throttles failed", "err", err.Error())
+		return nil, err
+	}
+	return throttles, nil
+}
+
+func (r *loginAttemptRepository) GetLoginThrottleWithLock(key string) (*models.LoginThrottle, error) {
+	err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.LoginThrottle{Key: key}).Error
+	if err != nil {
+		r.logger.Error("db create login throttle failed", "err", err.Error())
+		return nil, err
+	}
+	var throttle models.LoginThrottle
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&throttle).Error; err != nil {
+		r.logger.Error("db get login throttle failed", "err", err.Error())
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+func (r *loginAttemptRepository) SaveLoginThrottle(throttle *models.LoginThrottle) error {
+	return r.db.Save(throttle).Error
+}
+
+// DeleteLoginAttemptsBefore removes audit entries created before the time.
+func (r *loginAttemptRepository) DeleteLoginAttemptsBefore(before time.Time) (int64, error) {
+	result := r.db.Unscoped().Where("created_at < ?", before).Delete(&models.LoginAttempt{})
+	if result.Error != nil {
+		r.logger.Error("db delete login attempts failed", "err", result.Error.Error())
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// Throttles are deleted for good: a soft deleted row would still hold the
+// unique key that GetLoginThrottleWithLock creates rows under.
+func (r *loginAttemptRepository) DeleteIdleLoginThrottles(now, failedBefore time.Time) (int64, error) {
+	result := r.db.Unscoped().
+		Where("locked_until IS NULL OR locked_until <= ?", now).
+		Where("failures = 0 OR last_failure_at IS NULL OR last_failure_at < ?", failedBefore).
+		Delete(&models.LoginThrottle{})
+	if result.Error != nil {
+		r.logger.Error("db delete login throttles failed", "err", result.Error.Error())
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
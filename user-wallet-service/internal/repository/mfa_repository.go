@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"errors"
+	"log/slog"
+
+	models "user-service/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MFARepository interface {
+	ReplaceRecoveryCodes(userID uint, codes []models.MFARecoveryCode) error
+	GetUnusedRecoveryCodeWithLock(userID uint, hash string) (*models.MFARecoveryCode, error)
+	SaveRecoveryCode(code *models.MFARecoveryCode) error
+	WithDB(db *gorm.DB) MFARepository
+}
+
+type mfaRepository struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+func NewMFARepository(db *gorm.DB, logger *slog.Logger) MFARepository {
+	return &mfaRepository{db: db, logger: logger}
+}
+
+func (r *mfaRepository) WithDB(db *gorm.DB) MFARepository {
+	return &mfaRepository{db: db, logger: r.logger}
+}
+
+// ReplaceRecoveryCodes deletes the user's recovery codes and stores codes
+// instead; with no codes it only deletes.
+func (r *mfaRepository) ReplaceRecoveryCodes(userID uint, codes []models.MFARecoveryCode) error {
+	r.logger.Info("db replace recovery codes", "user_id", userID, "count", len(codes))
+	if err := r.db.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return r.db.Create(&codes).Error
+}
+
+func (r *mfaRepository) GetUnusedRecoveryCodeWithLock(userID uint, hash string) (*models.MFARecoveryCode, error) {
+	var code models.MFARecoveryCode
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		First(&code).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.logger.Error("db get recovery code failed", "user_id", userID, "err", err.Error())
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (r *mfaRepository) SaveRecoveryCode(code *models.MFARecoveryCode) error {
+	r.logger.Info("db save recovery code", "id", code.ID, "user_id", code.UserID)
+	return r.db.Save(code).Error
+}
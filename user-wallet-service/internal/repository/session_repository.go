@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	models "user-service/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SessionRepository interface {
+	CreateRefreshToken(token *models.RefreshToken) error
+	SaveRefreshToken(token *models.RefreshToken) error
+	GetRefreshTokenByHashWithLock(hash string) (*models.RefreshToken, error)
+	ListRefreshTokenFamily(familyID string) ([]models.RefreshToken, error)
+	ListActiveRefreshTokenFamilies(userID uint) ([]string, error)
+	RevokeRefreshTokenFamily(familyID string, at time.Time) error
+	RevokeAccessTokens(tokens []models.RevokedToken) error
+	ListRevokedTokens(now time.Time) ([]models.RevokedToken, error)
+	DeleteExpiredRevokedTokens(now time.Time) (int64, error)
+	WithDB(db *gorm.DB) SessionRepository
+}
+
+type sessionRepository struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+func NewSessionRepository(db *gorm.DB, logger *slog.Logger) SessionRepository {
+	return &sessionRepository{db: db, logger: logger}
+}
+
+func (r *sessionRepository) WithDB(db *gorm.DB) SessionRepository {
+	return &sessionRepository{db: db, logger: r.logger}
+}
+
+func (r *sessionRepository) CreateRefreshToken(token *models.RefreshToken) error {
+	r.logger.Info("db create refresh token", "user_id", token.UserID, "family_id", token.FamilyID)
+	return r.db.Create(token).Error
+}
+
+func (r *sessionRepository) SaveRefreshToken(token *models.RefreshToken) error {
+	r.logger.Info("db save refresh token", "id", token.ID, "family_id", token.FamilyID)
+	return r.db.Save(token).Error
+}
+
+func (r *sessionRepository) GetRefreshTokenByHashWithLock(hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.logger.Error("db get refresh token failed", "err", err.Error())
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *sessionRepository) ListRefreshTokenFamily(familyID string) ([]models.RefreshToken, error) {
+	var tokens []models.RefreshToken
+	if err := r.db.Where("family_id = ?", familyID).Find(&tokens).Error; err != nil {
+		r.logger.Error("db list refresh token family failed", "family_id", familyID, "err", err.Error())
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r *sessionRepository) ListActiveRefreshTokenFamilies(userID uint) ([]string, error) {
+	var families []string
+	err := r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Distinct().Pluck("family_id", &families).Error
+	if err != nil {
+		r.logger.Error("db list refresh token families failed", "user_id", userID, "err", err.Error())
+		return nil, err
+	}
+	return families, nil
+}
+
+func (r *sessionRepository) RevokeRefreshTokenFamily(familyID string, at time.Time) error {
+	r.logger.Info("db revoke refresh token family", "family_id", familyID)
+	return r.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", at).Error
+}
+
+func (r *sessionRepository) RevokeAccessTokens(tokens []models.RevokedToken) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	r.logger.Info("db revoke access tokens", "count", len(tokens))
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&tokens).Error
+}
+
+// ListRevokedTokens returns revocations of access tokens that have not
+// expired yet.
+func (r *sessionRepository) ListRevokedTokens(now time.Time) ([]models.RevokedToken, error) {
+	var tokens []models.RevokedToken
+	if err := r.db.Where("expires_at > ?", now).Find(&tokens).Error; err != nil {
+		r.logger.Error("db list revoked tokens failed", "err", err.Error())
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DeleteExpiredRevokedTokens removes revocations of access tokens that have
+// expired, which the gateway rejects on their own.
+func (r *sessionRepository) DeleteExpiredRevokedTokens(now time.Time) (int64, error) {
+	result := r.db.Unscoped().Where("expires_at <= ?", now).Delete(&models.RevokedToken{})
+	if result.Error != nil {
+		r.logger.Error("db delete expired revoked tokens failed", "err", result.Error.Error())
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
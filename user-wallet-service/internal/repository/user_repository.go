@@ -8,13 +8,16 @@ import (
 	model "user-service/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository interface {
 	Create(user *model.User) error
 	FindByEmail(email string) (*model.User, error)
 	FindByID(id uint) (*model.User, error)
+	FindByIDWithLock(id uint) (*model.User, error)
 	Update(user *model.User) error
+	WithDB(db *gorm.DB) UserRepository
 }
 
 type userRepository struct {
@@ -26,6 +29,10 @@ func NewUserRepository(db *gorm.DB, logger *slog.Logger) UserRepository {
 	return &userRepository{db: db, logger: logger}
 }
 
+func (r *userRepository) WithDB(db *gorm.DB) UserRepository {
+	return &userRepository{db: db, logger: r.logger}
+}
+
 func (r *userRepository) Create(user *model.User) error {
 	r.logger.Info("db create user", "email", user.Email)
 	return r.db.Create(user).Error
@@ -57,6 +64,18 @@ func (r *userRepository) FindByID(id uint) (*model.User, error) {
 	return &u, nil
 }
 
+func (r *userRepository) FindByIDWithLock(id uint) (*model.User, error) {
+	var u model.User
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&u, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.logger.Error("db find by id with lock failed", "id", id, "err", err.Error())
+		return nil, err
+	}
+	return &u, nil
+}
+
 func (r *userRepository) Update(user *model.User) error {
 	r.logger.Info("db update user", "id", user.ID)
 	return r.db.Save(user).Error
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	models "user-service/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UserTokenRepository interface {
+	CreateUserToken(token *models.UserToken) error
+	SaveUserToken(token *models.UserToken) error
+	GetUserTokenByHashWithLock(hash string) (*models.UserToken, error)
+	ExpireUserTokens(userID uint, purpose models.UserTokenPurpose, at time.Time) error
+	WithDB(db *gorm.DB) UserTokenRepository
+}
+
+type userTokenRepository struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+func NewUserTokenRepository(db *gorm.DB, logger *slog.Logger) UserTokenRepository {
+	return &userTokenRepository{db: db, logger: logger}
+}
+
+func (r *userTokenRepository) WithDB(db *gorm.DB) UserTokenRepository {
+	return &userTokenRepository{db: db, logger: r.logger}
+}
+
+func (r *userTokenRepository) CreateUserToken(token *models.UserToken) error {
+	r.logger.Info("db create user token", "user_id", token.UserID, "purpose", token.Purpose)
+	return r.db.Create(token).Error
+}
+
+func (r *userTokenRepository) SaveUserToken(token *models.UserToken) error {
+	r.logger.Info("db save user token", "id", token.ID, "purpose", token.Purpose)
+	return r.db.Save(token).Error
+}
+
+func (r *userTokenRepository) GetUserTokenByHashWithLock(hash string) (*models.UserToken, error) {
+	var token models.UserToken
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.logger.Error("db get user token failed", "err", err.Error())
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ExpireUserTokens marks the user's unused tokens of the purpose as used, so
+// only the most recently mailed one works.
+func (r *userTokenRepository) ExpireUserTokens(userID uint, purpose models.UserTokenPurpose, at time.Time) error {
+	r.logger.Info("db expire user tokens", "user_id", userID, "purpose", purpose)
+	return r.db.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", at).Error
+}
@@ -12,12 +12,36 @@ import (
 )
 
 type WalletRepository interface {
-	GetByUserID(userID uint) (*models.Wallet, error)
-	GetByUserIDWithLock(userID uint) (*models.Wallet, error)
+	GetByUserID(userID uint, currency string) (*models.Wallet, error)
+	GetByUserIDWithLock(userID uint, currency string) (*models.Wallet, error)
+	ListUserWallets(userID uint) ([]models.Wallet, error)
 	SaveWallet(wallet *models.Wallet) error
 	CreateWallet(wallet *models.Wallet) error
 	CreateTransaction(tx *models.Transaction) error
-	ListTransactions(userID uint, limit, offset int) ([]models.Transaction, error)
+	GetTransactionByIdempotencyKey(userID uint, key string) (*models.Transaction, error)
+	ListTransactions(userID uint, filter models.TransactionFilter, after *models.TransactionCursor, limit int) ([]models.Transaction, error)
+	GetHold(userID uint, ref models.HoldReference) (*models.Hold, error)
+	SaveHold(hold *models.Hold) error
+	SumActiveHolds(walletID uint) (int64, error)
+	ListHolds(userID uint, status models.HoldStatus) ([]models.Hold, error)
+	ListWallets() ([]models.Wallet, error)
+	CreatePaymentIntent(intent *models.PaymentIntent) error
+	SavePaymentIntent(intent *models.PaymentIntent) error
+	GetPaymentIntentByReferenceWithLock(reference string) (*models.PaymentIntent, error)
+	GetPaymentIntentByIdempotencyKey(userID uint, key string) (*models.PaymentIntent, error)
+	ListPaymentIntents(userID uint, limit, offset int) ([]models.PaymentIntent, error)
+	CreateWithdrawal(withdrawal *models.Withdrawal) error
+	SaveWithdrawal(withdrawal *models.Withdrawal) error
+	SetWithdrawalProviderReference(id uint, reference string) error
+	GetWithdrawalWithLock(id uint) (*models.Withdrawal, error)
+	GetWithdrawalByTransactionID(transactionID uint) (*models.Withdrawal, error)
+	ListWithdrawals(userID uint, limit, offset int) ([]models.Withdrawal, error)
+	ListPendingWithdrawals(limit int) ([]models.Withdrawal, error)
+	GetLedgerAccount(accountType models.LedgerAccountType, userID uint, currency string) (*models.LedgerAccount, error)
+	CreateLedgerEntry(entry *models.LedgerEntry) error
+	HasLedgerAccounts(userID uint, currency string) (bool, error)
+	LedgerBalances() ([]models.LedgerBalance, error)
+	UnbalancedLedgerEntries() ([]uint, error)
 	WithDB(db *gorm.DB) WalletRepository
 }
 
@@ -34,32 +58,41 @@ func (r *walletRepository) WithDB(db *gorm.DB) WalletRepository {
 	return &walletRepository{db: db, logger: r.logger}
 }
 
-func (r *walletRepository) GetByUserID(userID uint) (*models.Wallet, error) {
+func (r *walletRepository) GetByUserID(userID uint, currency string) (*models.Wallet, error) {
 	var wallet models.Wallet
-	if err := r.db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+	if err := r.db.Where("user_id = ? AND currency = ?", userID, currency).First(&wallet).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
 		}
-		r.logger.Error("db get wallet failed", "user_id", userID, "err", err.Error())
+		r.logger.Error("db get wallet failed", "user_id", userID, "currency", currency, "err", err.Error())
 		return nil, err
 	}
 	r.logger.Info("db got wallet", "user_id", userID, "wallet_id", wallet.ID)
 	return &wallet, nil
 }
 
-func (r *walletRepository) GetByUserIDWithLock(userID uint) (*models.Wallet, error) {
+func (r *walletRepository) GetByUserIDWithLock(userID uint, currency string) (*models.Wallet, error) {
 	var wallet models.Wallet
-	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ? AND currency = ?", userID, currency).First(&wallet).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
 		}
-		r.logger.Error("db get wallet lock failed", "user_id", userID, "err", err.Error())
+		r.logger.Error("db get wallet lock failed", "user_id", userID, "currency", currency, "err", err.Error())
 		return nil, err
 	}
 	r.logger.Info("db got wallet with lock", "user_id", userID, "wallet_id", wallet.ID)
 	return &wallet, nil
 }
 
+func (r *walletRepository) ListUserWallets(userID uint) ([]models.Wallet, error) {
+	var wallets []models.Wallet
+	if err := r.db.Where("user_id = ?", userID).Order("currency").Find(&wallets).Error; err != nil {
+		r.logger.Error("db list user wallets failed", "user_id", userID, "err", err.Error())
+		return nil, err
+	}
+	return wallets, nil
+}
+
 func (r *walletRepository) SaveWallet(wallet *models.Wallet) error {
 	r.logger.Info("db save wallet", "wallet_id", wallet.ID)
 	return r.db.Save(wallet).Error
@@ -75,15 +108,51 @@ func (r *walletRepository) CreateTransaction(tx *models.Transaction) error {
 	return r.db.Create(tx).Error
 }
 
-func (r *walletRepository) ListTransactions(userID uint, limit, offset int) ([]models.Transaction, error) {
+func (r *walletRepository) GetTransactionByIdempotencyKey(userID uint, key string) (*models.Transaction, error) {
+	var transaction models.Transaction
+	if err := r.db.Where("user_id = ? AND idempotency_key = ?", userID, key).First(&transaction).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.logger.Error("db get transaction by idempotency key failed", "user_id", userID, "err", err.Error())
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// ListTransactions returns up to limit transactions matching filter, newest
+// first, starting after the cursor when one is given.
+func (r *walletRepository) ListTransactions(userID uint, filter models.TransactionFilter, after *models.TransactionCursor, limit int) ([]models.Transaction, error) {
 	var txs []models.Transaction
-	query := r.db.Where("user_id = ?", userID).Order("created_at desc")
+	query := r.db.Where("user_id = ?", userID).Order("created_at desc, id desc")
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+	if filter.Currency != "" {
+		query = query.Where("currency = ?", filter.Currency)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.MinAmount != nil {
+		query = query.Where("amount >= ?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		query = query.Where("amount <= ?", *filter.MaxAmount)
+	}
+	if filter.ReferenceID != "" {
+		query = query.Where("hold_id IN (?)", r.db.Model(&models.Hold{}).Select("id").
+			Where("user_id = ? AND reference_type = ? AND reference_id = ?", userID, filter.ReferenceType, filter.ReferenceID))
+	}
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.CreatedAt, after.ID)
+	}
 	if limit > 0 {
 		query = query.Limit(limit)
 	}
-	if offset > 0 {
-		query = query.Offset(offset)
-	}
 	if err := query.Find(&txs).Error; err != nil {
 		r.logger.Error("db list transactions failed", "user_id", userID, "err", err.Error())
 		return nil, err
@@ -91,3 +160,239 @@ func (r *walletRepository) ListTransactions(userID uint, limit, offset int) ([]m
 	r.logger.Info("db list transactions", "user_id", userID, "count", len(txs))
 	return txs, nil
 }
+
+func (r *walletRepository) GetHold(userID uint, ref models.HoldReference) (*models.Hold, error) {
+	var hold models.Hold
+	err := r.db.Where("user_id = ? AND reference_type = ? AND reference_id = ?", userID, ref.ReferenceType, ref.ReferenceID).
+		First(&hold).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.logger.Error("db get hold failed", "user_id", userID, "reference_type", ref.ReferenceType, "reference_id", ref.ReferenceID, "err", err.Error())
+		return nil, err
+	}
+	return &hold, nil
+}
+
+func (r *walletRepository) SaveHold(hold *models.Hold) error {
+	r.logger.Info("db save hold", "user_id", hold.UserID, "reference_type", hold.ReferenceType, "reference_id", hold.ReferenceID, "status", hold.Status)
+	return r.db.Save(hold).Error
+}
+
+func (r *walletRepository) SumActiveHolds(walletID uint) (int64, error) {
+	var total int64
+	err := r.db.Model(&models.Hold{}).
+		Where("wallet_id = ? AND status = ?", walletID, models.HoldActive).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&total).Error
+	if err != nil {
+		r.logger.Error("db sum active holds failed", "wallet_id", walletID, "err", err.Error())
+		return 0, err
+	}
+	return total, nil
+}
+
+func (r *walletRepository) ListHolds(userID uint, status models.HoldStatus) ([]models.Hold, error) {
+	var holds []models.Hold
+	query := r.db.Where("user_id = ?", userID).Order("created_at desc")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Find(&holds).Error; err != nil {
+		r.logger.Error("db list holds failed", "user_id", userID, "err", err.Error())
+		return nil, err
+	}
+	return holds, nil
+}
+
+func (r *walletRepository) ListWallets() ([]models.Wallet, error) {
+	var wallets []models.Wallet
+	if err := r.db.Order("user_id, currency").Find(&wallets).Error; err != nil {
+		r.logger.Error("db list wallets failed", "err", err.Error())
+		return nil, err
+	}
+	return wallets, nil
+}
+
+// GetLedgerAccount returns the account, creating it on first use.
+func (r *walletRepository) GetLedgerAccount(accountType models.LedgerAccountType, userID uint, currency string) (*models.LedgerAccount, error) {
+	account := models.LedgerAccount{Type: accountType, UserID: userID, Currency: currency}
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		r.logger.Error("db create ledger account failed", "type", accountType, "user_id", userID, "currency", currency, "err", err.Error())
+		return nil, err
+	}
+	if account.ID != 0 {
+		return &account, nil
+	}
+	if err := r.db.Where("type = ? AND user_id = ? AND currency = ?", accountType, userID, currency).First(&account).Error; err != nil {
+		r.logger.Error("db get ledger account failed", "type", accountType, "user_id", userID, "currency", currency, "err", err.Error())
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (r *walletRepository) CreateLedgerEntry(entry *models.LedgerEntry) error {
+	r.logger.Info("db create ledger entry", "kind", entry.Kind, "transaction_id", entry.TransactionID, "postings", len(entry.Postings))
+	return r.db.Create(entry).Error
+}
+
+func (r *walletRepository) HasLedgerAccounts(userID uint, currency string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.LedgerAccount{}).
+		Where("user_id = ? AND currency = ? AND type IN ?", userID, currency, []models.LedgerAccountType{models.LedgerUserAvailable, models.LedgerUserHeld}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *walletRepository) LedgerBalances() ([]models.LedgerBalance, error) {
+	var balances []models.LedgerBalance
+	err := r.db.Table("ledger_accounts a").
+		Select("a.type, a.user_id, a.currency, COALESCE(SUM(p.amount), 0) AS balance").
+		Joins("LEFT JOIN ledger_postings p ON p.account_id = a.id AND p.deleted_at IS NULL").
+		Where("a.deleted_at IS NULL").
+		Group("a.type, a.user_id, a.currency").
+		Scan(&balances).Error
+	if err != nil {
+		r.logger.Error("db ledger balances failed", "err", err.Error())
+		return nil, err
+	}
+	return balances, nil
+}
+
+func (r *walletRepository) UnbalancedLedgerEntries() ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.LedgerPosting{}).
+		Select("entry_id").
+		Group("entry_id").
+		Having("SUM(amount) <> 0").
+		Scan(&ids).Error
+	if err != nil {
+		r.logger.Error("db unbalanced ledger entries failed", "err", err.Error())
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *walletRepository) CreateWithdrawal(withdrawal *models.Withdrawal) error {
+	r.logger.Info("db create withdrawal", "user_id", withdrawal.UserID, "amount", withdrawal.Amount)
+	return r.db.Create(withdrawal).Error
+}
+
+func (r *walletRepository) SaveWithdrawal(withdrawal *models.Withdrawal) error {
+	r.logger.Info("db save withdrawal", "withdrawal_id", withdrawal.ID, "status", withdrawal.Status)
+	return r.db.Save(withdrawal).Error
+}
+
+// SetWithdrawalProviderReference stores the provider reference of a
+// withdrawal that is still pending, leaving the rest of the row as it is.
+func (r *walletRepository) SetWithdrawalProviderReference(id uint, reference string) error {
+	r.logger.Info("db set withdrawal provider reference", "withdrawal_id", id, "reference", reference)
+	return r.db.Model(&models.Withdrawal{}).
+		Where("id = ? AND status = ?", id, models.WithdrawalPending).
+		Update("provider_reference", reference).Error
+}
+
+func (r *walletRepository) GetWithdrawalWithLock(id uint) (*models.Withdrawal, error) {
+	var withdrawal models.Withdrawal
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&withdrawal, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.logger.Error("db get withdrawal lock failed", "withdrawal_id", id, "err", err.Error())
+		return nil, err
+	}
+	return &withdrawal, nil
+}
+
+func (r *walletRepository) GetWithdrawalByTransactionID(transactionID uint) (*models.Withdrawal, error) {
+	var withdrawal models.Withdrawal
+	if err := r.db.Where("transaction_id = ?", transactionID).First(&withdrawal).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.logger.Error("db get withdrawal by transaction failed", "transaction_id", transactionID, "err", err.Error())
+		return nil, err
+	}
+	return &withdrawal, nil
+}
+
+func (r *walletRepository) ListWithdrawals(userID uint, limit, offset int) ([]models.Withdrawal, error) {
+	var withdrawals []models.Withdrawal
+	query := r.db.Where("user_id = ?", userID).Order("created_at desc")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if offset > 0 {
+		query = query.Offset(offset)
+	}
+	if err := query.Find(&withdrawals).Error; err != nil {
+		r.logger.Error("db list withdrawals failed", "user_id", userID, "err", err.Error())
+		return nil, err
+	}
+	return withdrawals, nil
+}
+
+// ListPendingWithdrawals skips withdrawals locked by a settlement in
+// progress.
+func (r *walletRepository) ListPendingWithdrawals(limit int) ([]models.Withdrawal, error) {
+	var withdrawals []models.Withdrawal
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ?", models.WithdrawalPending).Order("id").Limit(limit).Find(&withdrawals).Error
+	if err != nil {
+		r.logger.Error("db list pending withdrawals failed", "err", err.Error())
+		return nil, err
+	}
+	return withdrawals, nil
+}
+
+func (r *walletRepository) CreatePaymentIntent(intent *models.PaymentIntent) error {
+	r.logger.Info("db create payment intent", "user_id", intent.UserID, "reference", intent.Reference)
+	return r.db.Create(intent).Error
+}
+
+func (r *walletRepository) SavePaymentIntent(intent *models.PaymentIntent) error {
+	r.logger.Info("db save payment intent", "reference", intent.Reference, "status", intent.Status)
+	return r.db.Save(intent).Error
+}
+
+func (r *walletRepository) GetPaymentIntentByReferenceWithLock(reference string) (*models.PaymentIntent, error) {
+	var intent models.PaymentIntent
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("reference = ?", reference).First(&intent).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.logger.Error("db get payment intent lock failed", "reference", reference, "err", err.Error())
+		return nil, err
+	}
+	return &intent, nil
+}
+
+func (r *walletRepository) GetPaymentIntentByIdempotencyKey(userID uint, key string) (*models.PaymentIntent, error) {
+	var intent models.PaymentIntent
+	if err := r.db.Where("user_id = ? AND idempotency_key = ?", userID, key).First(&intent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.logger.Error("db get payment intent by idempotency key failed", "user_id", userID, "err", err.Error())
+		return nil, err
+	}
+	return &intent, nil
+}
+
+func (r *walletRepository) ListPaymentIntents(userID uint, limit, offset int) ([]models.PaymentIntent, error) {
+	var intents []models.PaymentIntent
+	query := r.db.Where("user_id = ?", userID).Order("created_at desc")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if offset > 0 {
+		query = query.Offset(offset)
+	}
+	if err := query.Find(&intents).Error; err != nil {
+		r.logger.Error("db list payment intents failed", "user_id", userID, "err", err.Error())
+		return nil, err
+	}
+	return intents, nil
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	model "user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/utils"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ChangePassword replaces the password after checking the current one and
+// signs the user out of every session.
+func (s *userService) ChangePassword(id uint, currentPassword, newPassword string) error {
+	s.logger.Info("service change password attempt", "user_id", id)
+	if len(newPassword) < s.minPassLn {
+		return errors.New("password too short")
+	}
+	u, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return errors.New("user not found")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(currentPassword)); err != nil {
+		return utils.ErrInvalidPassword
+	}
+	if err := s.setPassword(s.repo, u, newPassword); err != nil {
+		return err
+	}
+	s.logger.Info("service password changed", "user_id", id)
+	return s.sessions.RevokeUserSessions(id)
+}
+
+// ForgotPassword mails a password reset link. An unknown email is not an
+// error, so the endpoint does not reveal which emails are registered.
+func (s *userService) ForgotPassword(email string) error {
+	email = strings.TrimSpace(strings.ToLower(email))
+	u, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		s.logger.Info("service forgot password for unknown email")
+		return nil
+	}
+
+	token, err := s.issueUserToken(u, model.UserTokenPasswordReset, s.mailCfg.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("service password reset requested", "user_id", u.ID)
+	return s.mail.Send(Mail{
+		To:      u.Email,
+		Subject: "Сброс пароля",
+		Body: fmt.Sprintf(
+			"Чтобы задать новый пароль, перейдите по ссылке:\n%s\n\nСсылка действует %s. Если вы не запрашивали сброс пароля, проигнорируйте это письмо.",
+			s.link("/reset-password", token), s.mailCfg.PasswordResetTTL,
+		),
+	})
+}
+
+// ResetPassword sets a new password by a mailed reset token and signs the
+// user out of every session. Following the link proves access to the
+// mailbox, so the email counts as verified too unless it has changed since
+// the link was mailed.
+func (s *userService) ResetPassword(token, password string) error {
+	if len(password) < s.minPassLn {
+		return errors.New("password too short")
+	}
+	var userID uint
+	err := s.db.Transaction(func(txDB *gorm.DB) error {
+		users := s.repo.WithDB(txDB)
+
+		t, err := s.useUserToken(s.tokens.WithDB(txDB), token, model.UserTokenPasswordReset)
+		if err != nil {
+			return err
+		}
+		u, err := users.FindByID(t.UserID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return utils.ErrInvalidUserToken
+		}
+		if u.EmailVerifiedAt == nil && u.Email == t.Email {
+			u.EmailVerifiedAt = t.UsedAt
+		}
+		userID = u.ID
+		return s.setPassword(users, u, password)
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Info("service password reset", "user_id", userID)
+	return s.sessions.RevokeUserSessions(userID)
+}
+
+// SendEmailVerification mails a new verification link; earlier links stop
+// working.
+func (s *userService) SendEmailVerification(id uint) error {
+	u, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return errors.New("user not found")
+	}
+	if u.EmailVerified() {
+		return nil
+	}
+	return s.sendEmailVerification(u)
+}
+
+// VerifyEmail confirms the email address the token was mailed to. The new
+// state reaches access tokens on the next refresh.
+func (s *userService) VerifyEmail(token string) (*model.User, error) {
+	var user *model.User
+	err := s.db.Transaction(func(txDB *gorm.DB) error {
+		users := s.repo.WithDB(txDB)
+
+		t, err := s.useUserToken(s.tokens.WithDB(txDB), token, model.UserTokenEmailVerification)
+		if err != nil {
+			return err
+		}
+		user, err = users.FindByID(t.UserID)
+		if err != nil {
+			return err
+		}
+		if user == nil || user.Email != t.Email {
+			return utils.ErrInvalidUserToken
+		}
+		if user.EmailVerifiedAt != nil {
+			return nil
+		}
+		user.EmailVerifiedAt = t.UsedAt
+		return users.Update(user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info("service email verified", "user_id", user.ID)
+	return user, nil
+}
+
+func (s *userService) sendEmailVerification(u *model.User) error {
+	token, err := s.issueUserToken(u, model.UserTokenEmailVerification, s.mailCfg.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return s.mail.Send(Mail{
+		To:      u.Email,
+		Subject: "Подтверждение email",
+		Body: fmt.Sprintf(
+			"Чтобы подтвердить адрес и участвовать в торгах, перейдите по ссылке:\n%s\n\nСсылка действует %s.",
+			s.link("/verify-email", token), s.mailCfg.EmailVerificationTTL,
+		),
+	})
+}
+
+// issueUserToken creates a token of the purpose for the user, replacing the
+// ones mailed before, and returns its plain value.
+func (s *userService) issueUserToken(u *model.User, purpose model.UserTokenPurpose, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	err = s.db.Transaction(func(txDB *gorm.DB) error {
+		tokens := s.tokens.WithDB(txDB)
+		if err := tokens.ExpireUserTokens(u.ID, purpose, now); err != nil {
+			return err
+		}
+		return tokens.CreateUserToken(&model.UserToken{
+			UserID:    u.ID,
+			Purpose:   purpose,
+			TokenHash: hashToken(token),
+			Email:     u.Email,
+			ExpiresAt: now.Add(ttl),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// useUserToken locks the token and marks it used; a token works only once
+// and only before it expires.
+func (s *userService) useUserToken(tokens repository.UserTokenRepository, token string, purpose model.UserTokenPurpose) (*model.UserToken, error) {
+	t, err := tokens.GetUserTokenByHashWithLock(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if t == nil || t.Purpose != purpose || t.UsedAt != nil || now.After(t.ExpiresAt) {
+		return nil, utils.ErrInvalidUserToken
+	}
+	t.UsedAt = &now
+	if err := tokens.SaveUserToken(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *userService) setPassword(users repository.UserRepository, u *model.User, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return users.Update(u)
+}
+
+func (s *userService) link(path, token string) string {
+	return s.mailCfg.AppBaseURL + path + "?token=" + url.QueryEscape(token)
+}
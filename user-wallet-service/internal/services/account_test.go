@@ -0,0 +1,65 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	models "user-service/internal/models"
+	"user-service/internal/utils"
+)
+
+func TestResetLinkStopsWorkingAfterEmailChange(t *testing.T) {
+	s, mail := newTestUserService(t, newTestDB(t))
+	u := registerUser(t, s, "old@example.com")
+	if err := s.ForgotPassword("old@example.com"); err != nil {
+		t.Fatalf("forgot password: %v", err)
+	}
+	token := mailedToken(t, mail, "old@example.com")
+
+	if _, err := s.UpdateProfile(u.ID, "", "new@example.com"); err != nil {
+		t.Fatalf("update profile: %v", err)
+	}
+
+	if err := s.ResetPassword(token, "new-password"); !errors.Is(err, utils.ErrInvalidUserToken) {
+		t.Fatalf("expected ErrInvalidUserToken, got %v", err)
+	}
+	updated, err := s.GetByID(u.ID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if updated.EmailVerified() {
+		t.Fatal("new email marked verified by a link mailed to the old one")
+	}
+}
+
+func TestResetPasswordVerifiesEmail(t *testing.T) {
+	s, mail := newTestUserService(t, newTestDB(t))
+	u := registerUser(t, s, "user@example.com")
+	if err := s.ForgotPassword("user@example.com"); err != nil {
+		t.Fatalf("forgot password: %v", err)
+	}
+
+	if err := s.ResetPassword(mailedToken(t, mail, "user@example.com"), "new-password"); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	updated, err := s.GetByID(u.ID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if !updated.EmailVerified() {
+		t.Fatal("email not verified by the reset link")
+	}
+}
+
+func TestRegisterCreatesBuyer(t *testing.T) {
+	s, _ := newTestUserService(t, newTestDB(t))
+	u := registerUser(t, s, "user@example.com")
+
+	stored, err := s.GetByID(u.ID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if u.Role != models.RoleBuyer || stored.Role != models.RoleBuyer {
+		t.Fatalf("role = %q, stored %q, want %q", u.Role, stored.Role, models.RoleBuyer)
+	}
+}
@@ -1,58 +1,134 @@
 package services
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"os"
+	"fmt"
+	"math/big"
 	"time"
 
+	"user-service/internal/config"
 	model "user-service/internal/models"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type JWTService interface {
-	GenerateToken(u *model.User, ttl time.Duration) (string, error)
+	// GenerateToken returns a signed access token and its unique ID (jti),
+	// under which it can be revoked.
+	GenerateToken(u *model.User, ttl time.Duration) (string, string, error)
 	ParseToken(tokenStr string) (*jwt.RegisteredClaims, model.Role, uint, error)
+	// JWKS returns the public keys tokens can be verified with.
+	JWKS() model.JWKS
+}
+
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    any
+	public any
 }
 
 type jwtService struct {
-	secret string
+	active *signingKey
+	keys   map[string]*signingKey
+	jwks   model.JWKS
 }
 
-func NewJWTService() JWTService {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "dev-secret-change-me"
+// NewJWTService signs tokens with the active key and accepts tokens signed by
+// any of the loaded keys, so tokens survive a key rotation.
+func NewJWTService(keys config.SigningKeys) JWTService {
+	s := &jwtService{keys: map[string]*signingKey{}, jwks: model.JWKS{Keys: []model.JSONWebKey{}}}
+	for _, k := range keys.Keys {
+		sk := &signingKey{kid: k.KID, key: k.Key, public: k.Key.Public()}
+		jwk := model.JSONWebKey{Kid: k.KID, Use: "sig"}
+		switch pub := sk.public.(type) {
+		case *rsa.PublicKey:
+			sk.method = jwt.SigningMethodRS256
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			sk.method = jwt.SigningMethodEdDSA
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		jwk.Alg = sk.method.Alg()
+
+		s.keys[sk.kid] = sk
+		s.jwks.Keys = append(s.jwks.Keys, jwk)
+		if sk.kid == keys.ActiveKID {
+			s.active = sk
+		}
 	}
-	return &jwtService{secret: secret}
+	return s
 }
 
 type userClaims struct {
-	Role string `json:"role"`
-	UID  uint   `json:"uid"`
+	Role          string `json:"role"`
+	UID           uint   `json:"uid"`
+	EmailVerified bool   `json:"email_verified"`
 	jwt.RegisteredClaims
 }
 
-func (s *jwtService) GenerateToken(u *model.User, ttl time.Duration) (string, error) {
+func (s *jwtService) GenerateToken(u *model.User, ttl time.Duration) (string, string, error) {
+	if s.active == nil {
+		return "", "", errors.New("no active signing key")
+	}
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
 	now := time.Now()
 	claims := &userClaims{
-		Role: string(u.Role),
-		UID:  u.ID,
+		Role:          string(u.Role),
+		UID:           u.ID,
+		EmailVerified: u.EmailVerified(),
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 			Subject:   "user_auth",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.secret))
+	token := jwt.NewWithClaims(s.active.method, claims)
+	token.Header["kid"] = s.active.kid
+	signed, err := token.SignedString(s.active.key)
+	if err != nil {
+		return "", "", err
+	}
+	return signed, jti, nil
+}
+
+// randomToken returns n random bytes, hex encoded.
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
 }
 
 func (s *jwtService) ParseToken(tokenStr string) (*jwt.RegisteredClaims, model.Role, uint, error) {
 	parsed, err := jwt.ParseWithClaims(tokenStr, &userClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.secret), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
 	if err != nil || !parsed.Valid {
 		return nil, "", 0, errors.New("invalid token")
 	}
@@ -62,3 +138,7 @@ func (s *jwtService) ParseToken(tokenStr string) (*jwt.RegisteredClaims, model.R
 	}
 	return &cl.RegisteredClaims, model.Role(cl.Role), cl.UID, nil
 }
+
+func (s *jwtService) JWKS() model.JWKS {
+	return s.jwks
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	models "user-service/internal/models"
+	"user-service/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// CreateDeposit opens a payment intent with the payment provider and returns
+// it with the checkout URL the user pays through. The wallet is credited by
+// HandlePaymentWebhook once the provider confirms the payment.
+func (s *walletService) CreateDeposit(userID uint, amount int64, currency, description, idempotencyKey string) (*models.PaymentIntent, error) {
+
+	s.logger.Info("service deposit attempt", "user_id", userID, "amount", amount, "currency", currency)
+
+	if amount <= 0 {
+		return nil, utils.ErrAmountMustBePositive
+	}
+	if idempotencyKey != "" {
+		previous, err := s.repo.GetPaymentIntentByIdempotencyKey(userID, idempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil {
+			return s.replayDeposit(previous, amount, currency)
+		}
+	}
+
+	reference, err := newPaymentReference()
+	if err != nil {
+		return nil, err
+	}
+	intent := &models.PaymentIntent{
+		UserID:         userID,
+		Amount:         amount,
+		Currency:       currency,
+		Description:    description,
+		Status:         models.PaymentIntentPending,
+		Reference:      reference,
+		IdempotencyKey: optionalKey(idempotencyKey),
+	}
+	if err := s.repo.CreatePaymentIntent(intent); err != nil {
+		// A concurrent request with the same key created its intent first.
+		if idempotencyKey != "" && errors.Is(err, gorm.ErrDuplicatedKey) {
+			previous, lookupErr := s.repo.GetPaymentIntentByIdempotencyKey(userID, idempotencyKey)
+			if lookupErr == nil && previous != nil {
+				return s.replayDeposit(previous, amount, currency)
+			}
+		}
+		s.logger.Error("service deposit failed", "user_id", userID, "err", err.Error())
+		return nil, err
+	}
+
+	checkoutURL, err := s.payments.CreateCheckout(intent)
+	if err != nil {
+		intent.Status = models.PaymentIntentFailed
+		intent.FailureReason = err.Error()
+	} else {
+		intent.CheckoutURL = checkoutURL
+	}
+	if err := s.repo.SavePaymentIntent(intent); err != nil {
+		return nil, err
+	}
+	if intent.Status == models.PaymentIntentFailed {
+		s.logger.Error("service deposit checkout failed", "reference", intent.Reference, "reason", intent.FailureReason)
+		return nil, fmt.Errorf("payment provider: %s", intent.FailureReason)
+	}
+	s.logger.Info("service deposit created", "user_id", userID, "reference", intent.Reference)
+	return intent, nil
+}
+
+// replayDeposit returns the intent already created under the idempotency key
+// if it was created for the same amount and currency.
+func (s *walletService) replayDeposit(previous *models.PaymentIntent, amount int64, currency string) (*models.PaymentIntent, error) {
+	if previous.Amount != amount || previous.Currency != currency {
+		return nil, utils.ErrIdempotencyKeyReused
+	}
+	s.logger.Info("service deposit replayed", "user_id", previous.UserID, "reference", previous.Reference)
+	return previous, nil
+}
+
+// HandlePaymentWebhook applies a signed provider notification to its payment
+// intent. Providers redeliver webhooks, so notifications for intents that
+// are no longer pending are acknowledged without changes.
+func (s *walletService) HandlePaymentWebhook(body []byte, signature string) error {
+	event, err := s.payments.ParseWebhook(body, signature)
+	if err != nil {
+		s.logger.Warn("service payment webhook rejected", "err", err.Error())
+		return err
+	}
+
+	s.logger.Info("service payment webhook", "reference", event.Reference, "status", event.Status)
+
+	return s.db.Transaction(func(txDB *gorm.DB) error {
+		walletRepo := s.repo.WithDB(txDB)
+
+		intent, err := walletRepo.GetPaymentIntentByReferenceWithLock(event.Reference)
+		if err != nil {
+			return err
+		}
+		if intent == nil {
+			return utils.ErrPaymentIntentNotFound
+		}
+		if intent.Status != models.PaymentIntentPending {
+			s.logger.Info("service payment webhook replayed", "reference", intent.Reference, "status", intent.Status)
+			return nil
+		}
+
+		if event.Status == models.PaymentIntentSucceeded {
+			transaction, err := creditDeposit(walletRepo, intent.UserID, intent.Currency, intent.Amount, intent.Description, "payment-"+intent.Reference)
+			if err != nil {
+				return err
+			}
+			intent.TransactionID = &transaction.ID
+		} else {
+			intent.FailureReason = event.Reason
+		}
+		intent.Status = event.Status
+		return walletRepo.SavePaymentIntent(intent)
+	})
+}
+
+func (s *walletService) ListDeposits(userID uint, limit, offset int) ([]models.PaymentIntent, error) {
+	s.logger.Info("service list deposits", "user_id", userID, "limit", limit, "offset", offset)
+	return s.repo.ListPaymentIntents(userID, limit, offset)
+}
+
+func newPaymentReference() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate payment reference: %w", err)
+	}
+	return "pi_" + hex.EncodeToString(buf), nil
+}
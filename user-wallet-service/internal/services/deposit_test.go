@@ -0,0 +1,57 @@
+package services
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"user-service/internal/config"
+	models "user-service/internal/models"
+	"user-service/internal/repository"
+)
+
+// racingIntentRepository runs a concurrent request during the first
+// idempotency lookup and answers it as it stood before that request.
+type racingIntentRepository struct {
+	repository.WalletRepository
+	concurrent func()
+	started    atomic.Bool
+}
+
+func (r *racingIntentRepository) GetPaymentIntentByIdempotencyKey(userID uint, key string) (*models.PaymentIntent, error) {
+	if r.started.CompareAndSwap(false, true) {
+		previous, err := r.WalletRepository.GetPaymentIntentByIdempotencyKey(userID, key)
+		r.concurrent()
+		return previous, err
+	}
+	return r.WalletRepository.GetPaymentIntentByIdempotencyKey(userID, key)
+}
+
+func TestCreateDepositConcurrentRetryReturnsStoredIntent(t *testing.T) {
+	s := newTestWalletService(newTestDB(t), config.SettlementConfig{}, nil)
+	repo := &racingIntentRepository{WalletRepository: s.repo}
+	s.repo = repo
+
+	var concurrent *models.PaymentIntent
+	var concurrentErr error
+	repo.concurrent = func() {
+		concurrent, concurrentErr = s.CreateDeposit(2, 500, models.DefaultCurrency, "top up", "deposit-1")
+	}
+	intent, err := s.CreateDeposit(2, 500, models.DefaultCurrency, "top up", "deposit-1")
+
+	if concurrentErr != nil {
+		t.Fatalf("concurrent deposit: %v", concurrentErr)
+	}
+	if err != nil {
+		t.Fatalf("deposit: %v", err)
+	}
+	if intent.Reference != concurrent.Reference {
+		t.Fatalf("got intents %s and %s, want the same one", intent.Reference, concurrent.Reference)
+	}
+	var count int64
+	if err := s.db.Model(&models.PaymentIntent{}).Count(&count).Error; err != nil {
+		t.Fatalf("count intents: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("got %d intents, want 1", count)
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"user-service/internal/config"
+	models "user-service/internal/models"
+	"user-service/internal/repository"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens a fresh SQLite database with the wallet schema. SQLite
+// ignores FOR UPDATE and serializes writers, so races are reproduced by
+// running the competing call from inside a provider call.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "wallet.db") + "?_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{TranslateError: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Wallet{}, &models.Transaction{}, &models.Hold{},
+		&models.LedgerAccount{}, &models.LedgerEntry{}, &models.LedgerPosting{}, &models.Withdrawal{}, &models.PaymentIntent{},
+		&models.RefreshToken{}, &models.RevokedToken{}, &models.UserToken{}, &models.MFARecoveryCode{},
+		&models.LoginAttempt{}, &models.LoginThrottle{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func newTestWalletService(db *gorm.DB, settlement config.SettlementConfig, payouts PayoutProvider) *walletService {
+	if payouts == nil {
+		payouts = NewFakePayoutProvider()
+	}
+	repo := repository.NewWalletRepository(db, testLogger())
+	return NewWalletService(repo, db, settlement, NewMockPaymentProvider("secret"), payouts, testLogger()).(*walletService)
+}
+
+// deposit credits the user's wallet as a confirmed payment would.
+func deposit(t *testing.T, s *walletService, userID uint, amount int64) {
+	t.Helper()
+	err := s.db.Transaction(func(txDB *gorm.DB) error {
+		_, err := creditDeposit(s.repo.WithDB(txDB), userID, models.DefaultCurrency, amount, "test deposit", "")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("deposit: %v", err)
+	}
+}
+
+// ledgerBalance returns the sum of postings of one account.
+func ledgerBalance(t *testing.T, s *walletService, account models.LedgerAccountType, userID uint) int64 {
+	t.Helper()
+	balances, err := s.repo.LedgerBalances()
+	if err != nil {
+		t.Fatalf("ledger balances: %v", err)
+	}
+	for _, b := range balances {
+		if b.Type == account && b.UserID == userID && b.Currency == models.DefaultCurrency {
+			return b.Balance
+		}
+	}
+	return 0
+}
+
+// assertReconciled checks that the ledger is balanced and matches every
+// wallet.
+func assertReconciled(t *testing.T, s *walletService) {
+	t.Helper()
+	report, err := s.Reconcile()
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if !report.Balanced {
+		t.Fatalf("ledger not reconciled: %+v", report)
+	}
+}
+
+func newTestUserService(t *testing.T, db *gorm.DB) (*userService, *MemoryMailSender) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	jwt := NewJWTService(config.SigningKeys{Keys: []config.SigningKey{{KID: "test", Key: key}}, ActiveKID: "test"})
+	userRepo := repository.NewUserRepository(db, testLogger())
+	sessions := NewSessionService(repository.NewSessionRepository(db, testLogger()), userRepo, jwt, db,
+		config.SessionConfig{AccessTTL: time.Minute, RefreshTTL: time.Hour}, testLogger())
+	guard := NewLoginGuard(repository.NewLoginAttemptRepository(db, testLogger()), db, testLoginGuardConfig(), testLogger())
+	mail := NewMemoryMailSender()
+	mailCfg := config.MailConfig{AppBaseURL: "http://app", PasswordResetTTL: time.Hour, EmailVerificationTTL: time.Hour}
+	users := NewUserService(userRepo, repository.NewUserTokenRepository(db, testLogger()), repository.NewMFARepository(db, testLogger()),
+		sessions, guard, mail, mailCfg, config.MFAConfig{Issuer: "Test", ChallengeTTL: time.Minute, LockoutAttempts: 3, LockoutDuration: time.Hour}, db, testLogger())
+	return users.(*userService), mail
+}
+
+func testLoginGuardConfig() config.LoginGuardConfig {
+	return config.LoginGuardConfig{
+		EmailFreeAttempts:    2,
+		EmailLockoutAttempts: 4,
+		IPFreeAttempts:       10,
+		IPLockoutAttempts:    100,
+		BaseDelay:            time.Millisecond,
+		MaxDelay:             time.Millisecond,
+		LockoutDuration:      time.Hour,
+		FailureWindow:        time.Hour,
+		AttemptRetention:     90 * 24 * time.Hour,
+	}
+}
+
+func registerUser(t *testing.T, s *userService, email string) *models.User {
+	t.Helper()
+	u, _, err := s.Register(email, "password")
+	if err != nil {
+		t.Fatalf("register %s: %v", email, err)
+	}
+	return u
+}
+
+// mailedToken returns the token of the last link mailed to the address.
+func mailedToken(t *testing.T, mail *MemoryMailSender, to string) string {
+	t.Helper()
+	sent := mail.Sent()
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].To != to {
+			continue
+		}
+		start := strings.Index(sent[i].Body, "http")
+		end := strings.IndexAny(sent[i].Body[start:], "\n ")
+		link, err := url.Parse(sent[i].Body[start : start+end])
+		if err != nil {
+			t.Fatalf("parse link: %v", err)
+		}
+		return link.Query().Get("token")
+	}
+	t.Fatalf("no email sent to %s", to)
+	return ""
+}
+
+func noStepUp(*gorm.DB) error { return nil }
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+
+	models "user-service/internal/models"
+	"user-service/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// walletKey identifies a wallet by its owner and currency.
+type walletKey struct {
+	userID   uint
+	currency string
+}
+
+// ledgerPosting is a posting before its account is resolved.
+type ledgerPosting struct {
+	account models.LedgerAccountType
+	userID  uint
+	amount  int64
+}
+
+func increase(account models.LedgerAccountType, userID uint, amount int64) ledgerPosting {
+	return ledgerPosting{account: account, userID: userID, amount: amount}
+}
+
+func decrease(account models.LedgerAccountType, userID uint, amount int64) ledgerPosting {
+	return ledgerPosting{account: account, userID: userID, amount: -amount}
+}
+
+// postEntry writes a balanced ledger entry on the accounts of one currency;
+// it runs in the same database transaction as the wallet change it describes.
+// Zero postings are skipped.
+func postEntry(walletRepo repository.WalletRepository, currency string, kind models.TransactionType, transactionID uint, description string, postings ...ledgerPosting) error {
+	entry := models.LedgerEntry{Kind: kind, TransactionID: transactionID, Description: description}
+	var total int64
+	for _, p := range postings {
+		if p.amount == 0 {
+			continue
+		}
+		account, err := walletRepo.GetLedgerAccount(p.account, p.userID, currency)
+		if err != nil {
+			return err
+		}
+		entry.Postings = append(entry.Postings, models.LedgerPosting{AccountID: account.ID, Amount: p.amount})
+		total += p.amount
+	}
+	if total != 0 {
+		return fmt.Errorf("unbalanced ledger entry for %s transaction %d: postings sum to %d %s", kind, transactionID, total, currency)
+	}
+	if len(entry.Postings) == 0 {
+		return nil
+	}
+	return walletRepo.CreateLedgerEntry(&entry)
+}
+
+// holdPostings books a single-wallet hold operation. A plain capture keeps
+// the money on the platform.
+func holdPostings(tran *models.Transaction) []ledgerPosting {
+	userID, amount := tran.UserID, tran.Amount
+	switch tran.Type {
+	case models.TransactionFreeze:
+		return []ledgerPosting{
+			increase(models.LedgerUserHeld, userID, amount),
+			decrease(models.LedgerUserAvailable, userID, amount),
+		}
+	case models.TransactionUnfreeze:
+		return []ledgerPosting{
+			increase(models.LedgerUserAvailable, userID, amount),
+			decrease(models.LedgerUserHeld, userID, amount),
+		}
+	case models.TransactionCharge:
+		return append(capturePostings(tran), increase(models.LedgerPlatformRevenue, 0, amount))
+	}
+	return nil
+}
+
+// capturePostings books the buyer's side of a capture: the whole hold leaves
+// user_held, the captured amount is left for the caller to credit and the
+// rest of a partially captured hold returns to user_available.
+func capturePostings(charge *models.Transaction) []ledgerPosting {
+	held := charge.FrozenBefore - charge.FrozenAfter
+	return []ledgerPosting{
+		decrease(models.LedgerUserHeld, charge.UserID, held),
+		increase(models.LedgerUserAvailable, charge.UserID, held-charge.Amount),
+	}
+}
+
+// OpenLedgerBalances gives every wallet without ledger accounts an opening
+// entry for its current balance, so wallets from before the ledger reconcile.
+func (s *walletService) OpenLedgerBalances() error {
+	wallets, err := s.repo.ListWallets()
+	if err != nil {
+		return err
+	}
+	for _, w := range wallets {
+		if w.Balance == 0 && w.FrozenBalance == 0 {
+			continue
+		}
+		err := s.db.Transaction(func(txDB *gorm.DB) error {
+			walletRepo := s.repo.WithDB(txDB)
+			wallet, err := walletRepo.GetByUserIDWithLock(w.UserID, w.Currency)
+			if err != nil {
+				return err
+			}
+			opened, err := walletRepo.HasLedgerAccounts(w.UserID, w.Currency)
+			if err != nil || opened {
+				return err
+			}
+			return postEntry(walletRepo, wallet.Currency, models.LedgerEntryOpening, 0, "opening balance",
+				increase(models.LedgerUserAvailable, wallet.UserID, wallet.Balance-wallet.FrozenBalance),
+				increase(models.LedgerUserHeld, wallet.UserID, wallet.FrozenBalance),
+				decrease(models.LedgerExternalCash, 0, wallet.Balance),
+			)
+		})
+		if err != nil {
+			s.logger.Error("service open ledger balance failed", "user_id", w.UserID, "currency", w.Currency, "err", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// Reconcile checks that every ledger entry is balanced and that each wallet,
+// a projection of the ledger, matches the sum of its accounts' postings in
+// the wallet's currency.
+func (s *walletService) Reconcile() (*models.ReconciliationReport, error) {
+	report := models.ReconciliationReport{
+		PostingsTotal:   map[string]int64{},
+		PlatformRevenue: map[string]int64{},
+		ExternalCash:    map[string]int64{},
+		PayoutsPending:  map[string]int64{},
+	}
+	err := s.db.Transaction(func(txDB *gorm.DB) error {
+		walletRepo := s.repo.WithDB(txDB)
+
+		unbalanced, err := walletRepo.UnbalancedLedgerEntries()
+		if err != nil {
+			return err
+		}
+		balances, err := walletRepo.LedgerBalances()
+		if err != nil {
+			return err
+		}
+		wallets, err := walletRepo.ListWallets()
+		if err != nil {
+			return err
+		}
+
+		available := map[walletKey]int64{}
+		held := map[walletKey]int64{}
+		for _, b := range balances {
+			report.PostingsTotal[b.Currency] += b.Balance
+			key := walletKey{userID: b.UserID, currency: b.Currency}
+			switch b.Type {
+			case models.LedgerUserAvailable:
+				available[key] = b.Balance
+			case models.LedgerUserHeld:
+				held[key] = b.Balance
+			case models.LedgerPlatformRevenue:
+				report.PlatformRevenue[b.Currency] = b.Balance
+			case models.LedgerExternalCash:
+				report.ExternalCash[b.Currency] = b.Balance
+			case models.LedgerPayoutsPending:
+				report.PayoutsPending[b.Currency] = b.Balance
+			}
+		}
+
+		report.UnbalancedEntries = unbalanced
+		if report.UnbalancedEntries == nil {
+			report.UnbalancedEntries = []uint{}
+		}
+		report.Mismatches = []models.ReconciliationMismatch{}
+		for _, w := range wallets {
+			key := walletKey{userID: w.UserID, currency: w.Currency}
+			ledgerBalance := available[key] + held[key]
+			if w.Balance != ledgerBalance || w.FrozenBalance != held[key] {
+				report.Mismatches = append(report.Mismatches, models.ReconciliationMismatch{
+					UserID:        w.UserID,
+					Currency:      w.Currency,
+					Balance:       w.Balance,
+					LedgerBalance: ledgerBalance,
+					FrozenBalance: w.FrozenBalance,
+					LedgerHeld:    held[key],
+				})
+			}
+		}
+		report.WalletsChecked = len(wallets)
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		s.logger.Error("service reconcile failed", "err", err.Error())
+		return nil, err
+	}
+
+	report.Balanced = len(report.UnbalancedEntries) == 0 && len(report.Mismatches) == 0
+	for _, total := range report.PostingsTotal {
+		if total != 0 {
+			report.Balanced = false
+		}
+	}
+	s.logger.Info("service reconcile", "balanced", report.Balanced, "wallets", report.WalletsChecked, "mismatches", len(report.Mismatches))
+	return &report, nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+
+	"user-service/internal/config"
+	models "user-service/internal/models"
+)
+
+var lotRef = models.HoldReference{ReferenceType: "lot", ReferenceID: "7"}
+
+func TestPartialCaptureReleasesRestInLedger(t *testing.T) {
+	s := newTestWalletService(newTestDB(t), config.SettlementConfig{}, nil)
+	deposit(t, s, 2, 1000)
+	if _, _, err := s.Hold(2, lotRef, 600, models.DefaultCurrency, "bid", "hold-1"); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+
+	wallet, _, err := s.CaptureHold(2, lotRef, 400, "win", "capture-1")
+	if err != nil {
+		t.Fatalf("capture: %v", err)
+	}
+
+	if wallet.Balance != 600 || wallet.FrozenBalance != 0 {
+		t.Fatalf("wallet balance %d frozen %d, want 600 and 0", wallet.Balance, wallet.FrozenBalance)
+	}
+	if held := ledgerBalance(t, s, models.LedgerUserHeld, 2); held != 0 {
+		t.Fatalf("user_held = %d, want 0", held)
+	}
+	if available := ledgerBalance(t, s, models.LedgerUserAvailable, 2); available != 600 {
+		t.Fatalf("user_available = %d, want 600", available)
+	}
+	if revenue := ledgerBalance(t, s, models.LedgerPlatformRevenue, 0); revenue != 400 {
+		t.Fatalf("platform_revenue = %d, want 400", revenue)
+	}
+	assertReconciled(t, s)
+}
+
+func TestPartialPayoutReleasesRestInLedger(t *testing.T) {
+	s := newTestWalletService(newTestDB(t), config.SettlementConfig{CommissionBPS: 1000}, nil)
+	deposit(t, s, 2, 1000)
+	if _, _, err := s.Hold(2, lotRef, 700, models.DefaultCurrency, "bid", "hold-1"); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+
+	if _, _, err := s.PayoutHold(2, lotRef, 500, 3, "sold", "payout-1"); err != nil {
+		t.Fatalf("payout: %v", err)
+	}
+
+	if held := ledgerBalance(t, s, models.LedgerUserHeld, 2); held != 0 {
+		t.Fatalf("buyer user_held = %d, want 0", held)
+	}
+	if available := ledgerBalance(t, s, models.LedgerUserAvailable, 2); available != 500 {
+		t.Fatalf("buyer user_available = %d, want 500", available)
+	}
+	if available := ledgerBalance(t, s, models.LedgerUserAvailable, 3); available != 450 {
+		t.Fatalf("seller user_available = %d, want 450", available)
+	}
+	if revenue := ledgerBalance(t, s, models.LedgerPlatformRevenue, 0); revenue != 50 {
+		t.Fatalf("platform_revenue = %d, want 50", revenue)
+	}
+	assertReconciled(t, s)
+}
+
+func TestLegacyChargeKeepsRestHeldInLedger(t *testing.T) {
+	s := newTestWalletService(newTestDB(t), config.SettlementConfig{}, nil)
+	deposit(t, s, 2, 1000)
+	if _, _, err := s.LegacyFreeze(2, 500, models.DefaultCurrency, "freeze", "freeze-1"); err != nil {
+		t.Fatalf("freeze: %v", err)
+	}
+
+	if _, _, err := s.LegacyCharge(2, 200, models.DefaultCurrency, "charge", "charge-1"); err != nil {
+		t.Fatalf("charge: %v", err)
+	}
+
+	if held := ledgerBalance(t, s, models.LedgerUserHeld, 2); held != 300 {
+		t.Fatalf("user_held = %d, want 300", held)
+	}
+	assertReconciled(t, s)
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	models "user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/utils"
+)
+
+// The deprecated /freeze, /unfreeze and /charge endpoints keep working for
+// clients that have not moved to holds yet. They operate on the wallet's
+// legacy hold, which also holds the frozen balances migrated from before holds.
+
+func (s *walletService) LegacyFreeze(userID uint, amount int64, currency, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error) {
+	wallet, err := s.GetWallet(userID, currency)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.Hold(userID, models.LegacyHoldReference(wallet.ID), amount, currency, description, idempotencyKey)
+}
+
+func (s *walletService) LegacyUnfreeze(userID uint, amount int64, currency, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error) {
+	wallet, err := s.GetWallet(userID, currency)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.ReleaseHold(userID, models.LegacyHoldReference(wallet.ID), amount, description, idempotencyKey)
+}
+
+// LegacyCharge charges amount from the legacy hold. Unlike CaptureHold it
+// leaves the hold open with the rest frozen, as the old /charge did with the
+// frozen balance, so later legacy freezes can still top it up.
+func (s *walletService) LegacyCharge(userID uint, amount int64, currency, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error) {
+	wallet, err := s.GetWallet(userID, currency)
+	if err != nil {
+		return nil, nil, err
+	}
+	ref := models.LegacyHoldReference(wallet.ID)
+	return s.applyHold(userID, currency, models.TransactionCharge, amount, description, idempotencyKey, func(walletRepo repository.WalletRepository, wallet *models.Wallet) (*models.Hold, int64, error) {
+		hold, err := activeHold(walletRepo, userID, ref, amount)
+		if err != nil {
+			return nil, 0, err
+		}
+		wallet.Balance -= amount
+		if wallet.Balance < 0 {
+			return nil, 0, utils.ErrResultingBalanceNegative
+		}
+		hold.Amount -= amount
+		hold.CapturedAmount += amount
+		return hold, amount, nil
+	})
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"user-service/internal/config"
+	model "user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// LoginLockedError rejects a login while the email or the client IP is
+// backed off or locked out.
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", utils.ErrLoginLocked, e.RetryAfter)
+}
+
+func (e *LoginLockedError) Unwrap() error {
+	return utils.ErrLoginLocked
+}
+
+// LoginGuard throttles failed logins per email and per client IP and keeps
+// the audit log of login attempts.
+type LoginGuard interface {
+	// Check returns a *LoginLockedError while logins for the email or the
+	// IP are throttled.
+	Check(email, ip string) error
+	// Reserve is Check for a password login: in the same transaction, under
+	// the throttles' row locks, it counts the attempt as failed before the
+	// password is checked, so concurrent guesses cannot all pass the check.
+	// Release takes the attempt back once the password proves right.
+	Reserve(email, ip string) error
+	Release(email, ip string)
+	RecordFailure(email, ip string, userID *uint, result model.LoginResult)
+	RecordSuccess(email, ip string, userID *uint, result model.LoginResult)
+	// Audit only logs the attempt, e.g. a wrong password Reserve has
+	// already counted.
+	Audit(email, ip string, userID *uint, result model.LoginResult)
+	// Purge deletes audit entries older than the retention and throttles
+	// that no longer hold any failures or locks.
+	Purge() (int64, error)
+	Unlock(email string) error
+	ListAttempts(email string, limit int) ([]model.LoginAttempt, error)
+}
+
+type loginGuard struct {
+	repo   repository.LoginAttemptRepository
+	db     *gorm.DB
+	cfg    config.LoginGuardConfig
+	logger *slog.Logger
+}
+
+func NewLoginGuard(repo repository.LoginAttemptRepository, db *gorm.DB, cfg config.LoginGuardConfig, logger *slog.Logger) LoginGuard {
+	return &loginGuard{repo: repo, db: db, cfg: cfg, logger: logger}
+}
+
+func emailThrottleKey(email string) string { return "email:" + email }
+func ipThrottleKey(ip string) string       { return "ip:" + ip }
+
+func (g *loginGuard) Check(email, ip string) error {
+	throttles, err := g.repo.ListLoginThrottles([]string{emailThrottleKey(email), ipThrottleKey(ip)})
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	var wait time.Duration
+	for _, t := range throttles {
+		if t.LockedUntil != nil && t.LockedUntil.After(now) && t.LockedUntil.Sub(now) > wait {
+			wait = t.LockedUntil.Sub(now)
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+	g.audit(email, ip, nil, model.LoginLocked)
+	return &LoginLockedError{RetryAfter: (wait + time.Second - 1).Truncate(time.Second)}
+}
+
+func (g *loginGuard) Reserve(email, ip string) error {
+	var wait time.Duration
+	err := g.db.Transaction(func(txDB *gorm.DB) error {
+		repo := g.repo.WithDB(txDB)
+		now := time.Now().UTC()
+
+		emailThrottle, err := repo.GetLoginThrottleWithLock(emailThrottleKey(email))
+		if err != nil {
+			return err
+		}
+		ipThrottle, err := repo.GetLoginThrottleWithLock(ipThrottleKey(ip))
+		if err != nil {
+			return err
+		}
+		for _, t := range []*model.LoginThrottle{emailThrottle, ipThrottle} {
+			if t.LockedUntil != nil && t.LockedUntil.After(now) && t.LockedUntil.Sub(now) > wait {
+				wait = t.LockedUntil.Sub(now)
+			}
+		}
+		if wait > 0 {
+			return nil
+		}
+		if err := g.countFailure(repo, emailThrottle, g.cfg.EmailFreeAttempts, g.cfg.EmailLockoutAttempts, now); err != nil {
+			return err
+		}
+		return g.countFailure(repo, ipThrottle, g.cfg.IPFreeAttempts, g.cfg.IPLockoutAttempts, now)
+	})
+	if err != nil {
+		return err
+	}
+	if wait == 0 {
+		return nil
+	}
+	g.audit(email, ip, nil, model.LoginLocked)
+	return &LoginLockedError{RetryAfter: (wait + time.Second - 1).Truncate(time.Second)}
+}
+
+func (g *loginGuard) Release(email, ip string) {
+	err := g.db.Transaction(func(txDB *gorm.DB) error {
+		repo := g.repo.WithDB(txDB)
+
+		if err := g.release(repo, emailThrottleKey(email), g.cfg.EmailLockoutAttempts); err != nil {
+			return err
+		}
+		return g.release(repo, ipThrottleKey(ip), g.cfg.IPLockoutAttempts)
+	})
+	if err != nil {
+		g.logger.Error("service release login attempt failed", "err", err.Error())
+	}
+}
+
+// release takes back one failure. Reserve only counts an attempt while the
+// throttle is unlocked, so below the lockout the lock left is the one the
+// reserved attempt started, even a lockout it reached, and it is lifted.
+func (g *loginGuard) release(repo repository.LoginAttemptRepository, key string, lockout int) error {
+	t, err := repo.GetLoginThrottleWithLock(key)
+	if err != nil {
+		return err
+	}
+	if t.Failures == 0 {
+		return nil
+	}
+	t.Failures--
+	if t.Failures < lockout {
+		t.LockedUntil = nil
+	}
+	return repo.SaveLoginThrottle(t)
+}
+
+// RecordFailure counts a failed attempt against both the email and the IP
+// and backs them off once their free attempts are used up. Errors are
+// logged: a failing audit must not turn a wrong password into a 500.
+func (g *loginGuard) RecordFailure(email, ip string, userID *uint, result model.LoginResult) {
+	g.audit(email, ip, userID, result)
+
+	err := g.db.Transaction(func(txDB *gorm.DB) error {
+		repo := g.repo.WithDB(txDB)
+		now := time.Now().UTC()
+
+		if err := g.fail(repo, emailThrottleKey(email), g.cfg.EmailFreeAttempts, g.cfg.EmailLockoutAttempts, now); err != nil {
+			return err
+		}
+		return g.fail(repo, ipThrottleKey(ip), g.cfg.IPFreeAttempts, g.cfg.IPLockoutAttempts, now)
+	})
+	if err != nil {
+		g.logger.Error("service record login failure failed", "err", err.Error())
+	}
+}
+
+func (g *loginGuard) fail(repo repository.LoginAttemptRepository, key string, free, lockout int, now time.Time) error {
+	t, err := repo.GetLoginThrottleWithLock(key)
+	if err != nil {
+		return err
+	}
+	return g.countFailure(repo, t, free, lockout, now)
+}
+
+// countFailure adds a failure to the locked throttle and backs it off or
+// locks it out.
+func (g *loginGuard) countFailure(repo repository.LoginAttemptRepository, t *model.LoginThrottle, free, lockout int, now time.Time) error {
+	if t.LastFailureAt != nil && now.Sub(*t.LastFailureAt) > g.cfg.FailureWindow {
+		t.Failures = 0
+	}
+	t.Failures++
+	t.LastFailureAt = &now
+
+	switch {
+	case t.Failures >= lockout:
+		until := now.Add(g.cfg.LockoutDuration)
+		t.LockedUntil = &until
+		g.logger.Warn("service login locked out", "key", t.Key, "failures", t.Failures, "until", until)
+	case t.Failures > free:
+		delay := g.cfg.MaxDelay
+		if shift := t.Failures - free - 1; shift < 32 && g.cfg.BaseDelay<<shift < g.cfg.MaxDelay {
+			delay = g.cfg.BaseDelay << shift
+		}
+		until := now.Add(delay)
+		t.LockedUntil = &until
+	}
+	return repo.SaveLoginThrottle(t)
+}
+
+// RecordSuccess resets the failures of the email. The IP keeps its count, or
+// an attacker could clear it by logging into an own account.
+func (g *loginGuard) RecordSuccess(email, ip string, userID *uint, result model.LoginResult) {
+	g.audit(email, ip, userID, result)
+	if result != model.LoginSucceeded {
+		return
+	}
+	if err := g.reset(email); err != nil {
+		g.logger.Error("service reset login throttle failed", "err", err.Error())
+	}
+}
+
+func (g *loginGuard) Unlock(email string) error {
+	if err := g.reset(email); err != nil {
+		return err
+	}
+	g.logger.Info("service login unlocked", "email", email)
+	return nil
+}
+
+func (g *loginGuard) reset(email string) error {
+	return g.db.Transaction(func(txDB *gorm.DB) error {
+		repo := g.repo.WithDB(txDB)
+
+		t, err := repo.GetLoginThrottleWithLock(emailThrottleKey(email))
+		if err != nil {
+			return err
+		}
+		if t.Failures == 0 && t.LockedUntil == nil {
+			return nil
+		}
+		t.Failures = 0
+		t.LockedUntil = nil
+		return repo.SaveLoginThrottle(t)
+	})
+}
+
+func (g *loginGuard) ListAttempts(email string, limit int) ([]model.LoginAttempt, error) {
+	return g.repo.ListLoginAttempts(email, limit)
+}
+
+func (g *loginGuard) Audit(email, ip string, userID *uint, result model.LoginResult) {
+	g.audit(email, ip, userID, result)
+}
+
+func (g *loginGuard) Purge() (int64, error) {
+	now := time.Now().UTC()
+	attempts, err := g.repo.DeleteLoginAttemptsBefore(now.Add(-g.cfg.AttemptRetention))
+	if err != nil {
+		return 0, err
+	}
+	throttles, err := g.repo.DeleteIdleLoginThrottles(now, now.Add(-g.cfg.FailureWindow))
+	if err != nil {
+		return attempts, err
+	}
+	return attempts + throttles, nil
+}
+
+func (g *loginGuard) audit(email, ip string, userID *uint, result model.LoginResult) {
+	attempt := &model.LoginAttempt{Email: email, IP: ip, UserID: userID, Result: result}
+	if err := g.repo.CreateLoginAttempt(attempt); err != nil {
+		g.logger.Error("service audit login attempt failed", "err", err.Error())
+	}
+}
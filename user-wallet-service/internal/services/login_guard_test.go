@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	models "user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/utils"
+)
+
+const testIP = "10.0.0.1"
+
+func loginThrottle(t *testing.T, s *userService, key string) models.LoginThrottle {
+	t.Helper()
+	var throttle models.LoginThrottle
+	if err := s.db.Where("key = ?", key).Limit(1).Find(&throttle).Error; err != nil {
+		t.Fatalf("load throttle: %v", err)
+	}
+	return throttle
+}
+
+func TestLoginLocksOutAfterThreshold(t *testing.T) {
+	s, _ := newTestUserService(t, newTestDB(t))
+	registerUser(t, s, "user@example.com")
+	cfg := testLoginGuardConfig()
+
+	for i := 0; i < cfg.EmailLockoutAttempts; i++ {
+		time.Sleep(5 * cfg.MaxDelay)
+		if _, _, _, err := s.Login("user@example.com", "wrong", testIP); !errors.Is(err, utils.ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected ErrInvalidCredentials, got %v", i+1, err)
+		}
+	}
+
+	_, _, _, err := s.Login("user@example.com", "password", testIP)
+	var locked *LoginLockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("expected LoginLockedError with the right password, got %v", err)
+	}
+	if locked.RetryAfter < cfg.LockoutDuration-time.Minute {
+		t.Fatalf("retry after %s, want about %s", locked.RetryAfter, cfg.LockoutDuration)
+	}
+}
+
+func TestLoginBacksOffAfterFreeAttempts(t *testing.T) {
+	s, _ := newTestUserService(t, newTestDB(t))
+	registerUser(t, s, "user@example.com")
+	cfg := testLoginGuardConfig()
+	cfg.BaseDelay = time.Minute
+	cfg.MaxDelay = time.Hour
+	s.guard = NewLoginGuard(repository.NewLoginAttemptRepository(s.db, testLogger()), s.db, cfg, testLogger())
+
+	for i := 0; i < cfg.EmailFreeAttempts; i++ {
+		if _, _, _, err := s.Login("user@example.com", "wrong", testIP); !errors.Is(err, utils.ErrInvalidCredentials) {
+			t.Fatalf("free attempt %d: expected ErrInvalidCredentials, got %v", i+1, err)
+		}
+	}
+	if _, _, _, err := s.Login("user@example.com", "wrong", testIP); !errors.Is(err, utils.ErrInvalidCredentials) {
+		t.Fatalf("first delayed attempt: expected ErrInvalidCredentials, got %v", err)
+	}
+
+	if _, _, _, err := s.Login("user@example.com", "password", testIP); !errors.Is(err, utils.ErrLoginLocked) {
+		t.Fatalf("expected ErrLoginLocked during the backoff, got %v", err)
+	}
+}
+
+func TestSuccessfulLoginsDoNotCountAgainstIP(t *testing.T) {
+	s, _ := newTestUserService(t, newTestDB(t))
+	registerUser(t, s, "user@example.com")
+	cfg := testLoginGuardConfig()
+
+	for i := 0; i <= cfg.IPLockoutAttempts/10+cfg.IPFreeAttempts; i++ {
+		if _, _, _, err := s.Login("user@example.com", "password", testIP); err != nil {
+			t.Fatalf("login %d: %v", i+1, err)
+		}
+	}
+	if throttle := loginThrottle(t, s, ipThrottleKey(testIP)); throttle.Failures != 0 || throttle.LockedUntil != nil {
+		t.Fatalf("ip throttle = %+v, want no failures", throttle)
+	}
+}
+
+// racingUserRepository runs a concurrent login while the first one is
+// between the throttle check and the password check.
+type racingUserRepository struct {
+	repository.UserRepository
+	concurrent func()
+	started    atomic.Bool
+}
+
+func (r *racingUserRepository) FindByEmail(email string) (*models.User, error) {
+	if r.started.CompareAndSwap(false, true) {
+		r.concurrent()
+	}
+	return r.UserRepository.FindByEmail(email)
+}
+
+func TestConcurrentGuessCountsBeforePasswordCheck(t *testing.T) {
+	s, _ := newTestUserService(t, newTestDB(t))
+	registerUser(t, s, "user@example.com")
+	cfg := testLoginGuardConfig()
+	for i := 0; i < cfg.EmailLockoutAttempts-1; i++ {
+		time.Sleep(5 * cfg.MaxDelay)
+		if _, _, _, err := s.Login("user@example.com", "wrong", testIP); !errors.Is(err, utils.ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected ErrInvalidCredentials, got %v", i+1, err)
+		}
+	}
+	time.Sleep(5 * cfg.MaxDelay)
+
+	repo := &racingUserRepository{UserRepository: s.repo}
+	s.repo = repo
+	var concurrentErr error
+	repo.concurrent = func() {
+		_, _, _, concurrentErr = s.Login("user@example.com", "password", testIP)
+	}
+	_, _, _, err := s.Login("user@example.com", "wrong", testIP)
+
+	if !errors.Is(err, utils.ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if !errors.Is(concurrentErr, utils.ErrLoginLocked) {
+		t.Fatalf("concurrent login with the last allowed attempt taken: expected ErrLoginLocked, got %v", concurrentErr)
+	}
+}
+
+func TestRightPasswordOnLastAttemptDoesNotLockOut(t *testing.T) {
+	users, _, _, secret := newTestMFAServices(t)
+	cfg := testLoginGuardConfig()
+	for i := 0; i < cfg.EmailLockoutAttempts-1; i++ {
+		time.Sleep(5 * cfg.MaxDelay)
+		if _, _, _, err := users.Login("mfa@example.com", "wrong", testIP); !errors.Is(err, utils.ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected ErrInvalidCredentials, got %v", i+1, err)
+		}
+	}
+	time.Sleep(5 * cfg.MaxDelay)
+
+	if _, _, err := users.LoginMFA(mfaChallenge(t, users), codeAt(t, secret, 0), testIP); err != nil {
+		t.Fatalf("login with the right password and code: %v", err)
+	}
+}
+
+func TestPurgeKeepsActiveThrottles(t *testing.T) {
+	s, _ := newTestUserService(t, newTestDB(t))
+	registerUser(t, s, "user@example.com")
+	if _, _, _, err := s.Login("other@example.com", "wrong", testIP); !errors.Is(err, utils.ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, _, _, err := s.Login("user@example.com", "password", "10.0.0.2"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	old := time.Now().UTC().Add(-100 * 24 * time.Hour)
+	if err := s.db.Model(&models.LoginAttempt{}).Where("email = ?", "user@example.com").Update("created_at", old).Error; err != nil {
+		t.Fatalf("age attempts: %v", err)
+	}
+
+	if _, err := s.guard.Purge(); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+
+	if throttle := loginThrottle(t, s, emailThrottleKey("other@example.com")); throttle.Failures != 1 {
+		t.Fatalf("throttle with a recent failure was purged: %+v", throttle)
+	}
+	if throttle := loginThrottle(t, s, emailThrottleKey("user@example.com")); throttle.ID != 0 {
+		t.Fatalf("idle throttle kept: %+v", throttle)
+	}
+	var attempts []models.LoginAttempt
+	if err := s.db.Unscoped().Find(&attempts).Error; err != nil {
+		t.Fatalf("load attempts: %v", err)
+	}
+	if len(attempts) != 1 || attempts[0].Email != "other@example.com" {
+		t.Fatalf("attempts = %+v, want only the recent one", attempts)
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// MailSender delivers emails to users.
+type MailSender interface {
+	Send(mail Mail) error
+}
+
+// fileMailSender writes every email as a file into a local outbox directory,
+// for development without a mail server.
+type fileMailSender struct {
+	dir string
+}
+
+func NewFileMailSender(dir string) (MailSender, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &fileMailSender{dir: dir}, nil
+}
+
+func (s *fileMailSender) Send(mail Mail) error {
+	suffix, err := randomToken(4)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), suffix)
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", mail.To, mail.Subject, mail.Body)
+	return os.WriteFile(filepath.Join(s.dir, name), []byte(content), 0o600)
+}
+
+// MemoryMailSender keeps sent emails in memory, for tests.
+type MemoryMailSender struct {
+	mutex sync.Mutex
+	sent  []Mail
+}
+
+func NewMemoryMailSender() *MemoryMailSender {
+	return &MemoryMailSender{}
+}
+
+func (s *MemoryMailSender) Send(mail Mail) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sent = append(s.sent, mail)
+	return nil
+}
+
+// Sent returns the emails sent so far, oldest first.
+func (s *MemoryMailSender) Sent() []Mail {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Mail(nil), s.sent...)
+}
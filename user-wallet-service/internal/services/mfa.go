@@ -0,0 +1,344 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	model "user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount = 10
+	// maxMFAAttempts is how many wrong codes end an MFA login challenge.
+	maxMFAAttempts = 5
+)
+
+// LoginMFA completes a login of a user with two factor authentication by
+// the challenge Login returned and a TOTP or recovery code. Wrong codes
+// count as failed logins of the email and towards the user's MFA lockout,
+// which a new challenge does not reset.
+func (s *userService) LoginMFA(challenge, code, ip string) (*model.User, *model.TokenPair, error) {
+	var user *model.User
+	wrongCode := false
+	err := s.db.Transaction(func(txDB *gorm.DB) error {
+		tokens := s.tokens.WithDB(txDB)
+		users := s.repo.WithDB(txDB)
+
+		t, err := tokens.GetUserTokenByHashWithLock(hashToken(challenge))
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if t == nil || t.Purpose != model.UserTokenMFAChallenge || t.UsedAt != nil || now.After(t.ExpiresAt) {
+			return utils.ErrInvalidMFAChallenge
+		}
+		user, err = users.FindByIDWithLock(t.UserID)
+		if err != nil {
+			return err
+		}
+		if user == nil || !user.TwoFactorEnabled() {
+			return utils.ErrInvalidMFAChallenge
+		}
+		if err := s.guard.Check(user.Email, ip); err != nil {
+			return err
+		}
+
+		err = s.checkSecondFactor(users, s.mfa.WithDB(txDB), user, code)
+		if errors.Is(err, utils.ErrInvalidMFACode) {
+			// Keep the attempt: the error is reported after commit.
+			wrongCode = true
+			t.Attempts++
+			if t.Attempts >= maxMFAAttempts {
+				t.UsedAt = &now
+			}
+			return tokens.SaveUserToken(t)
+		}
+		if err != nil {
+			return err
+		}
+		t.UsedAt = &now
+		return tokens.SaveUserToken(t)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if wrongCode {
+		if err := s.RecordMFAFailure(user.ID); err != nil {
+			s.logger.Error("service record mfa failure failed", "user_id", user.ID, "err", err.Error())
+		}
+		s.guard.RecordFailure(user.Email, ip, &user.ID, model.LoginInvalidMFACode)
+		s.logger.Warn("service mfa login wrong code", "user_id", user.ID)
+		return nil, nil, utils.ErrInvalidMFACode
+	}
+
+	tokens, err := s.sessions.Issue(user)
+	if err != nil {
+		s.logger.Error("service issue session failed", "user_id", user.ID, "err", err.Error())
+		return nil, nil, err
+	}
+	s.guard.RecordSuccess(user.Email, ip, &user.ID, model.LoginSucceeded)
+	s.logger.Info("service mfa login success", "user_id", user.ID)
+	return user, tokens, nil
+}
+
+// SetupTOTP generates a new TOTP secret for the user. Two factor
+// authentication stays off until EnableTOTP confirms a code from it.
+func (s *userService) SetupTOTP(id uint) (*model.MFASetupResponse, error) {
+	u, err := s.repo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errors.New("user not found")
+	}
+	if u.TwoFactorEnabled() {
+		return nil, utils.ErrMFAAlreadyEnabled
+	}
+
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	u.TOTPSecret = totpEncoding.EncodeToString(raw)
+	u.TOTPLastStep = 0
+	if err := s.repo.Update(u); err != nil {
+		return nil, err
+	}
+	s.logger.Info("service totp setup", "user_id", id)
+	return &model.MFASetupResponse{
+		Secret:          u.TOTPSecret,
+		ProvisioningURI: totpProvisioningURI(s.mfaCfg.Issuer, u.Email, u.TOTPSecret),
+	}, nil
+}
+
+// EnableTOTP turns two factor authentication on once code proves the
+// authenticator is set up, and returns the recovery codes.
+func (s *userService) EnableTOTP(id uint, code string) ([]string, error) {
+	var codes []string
+	err := s.db.Transaction(func(txDB *gorm.DB) error {
+		users := s.repo.WithDB(txDB)
+
+		u, err := users.FindByIDWithLock(id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return errors.New("user not found")
+		}
+		if u.TwoFactorEnabled() {
+			return utils.ErrMFAAlreadyEnabled
+		}
+		if u.TOTPSecret == "" {
+			return utils.ErrMFANotSetUp
+		}
+		step, ok := matchTOTP(u.TOTPSecret, normalizeMFACode(code), time.Now())
+		if !ok {
+			return utils.ErrInvalidMFACode
+		}
+		now := time.Now().UTC()
+		u.TOTPEnabledAt = &now
+		u.TOTPLastStep = step
+		if err := users.Update(u); err != nil {
+			return err
+		}
+		codes, err = s.replaceRecoveryCodes(s.mfa.WithDB(txDB), id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info("service totp enabled", "user_id", id)
+	return codes, nil
+}
+
+// DisableTOTP turns two factor authentication off; it takes a TOTP or
+// recovery code.
+func (s *userService) DisableTOTP(id uint, code string) error {
+	err := s.withSecondFactor(id, code, func(users repository.UserRepository, mfa repository.MFARepository, u *model.User) error {
+		u.TOTPSecret = ""
+		u.TOTPEnabledAt = nil
+		u.TOTPLastStep = 0
+		if err := users.Update(u); err != nil {
+			return err
+		}
+		return mfa.ReplaceRecoveryCodes(id, nil)
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Info("service totp disabled", "user_id", id)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user.
+func (s *userService) RegenerateRecoveryCodes(id uint, code string) ([]string, error) {
+	var codes []string
+	err := s.withSecondFactor(id, code, func(users repository.UserRepository, mfa repository.MFARepository, u *model.User) error {
+		var err error
+		codes, err = s.replaceRecoveryCodes(mfa, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info("service recovery codes regenerated", "user_id", id)
+	return codes, nil
+}
+
+// StepUpCheck verifies the second factor in the transaction of the
+// operation it guards.
+type StepUpCheck func(txDB *gorm.DB) error
+
+// StepUp checks the second factor before a sensitive operation. Users
+// without two factor authentication pass without a code.
+func (s *userService) StepUp(id uint, code string) StepUpCheck {
+	return func(txDB *gorm.DB) error {
+		users := s.repo.WithDB(txDB)
+
+		u, err := users.FindByIDWithLock(id)
+		if err != nil {
+			return err
+		}
+		if u == nil || !u.TwoFactorEnabled() {
+			return nil
+		}
+		if strings.TrimSpace(code) == "" {
+			return utils.ErrMFARequired
+		}
+		return s.checkSecondFactor(users, s.mfa.WithDB(txDB), u, code)
+	}
+}
+
+// RecordMFAFailure counts a wrong code in a transaction of its own, since
+// the one that checked the code rolls back. After
+// MFAConfig.LockoutAttempts failures in a row code checks are blocked for
+// MFAConfig.LockoutDuration.
+func (s *userService) RecordMFAFailure(id uint) error {
+	return s.db.Transaction(func(txDB *gorm.DB) error {
+		users := s.repo.WithDB(txDB)
+
+		u, err := users.FindByIDWithLock(id)
+		if err != nil || u == nil {
+			return err
+		}
+		u.MFAFailures++
+		if u.MFAFailures >= s.mfaCfg.LockoutAttempts {
+			until := time.Now().UTC().Add(s.mfaCfg.LockoutDuration)
+			u.MFALockedUntil = &until
+			u.MFAFailures = 0
+			s.logger.Warn("service mfa locked", "user_id", id, "until", until)
+		}
+		return users.Update(u)
+	})
+}
+
+// withSecondFactor runs fn in a transaction once code passes as the second
+// factor of the user, who must have it enabled. A wrong code is recorded
+// after the transaction rolls back.
+func (s *userService) withSecondFactor(id uint, code string, fn func(users repository.UserRepository, mfa repository.MFARepository, u *model.User) error) error {
+	err := s.db.Transaction(func(txDB *gorm.DB) error {
+		users := s.repo.WithDB(txDB)
+		mfa := s.mfa.WithDB(txDB)
+
+		u, err := s.lockTwoFactorUser(users, id)
+		if err != nil {
+			return err
+		}
+		if err := s.checkSecondFactor(users, mfa, u, code); err != nil {
+			return err
+		}
+		return fn(users, mfa, u)
+	})
+	if errors.Is(err, utils.ErrInvalidMFACode) {
+		if recordErr := s.RecordMFAFailure(id); recordErr != nil {
+			s.logger.Error("service record mfa failure failed", "user_id", id, "err", recordErr.Error())
+		}
+	}
+	return err
+}
+
+func (s *userService) lockTwoFactorUser(users repository.UserRepository, id uint) (*model.User, error) {
+	u, err := users.FindByIDWithLock(id)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errors.New("user not found")
+	}
+	if !u.TwoFactorEnabled() {
+		return nil, utils.ErrMFANotEnabled
+	}
+	return u, nil
+}
+
+// checkSecondFactor verifies code unless the user is locked out of code
+// checks, and clears the failure count once a code is accepted. u must be
+// locked.
+func (s *userService) checkSecondFactor(users repository.UserRepository, mfa repository.MFARepository, u *model.User, code string) error {
+	if u.MFALockedUntil != nil && time.Now().Before(*u.MFALockedUntil) {
+		return utils.ErrMFALocked
+	}
+	if err := s.verifySecondFactor(users, mfa, u, code); err != nil {
+		return err
+	}
+	if u.MFAFailures == 0 && u.MFALockedUntil == nil {
+		return nil
+	}
+	u.MFAFailures = 0
+	u.MFALockedUntil = nil
+	return users.Update(u)
+}
+
+// verifySecondFactor accepts a TOTP code newer than the last accepted one or
+// an unused recovery code, and burns it. u must be locked.
+func (s *userService) verifySecondFactor(users repository.UserRepository, mfa repository.MFARepository, u *model.User, code string) error {
+	code = normalizeMFACode(code)
+	if step, ok := matchTOTP(u.TOTPSecret, code, time.Now()); ok {
+		if step <= u.TOTPLastStep {
+			return utils.ErrInvalidMFACode
+		}
+		u.TOTPLastStep = step
+		return users.Update(u)
+	}
+
+	recovery, err := mfa.GetUnusedRecoveryCodeWithLock(u.ID, hashToken(code))
+	if err != nil {
+		return err
+	}
+	if recovery == nil {
+		return utils.ErrInvalidMFACode
+	}
+	now := time.Now().UTC()
+	recovery.UsedAt = &now
+	s.logger.Info("service recovery code used", "user_id", u.ID)
+	return mfa.SaveRecoveryCode(recovery)
+}
+
+func (s *userService) replaceRecoveryCodes(mfa repository.MFARepository, userID uint) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]model.MFARecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := randomToken(5)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+		rows = append(rows, model.MFARecoveryCode{UserID: userID, CodeHash: hashToken(code)})
+	}
+	if err := mfa.ReplaceRecoveryCodes(userID, rows); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeMFACode drops the separators users type into recovery codes.
+func normalizeMFACode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
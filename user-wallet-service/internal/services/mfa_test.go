@@ -0,0 +1,189 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"user-service/internal/config"
+	models "user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/utils"
+)
+
+// codeAt returns the TOTP code of secret for the step offset periods from
+// now.
+func codeAt(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	raw, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	return totpCode(raw, time.Now().Unix()/totpPeriod+offset)
+}
+
+// enableTOTP turns two factor authentication on with the code of the
+// previous period, leaving the current and the next one for the test.
+func enableTOTP(t *testing.T, s *userService, userID uint) (string, []string) {
+	t.Helper()
+	setup, err := s.SetupTOTP(userID)
+	if err != nil {
+		t.Fatalf("setup totp: %v", err)
+	}
+	recovery, err := s.EnableTOTP(userID, codeAt(t, setup.Secret, -1))
+	if err != nil {
+		t.Fatalf("enable totp: %v", err)
+	}
+	return setup.Secret, recovery
+}
+
+func newTestMFAServices(t *testing.T) (*userService, *walletService, uint, string) {
+	t.Helper()
+	db := newTestDB(t)
+	users, _ := newTestUserService(t, db)
+	wallets := newTestWalletService(db, config.SettlementConfig{}, nil)
+	u := registerUser(t, users, "mfa@example.com")
+	secret, _ := enableTOTP(t, users, u.ID)
+	deposit(t, wallets, u.ID, 1000)
+	return users, wallets, u.ID, secret
+}
+
+func TestStepUpRejectsReplayedCode(t *testing.T) {
+	users, wallets, userID, secret := newTestMFAServices(t)
+	code := codeAt(t, secret, 0)
+
+	if _, _, err := wallets.Withdraw(userID, 100, models.DefaultCurrency, "card", "", "withdraw-1", users.StepUp(userID, code)); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	_, _, err := wallets.Withdraw(userID, 100, models.DefaultCurrency, "card", "", "withdraw-2", users.StepUp(userID, code))
+	if !errors.Is(err, utils.ErrInvalidMFACode) {
+		t.Fatalf("expected ErrInvalidMFACode for a replayed code, got %v", err)
+	}
+}
+
+func TestStepUpRequiresCode(t *testing.T) {
+	users, wallets, userID, _ := newTestMFAServices(t)
+
+	_, _, err := wallets.Withdraw(userID, 100, models.DefaultCurrency, "card", "", "withdraw-1", users.StepUp(userID, ""))
+	if !errors.Is(err, utils.ErrMFARequired) {
+		t.Fatalf("expected ErrMFARequired, got %v", err)
+	}
+}
+
+func TestWithdrawRetryReplaysWithUsedCode(t *testing.T) {
+	users, wallets, userID, secret := newTestMFAServices(t)
+	code := codeAt(t, secret, 0)
+
+	_, first, err := wallets.Withdraw(userID, 100, models.DefaultCurrency, "card", "", "withdraw-1", users.StepUp(userID, code))
+	if err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	_, retried, err := wallets.Withdraw(userID, 100, models.DefaultCurrency, "card", "", "withdraw-1", users.StepUp(userID, code))
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if retried.ID != first.ID {
+		t.Fatalf("retry created withdrawal %d, want %d", retried.ID, first.ID)
+	}
+}
+
+func TestFailedWithdrawKeepsCode(t *testing.T) {
+	users, wallets, userID, secret := newTestMFAServices(t)
+	code := codeAt(t, secret, 0)
+
+	_, _, err := wallets.Withdraw(userID, 5000, models.DefaultCurrency, "card", "", "withdraw-1", users.StepUp(userID, code))
+	if !errors.Is(err, utils.ErrInsufficientAvailableBalance) {
+		t.Fatalf("expected ErrInsufficientAvailableBalance, got %v", err)
+	}
+	if _, _, err := wallets.Withdraw(userID, 100, models.DefaultCurrency, "card", "", "withdraw-2", users.StepUp(userID, code)); err != nil {
+		t.Fatalf("withdraw with the same code: %v", err)
+	}
+}
+
+func TestWrongCodesLockSecondFactor(t *testing.T) {
+	users, _, userID, secret := newTestMFAServices(t)
+
+	for i := 0; i < users.mfaCfg.LockoutAttempts; i++ {
+		if _, err := users.RegenerateRecoveryCodes(userID, "000000"); !errors.Is(err, utils.ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: expected ErrInvalidMFACode, got %v", i+1, err)
+		}
+	}
+
+	if err := users.DisableTOTP(userID, codeAt(t, secret, 0)); !errors.Is(err, utils.ErrMFALocked) {
+		t.Fatalf("expected ErrMFALocked with a valid code, got %v", err)
+	}
+}
+
+func TestStepUpFailuresCountAfterRollback(t *testing.T) {
+	users, wallets, userID, secret := newTestMFAServices(t)
+
+	for i := 0; i < users.mfaCfg.LockoutAttempts; i++ {
+		_, _, err := wallets.Withdraw(userID, 100, models.DefaultCurrency, "card", "", "", users.StepUp(userID, "000000"))
+		if !errors.Is(err, utils.ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: expected ErrInvalidMFACode, got %v", i+1, err)
+		}
+		if err := users.RecordMFAFailure(userID); err != nil {
+			t.Fatalf("record failure: %v", err)
+		}
+	}
+
+	_, _, err := wallets.Withdraw(userID, 100, models.DefaultCurrency, "card", "", "", users.StepUp(userID, codeAt(t, secret, 0)))
+	if !errors.Is(err, utils.ErrMFALocked) {
+		t.Fatalf("expected ErrMFALocked, got %v", err)
+	}
+}
+
+func TestAcceptedCodeResetsFailures(t *testing.T) {
+	users, _, userID, secret := newTestMFAServices(t)
+
+	for i := 0; i < users.mfaCfg.LockoutAttempts-1; i++ {
+		if _, err := users.RegenerateRecoveryCodes(userID, "000000"); !errors.Is(err, utils.ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: expected ErrInvalidMFACode, got %v", i+1, err)
+		}
+	}
+	if _, err := users.RegenerateRecoveryCodes(userID, codeAt(t, secret, 0)); err != nil {
+		t.Fatalf("regenerate with a valid code: %v", err)
+	}
+	if _, err := users.RegenerateRecoveryCodes(userID, "000000"); !errors.Is(err, utils.ErrInvalidMFACode) {
+		t.Fatalf("expected ErrInvalidMFACode after the reset, got %v", err)
+	}
+	if err := users.DisableTOTP(userID, codeAt(t, secret, 1)); err != nil {
+		t.Fatalf("disable with a valid code: %v", err)
+	}
+}
+
+func mfaChallenge(t *testing.T, users *userService) string {
+	t.Helper()
+	_, _, challenge, err := users.Login("mfa@example.com", "password", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if challenge == nil {
+		t.Fatal("login did not ask for a second factor")
+	}
+	return challenge.Token
+}
+
+func TestLoginMFAFailuresLockAcrossChallenges(t *testing.T) {
+	users, _, userID, secret := newTestMFAServices(t)
+	// Keep the email throttle out of the way: only the MFA lockout is under
+	// test.
+	cfg := testLoginGuardConfig()
+	cfg.EmailFreeAttempts, cfg.EmailLockoutAttempts = 100, 1000
+	users.guard = NewLoginGuard(repository.NewLoginAttemptRepository(users.db, testLogger()), users.db, cfg, testLogger())
+
+	for i := 0; i < users.mfaCfg.LockoutAttempts; i++ {
+		time.Sleep(5 * testLoginGuardConfig().MaxDelay)
+		if _, _, err := users.LoginMFA(mfaChallenge(t, users), "000000", "10.0.0.1"); !errors.Is(err, utils.ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: expected ErrInvalidMFACode, got %v", i+1, err)
+		}
+	}
+
+	time.Sleep(5 * testLoginGuardConfig().MaxDelay)
+	if _, _, err := users.LoginMFA(mfaChallenge(t, users), codeAt(t, secret, 0), "10.0.0.1"); !errors.Is(err, utils.ErrMFALocked) {
+		t.Fatalf("expected ErrMFALocked on a fresh challenge, got %v", err)
+	}
+	if _, err := users.RegenerateRecoveryCodes(userID, codeAt(t, secret, 1)); !errors.Is(err, utils.ErrMFALocked) {
+		t.Fatalf("expected the step-up lock to apply too, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	models "user-service/internal/models"
+	"user-service/internal/utils"
+)
+
+// PaymentProvider takes deposits from users on behalf of the platform.
+type PaymentProvider interface {
+	// CreateCheckout registers the intent with the provider and returns the
+	// URL where the user pays it.
+	CreateCheckout(intent *models.PaymentIntent) (string, error)
+	// ParseWebhook verifies the signature of a provider webhook and decodes
+	// its body.
+	ParseWebhook(body []byte, signature string) (*models.PaymentWebhookEvent, error)
+}
+
+// mockPaymentProvider is the local stand-in for a real provider. Nothing is
+// charged; a payment is confirmed by posting a webhook whose signature header
+// is the hex HMAC-SHA256 of the body under the shared secret.
+type mockPaymentProvider struct {
+	secret []byte
+}
+
+func NewMockPaymentProvider(secret string) PaymentProvider {
+	return &mockPaymentProvider{secret: []byte(secret)}
+}
+
+func (p *mockPaymentProvider) CreateCheckout(intent *models.PaymentIntent) (string, error) {
+	return fmt.Sprintf("mock://checkout/%s", intent.Reference), nil
+}
+
+func (p *mockPaymentProvider) ParseWebhook(body []byte, signature string) (*models.PaymentWebhookEvent, error) {
+	if len(p.secret) == 0 {
+		return nil, utils.ErrInvalidWebhookSignature
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, utils.ErrInvalidWebhookSignature
+	}
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return nil, utils.ErrInvalidWebhookSignature
+	}
+
+	var event models.PaymentWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("invalid webhook body: %w", err)
+	}
+	if event.Status != models.PaymentIntentSucceeded && event.Status != models.PaymentIntentFailed {
+		return nil, fmt.Errorf("invalid webhook status %q", event.Status)
+	}
+	return &event, nil
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	models "user-service/internal/models"
+)
+
+// PayoutProvider sends withdrawals to an external payment system.
+type PayoutProvider interface {
+	// SendPayout submits the withdrawal and returns the provider reference.
+	// It may be called again for the same withdrawal and must not pay twice.
+	SendPayout(withdrawal *models.Withdrawal) (string, error)
+	// PayoutStatus reports the state of a submitted payout and, for failed
+	// ones, the reason.
+	PayoutStatus(reference string) (models.WithdrawalStatus, string, error)
+}
+
+// fakePayoutProvider is the local stand-in for a real provider: payouts
+// complete at once, except to destinations starting with "fail", which are
+// rejected.
+type fakePayoutProvider struct{}
+
+func NewFakePayoutProvider() PayoutProvider {
+	return fakePayoutProvider{}
+}
+
+func (fakePayoutProvider) SendPayout(withdrawal *models.Withdrawal) (string, error) {
+	outcome := "ok"
+	if strings.HasPrefix(withdrawal.Destination, "fail") {
+		outcome = "fail"
+	}
+	return fmt.Sprintf("fake-payout-%d-%s", withdrawal.ID, outcome), nil
+}
+
+func (fakePayoutProvider) PayoutStatus(reference string) (models.WithdrawalStatus, string, error) {
+	if strings.HasSuffix(reference, "-fail") {
+		return models.WithdrawalFailed, "destination rejected by fake payout provider", nil
+	}
+	return models.WithdrawalCompleted, "", nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"user-service/internal/config"
+	model "user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// SessionService issues access tokens together with rotating refresh tokens
+// and keeps the list of revoked access tokens for the gateway.
+type SessionService interface {
+	Issue(u *model.User) (*model.TokenPair, error)
+	Refresh(refreshToken string) (*model.User, *model.TokenPair, error)
+	Logout(refreshToken string) error
+	// RevokeUserSessions signs the user out everywhere.
+	RevokeUserSessions(userID uint) error
+	ListRevocations() ([]model.RevokedToken, error)
+	// PurgeExpiredRevocations deletes revocations of expired access tokens.
+	PurgeExpiredRevocations() (int64, error)
+}
+
+type sessionService struct {
+	repo   repository.SessionRepository
+	users  repository.UserRepository
+	jwt    JWTService
+	db     *gorm.DB
+	cfg    config.SessionConfig
+	logger *slog.Logger
+}
+
+func NewSessionService(
+	repo repository.SessionRepository, users repository.UserRepository, jwt JWTService,
+	db *gorm.DB, cfg config.SessionConfig, logger *slog.Logger,
+) SessionService {
+	return &sessionService{repo: repo, users: users, jwt: jwt, db: db, cfg: cfg, logger: logger}
+}
+
+// Issue starts a new session family for the user.
+func (s *sessionService) Issue(u *model.User) (*model.TokenPair, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(s.repo, u, familyID)
+}
+
+func (s *sessionService) issue(repo repository.SessionRepository, u *model.User, familyID string) (*model.TokenPair, error) {
+	access, jti, err := s.jwt.GenerateToken(u, s.cfg.AccessTTL)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	token := &model.RefreshToken{
+		UserID:          u.ID,
+		FamilyID:        familyID,
+		TokenHash:       hashToken(refresh),
+		ExpiresAt:       now.Add(s.cfg.RefreshTTL),
+		AccessJTI:       jti,
+		AccessExpiresAt: now.Add(s.cfg.AccessTTL),
+	}
+	if err := repo.CreateRefreshToken(token); err != nil {
+		return nil, err
+	}
+	return &model.TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(s.cfg.AccessTTL / time.Second),
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair. Presenting a token
+// that was already exchanged revokes its whole family, since either the user
+// or an attacker holds a stolen copy.
+func (s *sessionService) Refresh(refreshToken string) (*model.User, *model.TokenPair, error) {
+	var user *model.User
+	var pair *model.TokenPair
+	reused := false
+	err := s.db.Transaction(func(txDB *gorm.DB) error {
+		repo := s.repo.WithDB(txDB)
+
+		token, err := repo.GetRefreshTokenByHashWithLock(hashToken(refreshToken))
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if token == nil || token.RevokedAt != nil || now.After(token.ExpiresAt) {
+			return utils.ErrInvalidRefreshToken
+		}
+		if token.UsedAt != nil {
+			reused = true
+			s.logger.Warn("service refresh token reused", "user_id", token.UserID, "family_id", token.FamilyID)
+			return s.revokeFamily(repo, token.FamilyID, now)
+		}
+
+		user, err = s.users.FindByID(token.UserID)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return utils.ErrInvalidRefreshToken
+		}
+		token.UsedAt = &now
+		if err := repo.SaveRefreshToken(token); err != nil {
+			return err
+		}
+		pair, err = s.issue(repo, user, token.FamilyID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if reused {
+		return nil, nil, utils.ErrRefreshTokenReused
+	}
+	s.logger.Info("service session refreshed", "user_id", user.ID)
+	return user, pair, nil
+}
+
+// Logout revokes the session the refresh token belongs to, including its
+// access tokens. Logging out of a session that is already revoked succeeds.
+func (s *sessionService) Logout(refreshToken string) error {
+	return s.db.Transaction(func(txDB *gorm.DB) error {
+		repo := s.repo.WithDB(txDB)
+
+		token, err := repo.GetRefreshTokenByHashWithLock(hashToken(refreshToken))
+		if err != nil {
+			return err
+		}
+		if token == nil {
+			return utils.ErrInvalidRefreshToken
+		}
+		s.logger.Info("service logout", "user_id", token.UserID, "family_id", token.FamilyID)
+		return s.revokeFamily(repo, token.FamilyID, time.Now().UTC())
+	})
+}
+
+func (s *sessionService) RevokeUserSessions(userID uint) error {
+	return s.db.Transaction(func(txDB *gorm.DB) error {
+		repo := s.repo.WithDB(txDB)
+
+		families, err := repo.ListActiveRefreshTokenFamilies(userID)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, familyID := range families {
+			if err := s.revokeFamily(repo, familyID, now); err != nil {
+				return err
+			}
+		}
+		s.logger.Info("service user sessions revoked", "user_id", userID, "sessions", len(families))
+		return nil
+	})
+}
+
+func (s *sessionService) ListRevocations() ([]model.RevokedToken, error) {
+	return s.repo.ListRevokedTokens(time.Now().UTC())
+}
+
+func (s *sessionService) PurgeExpiredRevocations() (int64, error) {
+	return s.repo.DeleteExpiredRevokedTokens(time.Now().UTC())
+}
+
+// revokeFamily revokes every refresh token of the family and every access
+// token issued with them that has not expired yet.
+func (s *sessionService) revokeFamily(repo repository.SessionRepository, familyID string, now time.Time) error {
+	tokens, err := repo.ListRefreshTokenFamily(familyID)
+	if err != nil {
+		return err
+	}
+	var revoked []model.RevokedToken
+	for _, t := range tokens {
+		if t.AccessExpiresAt.After(now) {
+			revoked = append(revoked, model.RevokedToken{JTI: t.AccessJTI, ExpiresAt: t.AccessExpiresAt})
+		}
+	}
+	if err := repo.RevokeRefreshTokenFamily(familyID, now); err != nil {
+		return err
+	}
+	return repo.RevokeAccessTokens(revoked)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
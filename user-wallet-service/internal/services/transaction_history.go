@@ -0,0 +1,97 @@
+package services
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	models "user-service/internal/models"
+	"user-service/internal/utils"
+)
+
+const exportBatchSize = 500
+
+// ListTransactions returns one page of the user's history. The cursor is the
+// opaque next_cursor of the previous page; an empty cursor starts from the
+// newest transaction.
+func (s *walletService) ListTransactions(userID uint, filter models.TransactionFilter, cursor string, pageSize int) (*models.TransactionPage, error) {
+	s.logger.Info("service list transactions", "user_id", userID, "page_size", pageSize)
+
+	var after *models.TransactionCursor
+	if cursor != "" {
+		var err error
+		if after, err = decodeTransactionCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	// One extra row tells whether another page follows.
+	transactions, err := s.repo.ListTransactions(userID, filter, after, pageSize+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &models.TransactionPage{
+		Data:       transactions,
+		Pagination: models.CursorPagination{PageSize: pageSize},
+	}
+	if len(transactions) > pageSize {
+		page.Data = transactions[:pageSize]
+		page.Pagination.HasMore = true
+		page.Pagination.NextCursor = encodeTransactionCursor(page.Data[pageSize-1])
+	}
+	if page.Data == nil {
+		page.Data = []models.Transaction{}
+	}
+	return page, nil
+}
+
+// ExportTransactions passes the whole filtered history to write in batches,
+// newest first, so large histories are never held in memory at once.
+func (s *walletService) ExportTransactions(userID uint, filter models.TransactionFilter, write func([]models.Transaction) error) error {
+	s.logger.Info("service export transactions", "user_id", userID)
+
+	var after *models.TransactionCursor
+	for {
+		batch, err := s.repo.ListTransactions(userID, filter, after, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := write(batch); err != nil {
+			return err
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		last := batch[len(batch)-1]
+		after = &models.TransactionCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+}
+
+func encodeTransactionCursor(tran models.Transaction) string {
+	raw := tran.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatUint(uint64(tran.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeTransactionCursor(cursor string) (*models.TransactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, utils.ErrInvalidCursor
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, utils.ErrInvalidCursor
+	}
+	parsedTime, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, utils.ErrInvalidCursor
+	}
+	parsedID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, utils.ErrInvalidCursor
+	}
+	return &models.TransactionCursor{CreatedAt: parsedTime, ID: uint(parsedID)}, nil
+}
@@ -7,90 +7,167 @@ import (
 
 	"log/slog"
 
+	"user-service/internal/config"
 	model "user-service/internal/models"
 	"user-service/internal/repository"
+	"user-service/internal/utils"
 
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type UserService interface {
-	Register(email, password string, role model.Role) (*model.User, string, error)
-	Login(email, password string) (*model.User, string, error)
+	// Register creates a buyer: other roles are never self-assigned.
+	Register(email, password string) (*model.User, *model.TokenPair, error)
+	// Login returns a session, or only an MFA challenge to pass to LoginMFA
+	// when the user has two factor authentication on.
+	// Failed attempts are throttled per email and per client ip.
+	Login(email, password, ip string) (*model.User, *model.TokenPair, *model.MFAChallenge, error)
+	LoginMFA(challenge, code, ip string) (*model.User, *model.TokenPair, error)
+	UnlockLogin(id uint) error
+	ListLoginAttempts(id uint, limit int) ([]model.LoginAttempt, error)
 	GetByID(id uint) (*model.User, error)
 	UpdateProfile(id uint, fullName, email string) (*model.User, error)
+	ChangePassword(id uint, currentPassword, newPassword string) error
+	ForgotPassword(email string) error
+	ResetPassword(token, password string) error
+	SendEmailVerification(id uint) error
+	VerifyEmail(token string) (*model.User, error)
+	SetupTOTP(id uint) (*model.MFASetupResponse, error)
+	EnableTOTP(id uint, code string) ([]string, error)
+	DisableTOTP(id uint, code string) error
+	RegenerateRecoveryCodes(id uint, code string) ([]string, error)
+	// StepUp returns the second factor check of a sensitive operation, to be
+	// run inside the operation's transaction so the code is only used up if
+	// the operation commits. A wrong code has to be reported with
+	// RecordMFAFailure once that transaction has rolled back.
+	StepUp(id uint, code string) StepUpCheck
+	RecordMFAFailure(id uint) error
 }
 
 type userService struct {
 	repo      repository.UserRepository
-	jwt       JWTService
-	tokenTTL  time.Duration
+	tokens    repository.UserTokenRepository
+	mfa       repository.MFARepository
+	sessions  SessionService
+	guard     LoginGuard
+	mail      MailSender
+	mailCfg   config.MailConfig
+	mfaCfg    config.MFAConfig
+	db        *gorm.DB
 	minPassLn int
 	logger    *slog.Logger
 }
 
-func NewUserService(repo repository.UserRepository, jwt JWTService, logger *slog.Logger) UserService {
-	return &userService{repo: repo, jwt: jwt, tokenTTL: 24 * time.Hour, minPassLn: 6, logger: logger}
+func NewUserService(
+	repo repository.UserRepository, tokens repository.UserTokenRepository, mfa repository.MFARepository,
+	sessions SessionService, guard LoginGuard, mail MailSender, mailCfg config.MailConfig, mfaCfg config.MFAConfig,
+	db *gorm.DB, logger *slog.Logger,
+) UserService {
+	return &userService{
+		repo: repo, tokens: tokens, mfa: mfa, sessions: sessions, guard: guard, mail: mail,
+		mailCfg: mailCfg, mfaCfg: mfaCfg, db: db, minPassLn: 6, logger: logger,
+	}
 }
 
-func (s *userService) Register(email, password string, role model.Role) (*model.User, string, error) {
+func (s *userService) Register(email, password string) (*model.User, *model.TokenPair, error) {
 	email = strings.TrimSpace(strings.ToLower(email))
-	s.logger.Info("service register attempt", "email", email, "role", string(role))
+	s.logger.Info("service register attempt", "email", email)
 	if email == "" || len(password) < s.minPassLn {
-		return nil, "", errors.New("invalid email or password too short")
-	}
-	if role == "" {
-		role = model.RoleBuyer
-	}
-	if role != model.RoleBuyer && role != model.RoleSeller && role != model.RoleAdmin {
-		return nil, "", errors.New("invalid role")
+		return nil, nil, errors.New("invalid email or password too short")
 	}
 	exists, err := s.repo.FindByEmail(email)
 	if err != nil {
 		s.logger.Error("service register find by email failed", "email", email, "err", err.Error())
-		return nil, "", err
+		return nil, nil, err
 	}
 	if exists != nil {
-		return nil, "", errors.New("email already registered")
+		return nil, nil, errors.New("email already registered")
 	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return nil, "", err
+		return nil, nil, err
 	}
-	u := &model.User{Email: email, PasswordHash: string(hash), Role: role}
+	u := &model.User{Email: email, PasswordHash: string(hash), Role: model.RoleBuyer}
 	if err := s.repo.Create(u); err != nil {
 		s.logger.Error("service create user failed", "email", email, "err", err.Error())
-		return nil, "", err
+		return nil, nil, err
+	}
+	if err := s.sendEmailVerification(u); err != nil {
+		s.logger.Error("service send email verification failed", "user_id", u.ID, "err", err.Error())
 	}
-	token, err := s.jwt.GenerateToken(u, s.tokenTTL)
+	tokens, err := s.sessions.Issue(u)
 	if err != nil {
-		s.logger.Error("service generate token failed", "user_id", u.ID, "err", err.Error())
-		return nil, "", err
+		s.logger.Error("service issue session failed", "user_id", u.ID, "err", err.Error())
+		return nil, nil, err
 	}
 	s.logger.Info("service user registered", "user_id", u.ID, "email", u.Email)
-	return u, token, nil
+	return u, tokens, nil
 }
 
-func (s *userService) Login(email, password string) (*model.User, string, error) {
+func (s *userService) Login(email, password, ip string) (*model.User, *model.TokenPair, *model.MFAChallenge, error) {
 	email = strings.TrimSpace(strings.ToLower(email))
-	s.logger.Info("service login attempt", "email", email)
+	s.logger.Info("service login attempt", "email", email, "ip", ip)
+	if err := s.guard.Reserve(email, ip); err != nil {
+		s.logger.Warn("service login throttled", "email", email, "ip", ip)
+		return nil, nil, nil, err
+	}
 	u, err := s.repo.FindByEmail(email)
 	if err != nil {
 		s.logger.Error("service login find failed", "email", email, "err", err.Error())
-		return nil, "", err
+		s.guard.Release(email, ip)
+		return nil, nil, nil, err
 	}
 	if u == nil {
-		return nil, "", errors.New("invalid credentials")
+		s.guard.Audit(email, ip, nil, model.LoginInvalidCredentials)
+		return nil, nil, nil, utils.ErrInvalidCredentials
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
-		return nil, "", errors.New("invalid credentials")
+		s.guard.Audit(email, ip, &u.ID, model.LoginInvalidCredentials)
+		return nil, nil, nil, utils.ErrInvalidCredentials
+	}
+	s.guard.Release(email, ip)
+	if u.TwoFactorEnabled() {
+		challenge, err := s.issueUserToken(u, model.UserTokenMFAChallenge, s.mfaCfg.ChallengeTTL)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// Failures are only reset once the second factor passes too.
+		s.guard.RecordSuccess(email, ip, &u.ID, model.LoginMFARequired)
+		s.logger.Info("service login mfa required", "user_id", u.ID)
+		return u, nil, &model.MFAChallenge{Token: challenge, ExpiresIn: int64(s.mfaCfg.ChallengeTTL / time.Second)}, nil
 	}
-	token, err := s.jwt.GenerateToken(u, s.tokenTTL)
+	tokens, err := s.sessions.Issue(u)
 	if err != nil {
-		s.logger.Error("service generate token failed", "user_id", u.ID, "err", err.Error())
-		return nil, "", err
+		s.logger.Error("service issue session failed", "user_id", u.ID, "err", err.Error())
+		return nil, nil, nil, err
 	}
+	s.guard.RecordSuccess(email, ip, &u.ID, model.LoginSucceeded)
 	s.logger.Info("service login success", "user_id", u.ID)
-	return u, token, nil
+	return u, tokens, nil, nil
+}
+
+// UnlockLogin lifts the backoff or lockout of the user's email.
+func (s *userService) UnlockLogin(id uint) error {
+	u, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return utils.ErrUserNotFound
+	}
+	return s.guard.Unlock(u.Email)
+}
+
+func (s *userService) ListLoginAttempts(id uint, limit int) ([]model.LoginAttempt, error) {
+	u, err := s.repo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, utils.ErrUserNotFound
+	}
+	return s.guard.ListAttempts(u.Email, limit)
 }
 
 func (s *userService) GetByID(id uint) (*model.User, error) {
@@ -122,12 +199,31 @@ func (s *userService) UpdateProfile(id uint, fullName, email string) (*model.Use
 			return nil, errors.New("email already in use")
 		}
 	}
+	emailChanged := u.Email != email
 	u.FullName = strings.TrimSpace(fullName)
 	u.Email = email
-	if err := s.repo.Update(u); err != nil {
+	if emailChanged {
+		u.EmailVerifiedAt = nil
+	}
+	// Reset links mailed to the old address stop working with it.
+	err = s.db.Transaction(func(txDB *gorm.DB) error {
+		if err := s.repo.WithDB(txDB).Update(u); err != nil {
+			return err
+		}
+		if !emailChanged {
+			return nil
+		}
+		return s.tokens.WithDB(txDB).ExpireUserTokens(u.ID, model.UserTokenPasswordReset, time.Now().UTC())
+	})
+	if err != nil {
 		s.logger.Error("service update profile failed", "user_id", id, "err", err.Error())
 		return nil, err
 	}
+	if emailChanged {
+		if err := s.sendEmailVerification(u); err != nil {
+			s.logger.Error("service send email verification failed", "user_id", u.ID, "err", err.Error())
+		}
+	}
 	s.logger.Info("service profile updated", "user_id", u.ID)
 	return u, nil
 }
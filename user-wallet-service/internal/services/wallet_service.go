@@ -2,7 +2,9 @@ package services
 
 import (
 	"log/slog"
+	"slices"
 
+	"user-service/internal/config"
 	models "user-service/internal/models"
 	"user-service/internal/repository"
 	"user-service/internal/utils"
@@ -11,33 +13,56 @@ import (
 )
 
 type WalletService interface {
-	GetWallet(userID uint) (*models.Wallet, error)
-	Deposit(userID uint, amount int64, description string) (*models.Wallet, *models.Transaction, error)
-	Freeze(userID uint, amount int64, description string) (*models.Wallet, *models.Transaction, error)
-	Unfreeze(userID uint, amount int64, description string) (*models.Wallet, *models.Transaction, error)
-	Charge(userID uint, amount int64, description string) (*models.Wallet, *models.Transaction, error)
-	ListTransactions(userID uint, limit, offset int) ([]models.Transaction, error)
+	GetWallet(userID uint, currency string) (*models.Wallet, error)
+	ListWallets(userID uint) ([]models.Wallet, error)
+	CreateDeposit(userID uint, amount int64, currency, description, idempotencyKey string) (*models.PaymentIntent, error)
+	HandlePaymentWebhook(body []byte, signature string) error
+	ListDeposits(userID uint, limit, offset int) ([]models.PaymentIntent, error)
+	Hold(userID uint, ref models.HoldReference, amount int64, currency, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error)
+	ReleaseHold(userID uint, ref models.HoldReference, amount int64, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error)
+	CaptureHold(userID uint, ref models.HoldReference, amount int64, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error)
+	PayoutHold(buyerID uint, ref models.HoldReference, amount int64, sellerID uint, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error)
+	ListHolds(userID uint, status models.HoldStatus) ([]models.Hold, error)
+	// Deprecated: use Hold, ReleaseHold and CaptureHold with a reference.
+	LegacyFreeze(userID uint, amount int64, currency, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error)
+	// Deprecated: use ReleaseHold.
+	LegacyUnfreeze(userID uint, amount int64, currency, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error)
+	// Deprecated: use CaptureHold or PayoutHold.
+	LegacyCharge(userID uint, amount int64, currency, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error)
+	ListTransactions(userID uint, filter models.TransactionFilter, cursor string, pageSize int) (*models.TransactionPage, error)
+	ExportTransactions(userID uint, filter models.TransactionFilter, write func([]models.Transaction) error) error
+	Withdraw(userID uint, amount int64, currency, destination, description, idempotencyKey string, stepUp StepUpCheck) (*models.Wallet, *models.Withdrawal, error)
+	ListWithdrawals(userID uint, limit, offset int) ([]models.Withdrawal, error)
+	ProcessPendingWithdrawals() error
+	Reconcile() (*models.ReconciliationReport, error)
+	OpenLedgerBalances() error
 }
 
 type walletService struct {
-	repo   repository.WalletRepository
-	db     *gorm.DB
-	logger *slog.Logger
+	repo       repository.WalletRepository
+	db         *gorm.DB
+	settlement config.SettlementConfig
+	payments   PaymentProvider
+	payouts    PayoutProvider
+	logger     *slog.Logger
 }
 
-func NewWalletService(repo repository.WalletRepository, db *gorm.DB, logger *slog.Logger) WalletService {
-	return &walletService{repo: repo, db: db, logger: logger}
+func NewWalletService(
+	repo repository.WalletRepository, db *gorm.DB, settlement config.SettlementConfig,
+	payments PaymentProvider, payouts PayoutProvider, logger *slog.Logger,
+) WalletService {
+	return &walletService{repo: repo, db: db, settlement: settlement, payments: payments, payouts: payouts, logger: logger}
 }
 
-func (s *walletService) GetWallet(userID uint) (*models.Wallet, error) {
-	s.logger.Info("service get wallet", "user_id", userID)
-	wallet, err := s.repo.GetByUserID(userID)
+func (s *walletService) GetWallet(userID uint, currency string) (*models.Wallet, error) {
+	s.logger.Info("service get wallet", "user_id", userID, "currency", currency)
+	wallet, err := s.repo.GetByUserID(userID, currency)
 	if err != nil {
 		s.logger.Error("service get wallet failed", "user_id", userID, "err", err.Error())
 		return nil, err
 	}
 	if wallet == nil {
-		wallet = &models.Wallet{UserID: userID, Balance: 0, FrozenBalance: 0}
+		wallet = &models.Wallet{UserID: userID, Currency: currency, Balance: 0, FrozenBalance: 0}
 		if err := s.repo.CreateWallet(wallet); err != nil {
 			s.logger.Error("service create wallet failed", "user_id", userID, "err", err.Error())
 			return nil, err
@@ -47,230 +72,420 @@ func (s *walletService) GetWallet(userID uint) (*models.Wallet, error) {
 	return wallet, nil
 }
 
-func (s *walletService) Deposit(userID uint, amount int64, description string) (*models.Wallet, *models.Transaction, error) {
+func (s *walletService) ListWallets(userID uint) ([]models.Wallet, error) {
+	s.logger.Info("service list wallets", "user_id", userID)
+	return s.repo.ListUserWallets(userID)
+}
 
-	s.logger.Info("service deposit attempt", "user_id", userID, "amount", amount)
+// creditDeposit credits money received from the payment provider. It runs in
+// the caller's database transaction.
+func creditDeposit(walletRepo repository.WalletRepository, userID uint, currency string, amount int64, description, idempotencyKey string) (*models.Transaction, error) {
+	wallet, err := walletRepo.GetByUserIDWithLock(userID, currency)
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil {
+		wallet = &models.Wallet{UserID: userID, Currency: currency, Balance: 0, FrozenBalance: 0}
+		if err := walletRepo.CreateWallet(wallet); err != nil {
+			return nil, err
+		}
+	}
 
-	var result *models.Wallet
-	var tran *models.Transaction
+	beforeBalance := wallet.Balance
+	wallet.Balance += amount
+	if err := walletRepo.SaveWallet(wallet); err != nil {
+		return nil, err
+	}
+	transaction := models.Transaction{
+		WalletID:       wallet.ID,
+		UserID:         userID,
+		Type:           models.TransactionDeposit,
+		Amount:         amount,
+		Currency:       wallet.Currency,
+		BalanceBefore:  beforeBalance,
+		BalanceAfter:   wallet.Balance,
+		FrozenBefore:   wallet.FrozenBalance,
+		FrozenAfter:    wallet.FrozenBalance,
+		Description:    description,
+		IdempotencyKey: optionalKey(idempotencyKey),
+	}
+	if err := walletRepo.CreateTransaction(&transaction); err != nil {
+		return nil, err
+	}
+	if err := postEntry(walletRepo, wallet.Currency, models.TransactionDeposit, transaction.ID, description,
+		increase(models.LedgerUserAvailable, userID, amount),
+		decrease(models.LedgerExternalCash, 0, amount),
+	); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
 
-	err := s.db.Transaction(func(tx *gorm.DB) error {
-		walletRepo := s.repo.WithDB(tx)
+// Hold freezes amount of the wallet in currency. A hold stays in the currency
+// it was opened in, so topping it up in another currency is rejected.
+func (s *walletService) Hold(userID uint, ref models.HoldReference, amount int64, currency, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error) {
+	return s.applyHold(userID, currency, models.TransactionFreeze, amount, description, idempotencyKey, func(walletRepo repository.WalletRepository, wallet *models.Wallet) (*models.Hold, int64, error) {
+		if wallet.Balance-wallet.FrozenBalance < amount {
+			return nil, 0, utils.ErrInsufficientAvailableBalance
+		}
 
-		wallet, err := walletRepo.GetByUserIDWithLock(userID)
+		hold, err := walletRepo.GetHold(userID, ref)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
-		if wallet == nil {
-			wallet = &models.Wallet{UserID: userID, Balance: 0, FrozenBalance: 0}
-			if err := walletRepo.CreateWallet(wallet); err != nil {
-				return err
+		switch {
+		case hold == nil:
+			hold = &models.Hold{
+				WalletID:      wallet.ID,
+				UserID:        userID,
+				ReferenceType: ref.ReferenceType,
+				ReferenceID:   ref.ReferenceID,
+				Currency:      wallet.Currency,
+				Status:        models.HoldActive,
 			}
+		case hold.Currency != wallet.Currency:
+			return nil, 0, utils.ErrCurrencyMismatch
+		case hold.Status == models.HoldCaptured:
+			return nil, 0, utils.ErrHoldNotActive
+		case hold.Status == models.HoldReleased:
+			hold.Status = models.HoldActive
+			hold.Amount = 0
 		}
-		beforeBalance := wallet.Balance
-		beforeFrozen := wallet.FrozenBalance
-		wallet.Balance += amount
+		hold.Amount += amount
+		return hold, amount, nil
+	})
+}
 
-		if err := walletRepo.SaveWallet(wallet); err != nil {
-			return err
+func (s *walletService) ReleaseHold(userID uint, ref models.HoldReference, amount int64, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error) {
+	currency, err := s.holdCurrency(userID, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.applyHold(userID, currency, models.TransactionUnfreeze, amount, description, idempotencyKey, func(walletRepo repository.WalletRepository, wallet *models.Wallet) (*models.Hold, int64, error) {
+		hold, err := activeHold(walletRepo, userID, ref, amount)
+		if err != nil {
+			return nil, 0, err
 		}
-		transaction := models.Transaction{
-			WalletID:      wallet.ID,
-			UserID:        userID,
-			Type:          models.TransactionDeposit,
-			Amount:        amount,
-			BalanceBefore: beforeBalance,
-			BalanceAfter:  wallet.Balance,
-			FrozenBefore:  beforeFrozen,
-			FrozenAfter:   wallet.FrozenBalance,
-			Description:   description,
+		if amount == 0 {
+			amount = hold.Amount
 		}
-		if err := walletRepo.CreateTransaction(&transaction); err != nil {
-			return err
+		hold.Amount -= amount
+		if hold.Amount == 0 {
+			hold.Status = models.HoldReleased
 		}
-		result = wallet
-		tran = &transaction
-		return nil
+		return hold, amount, nil
 	})
+}
+
+// CaptureHold charges amount from the hold and releases whatever is left of
+// it, closing the hold.
+func (s *walletService) CaptureHold(userID uint, ref models.HoldReference, amount int64, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error) {
+	currency, err := s.holdCurrency(userID, ref)
 	if err != nil {
-		s.logger.Error("service deposit failed", "user_id", userID, "err", err.Error())
 		return nil, nil, err
 	}
-	s.logger.Info("service deposit success", "user_id", userID, "transaction_id", tran.ID)
-	return result, tran, nil
+	return s.applyHold(userID, currency, models.TransactionCharge, amount, description, idempotencyKey, captureOp(userID, ref, amount))
 }
 
-func (s *walletService) Freeze(userID uint, amount int64, description string) (*models.Wallet, *models.Transaction, error) {
+func captureOp(userID uint, ref models.HoldReference, amount int64) holdOp {
+	return func(walletRepo repository.WalletRepository, wallet *models.Wallet) (*models.Hold, int64, error) {
+		hold, err := activeHold(walletRepo, userID, ref, amount)
+		if err != nil {
+			return nil, 0, err
+		}
+		if amount == 0 {
+			amount = hold.Amount
+		}
+		wallet.Balance -= amount
+		if wallet.Balance < 0 {
+			return nil, 0, utils.ErrResultingBalanceNegative
+		}
+		hold.CapturedAmount = amount
+		hold.Amount = 0
+		hold.Status = models.HoldCaptured
+		return hold, amount, nil
+	}
+}
+
+// PayoutHold captures the buyer's hold and, in the same database transaction,
+// credits the seller with the amount minus the platform commission, which is
+// booked to the platform revenue account. The seller is paid in the currency
+// of the hold.
+func (s *walletService) PayoutHold(buyerID uint, ref models.HoldReference, amount int64, sellerID uint, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error) {
 
-	s.logger.Info("service freeze attempt", "user_id", userID, "amount", amount)
+	s.logger.Info("service payout attempt", "user_id", buyerID, "seller_id", sellerID, "amount", amount)
+
+	if sellerID == buyerID {
+		return nil, nil, utils.ErrSellerIsBuyer
+	}
+
+	currency, err := s.holdCurrency(buyerID, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	commission := amount * s.settlement.CommissionBPS / 10000
 
 	var result *models.Wallet
 	var tran *models.Transaction
+	err = s.db.Transaction(func(txDB *gorm.DB) error {
+		walletRepo := s.repo.WithDB(txDB)
 
-	err := s.db.Transaction(func(tx *gorm.DB) error {
-		walletRepo := s.repo.WithDB(tx)
-
-		wallet, err := walletRepo.GetByUserIDWithLock(userID)
+		wallets, err := lockWallets(walletRepo, currency, []uint{buyerID, sellerID})
 		if err != nil {
 			return err
 		}
-		if wallet == nil {
-			return utils.ErrWalletNotFound
+		buyer := wallets[buyerID]
+		previous, err := findReplay(walletRepo, buyer, idempotencyKey, models.TransactionCharge, amount)
+		if err != nil {
+			return err
 		}
-
-		available := wallet.Balance - wallet.FrozenBalance
-		if available < amount {
-			return utils.ErrInsufficientAvailableBalance
+		if previous != nil {
+			s.logger.Info("service payout replayed", "user_id", buyerID, "transaction_id", previous.ID)
+			result = replayedWallet(buyer, previous)
+			tran = previous
+			return nil
 		}
 
-		beforeBalance := wallet.Balance
-		beforeFrozen := wallet.FrozenBalance
-
-		wallet.FrozenBalance += amount
-		if err := walletRepo.SaveWallet(wallet); err != nil {
+		charge, err := recordHoldOp(walletRepo, buyer, models.TransactionCharge, description, idempotencyKey, captureOp(buyerID, ref, amount))
+		if err != nil {
 			return err
 		}
-
-		transaction := models.Transaction{
-			WalletID:      wallet.ID,
-			UserID:        userID,
-			Type:          models.TransactionFreeze,
-			Amount:        amount,
-			BalanceBefore: beforeBalance,
-			BalanceAfter:  wallet.Balance,
-			FrozenBefore:  beforeFrozen,
-			FrozenAfter:   wallet.FrozenBalance,
-			Description:   description,
+		if _, err := creditWallet(walletRepo, wallets[sellerID], models.TransactionPayout, amount-commission, description, charge.HoldID); err != nil {
+			return err
 		}
-
-		if err := walletRepo.CreateTransaction(&transaction); err != nil {
+		postings := append(capturePostings(charge),
+			increase(models.LedgerUserAvailable, sellerID, amount-commission),
+			increase(models.LedgerPlatformRevenue, 0, commission),
+		)
+		if err := postEntry(walletRepo, currency, models.TransactionPayout, charge.ID, description, postings...); err != nil {
 			return err
 		}
-
-		result = wallet
-		tran = &transaction
+		result = buyer
+		tran = charge
 		return nil
 	})
 	if err != nil {
-		s.logger.Error("service freeze failed", "user_id", userID, "err", err.Error())
+		s.logger.Error("service payout failed", "user_id", buyerID, "seller_id", sellerID, "err", err.Error())
 		return nil, nil, err
 	}
-	s.logger.Info("service freeze success", "user_id", userID, "transaction_id", tran.ID)
+	s.logger.Info("service payout success", "user_id", buyerID, "seller_id", sellerID, "commission", commission, "transaction_id", tran.ID)
 	return result, tran, nil
 }
 
-func (s *walletService) Unfreeze(userID uint, amount int64, description string) (*models.Wallet, *models.Transaction, error) {
+func (s *walletService) ListHolds(userID uint, status models.HoldStatus) ([]models.Hold, error) {
+	s.logger.Info("service list holds", "user_id", userID, "status", status)
+	return s.repo.ListHolds(userID, status)
+}
+
+// holdOp changes a hold of the locked wallet and returns it together with the
+// amount it actually moved.
+type holdOp func(walletRepo repository.WalletRepository, wallet *models.Wallet) (*models.Hold, int64, error)
 
-	s.logger.Info("service unfreeze attempt", "user_id", userID, "amount", amount)
+// applyHold runs a hold operation on the user's locked wallet in currency,
+// see recordHoldOp.
+func (s *walletService) applyHold(userID uint, currency string, kind models.TransactionType, amount int64, description, idempotencyKey string, op holdOp) (*models.Wallet, *models.Transaction, error) {
+
+	s.logger.Info("service "+string(kind)+" attempt", "user_id", userID, "amount", amount, "currency", currency)
 
 	var result *models.Wallet
 	var tran *models.Transaction
 	err := s.db.Transaction(func(txDB *gorm.DB) error {
 		walletRepo := s.repo.WithDB(txDB)
 
-		wallet, err := walletRepo.GetByUserIDWithLock(userID)
+		wallet, err := walletRepo.GetByUserIDWithLock(userID, currency)
 		if err != nil {
 			return err
 		}
 		if wallet == nil {
 			return utils.ErrWalletNotFound
 		}
-		if wallet.FrozenBalance < amount {
-			return utils.ErrInsufficientFrozenBalance
+		previous, err := findReplay(walletRepo, wallet, idempotencyKey, kind, amount)
+		if err != nil {
+			return err
+		}
+		if previous != nil {
+			s.logger.Info("service "+string(kind)+" replayed", "user_id", userID, "transaction_id", previous.ID)
+			result = replayedWallet(wallet, previous)
+			tran = previous
+			return nil
 		}
-		beforeBalance := wallet.Balance
-		beforeFrozen := wallet.FrozenBalance
-		wallet.FrozenBalance -= amount
 
-		if err := walletRepo.SaveWallet(wallet); err != nil {
+		transaction, err := recordHoldOp(walletRepo, wallet, kind, description, idempotencyKey, op)
+		if err != nil {
 			return err
 		}
-		transaction := models.Transaction{
-			WalletID:      wallet.ID,
-			UserID:        userID,
-			Type:          models.TransactionUnfreeze,
-			Amount:        amount,
-			BalanceBefore: beforeBalance,
-			BalanceAfter:  wallet.Balance,
-			FrozenBefore:  beforeFrozen,
-			FrozenAfter:   wallet.FrozenBalance,
-			Description:   description,
-		}
-		if err := walletRepo.CreateTransaction(&transaction); err != nil {
+		if err := postEntry(walletRepo, wallet.Currency, kind, transaction.ID, description, holdPostings(transaction)...); err != nil {
 			return err
 		}
 		result = wallet
-		tran = &transaction
+		tran = transaction
 		return nil
 	})
 	if err != nil {
-		s.logger.Error("service unfreeze failed", "user_id", userID, "err", err.Error())
+		s.logger.Error("service "+string(kind)+" failed", "user_id", userID, "err", err.Error())
 		return nil, nil, err
 	}
-	s.logger.Info("service unfreeze success", "user_id", userID, "transaction_id", tran.ID)
+	s.logger.Info("service "+string(kind)+" success", "user_id", userID, "transaction_id", tran.ID)
 	return result, tran, nil
 }
 
-func (s *walletService) Charge(userID uint, amount int64, description string) (*models.Wallet, *models.Transaction, error) {
+// recordHoldOp runs op, recomputes FrozenBalance from the active holds and
+// records the transaction. The wallet must be locked.
+func recordHoldOp(walletRepo repository.WalletRepository, wallet *models.Wallet, kind models.TransactionType, description, idempotencyKey string, op holdOp) (*models.Transaction, error) {
+	beforeBalance := wallet.Balance
+	beforeFrozen := wallet.FrozenBalance
 
-	s.logger.Info("service charge attempt", "user_id", userID, "amount", amount)
+	hold, moved, err := op(walletRepo, wallet)
+	if err != nil {
+		return nil, err
+	}
+	if err := walletRepo.SaveHold(hold); err != nil {
+		return nil, err
+	}
+	frozen, err := walletRepo.SumActiveHolds(wallet.ID)
+	if err != nil {
+		return nil, err
+	}
+	wallet.FrozenBalance = frozen
+	if err := walletRepo.SaveWallet(wallet); err != nil {
+		return nil, err
+	}
 
-	var result *models.Wallet
-	var tran *models.Transaction
-	err := s.db.Transaction(func(txDB *gorm.DB) error {
-		walletRepo := s.repo.WithDB(txDB)
+	transaction := models.Transaction{
+		WalletID:       wallet.ID,
+		UserID:         wallet.UserID,
+		Type:           kind,
+		Amount:         moved,
+		Currency:       wallet.Currency,
+		BalanceBefore:  beforeBalance,
+		BalanceAfter:   wallet.Balance,
+		FrozenBefore:   beforeFrozen,
+		FrozenAfter:    wallet.FrozenBalance,
+		Description:    description,
+		HoldID:         &hold.ID,
+		IdempotencyKey: optionalKey(idempotencyKey),
+	}
+	if err := walletRepo.CreateTransaction(&transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// creditWallet adds amount to the available balance of a locked wallet.
+func creditWallet(walletRepo repository.WalletRepository, wallet *models.Wallet, kind models.TransactionType, amount int64, description string, holdID *uint) (*models.Transaction, error) {
+	beforeBalance := wallet.Balance
+	wallet.Balance += amount
+	if err := walletRepo.SaveWallet(wallet); err != nil {
+		return nil, err
+	}
+	transaction := models.Transaction{
+		WalletID:      wallet.ID,
+		UserID:        wallet.UserID,
+		Type:          kind,
+		Amount:        amount,
+		Currency:      wallet.Currency,
+		BalanceBefore: beforeBalance,
+		BalanceAfter:  wallet.Balance,
+		FrozenBefore:  wallet.FrozenBalance,
+		FrozenAfter:   wallet.FrozenBalance,
+		Description:   description,
+		HoldID:        holdID,
+	}
+	if err := walletRepo.CreateTransaction(&transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
 
-		wallet, err := walletRepo.GetByUserIDWithLock(userID)
+// lockWallets locks the wallets in currency of all users in ascending user ID
+// order, so concurrent multi-wallet operations cannot deadlock. Missing
+// wallets are created, except the first user's, which must already have one.
+func lockWallets(walletRepo repository.WalletRepository, currency string, userIDs []uint) (map[uint]*models.Wallet, error) {
+	ordered := slices.Clone(userIDs)
+	slices.Sort(ordered)
+	ordered = slices.Compact(ordered)
+
+	wallets := make(map[uint]*models.Wallet, len(ordered))
+	for _, userID := range ordered {
+		wallet, err := walletRepo.GetByUserIDWithLock(userID, currency)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if wallet == nil {
-			return utils.ErrWalletNotFound
-		}
-		if wallet.FrozenBalance < amount {
-			return utils.ErrInsufficientFrozenBalance
-		}
-
-		beforeBalance := wallet.Balance
-		beforeFrozen := wallet.FrozenBalance
-		wallet.FrozenBalance -= amount
-		wallet.Balance -= amount
-
-		if wallet.Balance < 0 {
-			return utils.ErrResultingBalanceNegative
+			if userID == userIDs[0] {
+				return nil, utils.ErrWalletNotFound
+			}
+			wallet = &models.Wallet{UserID: userID, Currency: currency, Balance: 0, FrozenBalance: 0}
+			if err := walletRepo.CreateWallet(wallet); err != nil {
+				return nil, err
+			}
 		}
+		wallets[userID] = wallet
+	}
+	return wallets, nil
+}
 
-		if err := walletRepo.SaveWallet(wallet); err != nil {
-			return err
-		}
+// holdCurrency returns the currency of the user's hold on ref. It is read
+// before the wallet is locked, which is safe since a hold never changes
+// currency.
+func (s *walletService) holdCurrency(userID uint, ref models.HoldReference) (string, error) {
+	hold, err := s.repo.GetHold(userID, ref)
+	if err != nil {
+		return "", err
+	}
+	if hold == nil {
+		return "", utils.ErrHoldNotFound
+	}
+	return hold.Currency, nil
+}
 
-		transaction := models.Transaction{
-			WalletID:      wallet.ID,
-			UserID:        userID,
-			Type:          models.TransactionCharge,
-			Amount:        amount,
-			BalanceBefore: beforeBalance,
-			BalanceAfter:  wallet.Balance,
-			FrozenBefore:  beforeFrozen,
-			FrozenAfter:   wallet.FrozenBalance,
-			Description:   description,
-		}
-		if err := walletRepo.CreateTransaction(&transaction); err != nil {
-			return err
-		}
-		result = wallet
-		tran = &transaction
-		return nil
-	})
+func activeHold(walletRepo repository.WalletRepository, userID uint, ref models.HoldReference, amount int64) (*models.Hold, error) {
+	hold, err := walletRepo.GetHold(userID, ref)
 	if err != nil {
-		s.logger.Error("service charge failed", "user_id", userID, "err", err.Error())
-		return nil, nil, err
+		return nil, err
 	}
-	s.logger.Info("service charge success", "user_id", userID, "transaction_id", tran.ID)
-	return result, tran, nil
+	if hold == nil {
+		return nil, utils.ErrHoldNotFound
+	}
+	if hold.Status != models.HoldActive {
+		return nil, utils.ErrHoldNotActive
+	}
+	if amount > hold.Amount {
+		return nil, utils.ErrInsufficientHold
+	}
+	return hold, nil
 }
 
-func (s *walletService) ListTransactions(userID uint, limit, offset int) ([]models.Transaction, error) {
-	s.logger.Info("service list transactions", "user_id", userID, "limit", limit, "offset", offset)
-	return s.repo.ListTransactions(userID, limit, offset)
+// findReplay returns the transaction already recorded under the idempotency
+// key, or nil when the operation has not been applied yet. A zero amount
+// matches any amount. Must be called with the wallet row locked so concurrent
+// retries are serialized.
+func findReplay(walletRepo repository.WalletRepository, wallet *models.Wallet, key string, kind models.TransactionType, amount int64) (*models.Transaction, error) {
+	if key == "" {
+		return nil, nil
+	}
+	previous, err := walletRepo.GetTransactionByIdempotencyKey(wallet.UserID, key)
+	if err != nil || previous == nil {
+		return nil, err
+	}
+	if previous.WalletID != wallet.ID || previous.Type != kind || (amount != 0 && previous.Amount != amount) {
+		return nil, utils.ErrIdempotencyKeyReused
+	}
+	return previous, nil
+}
+
+// replayedWallet reports the wallet as it was right after the original
+// transaction.
+func replayedWallet(wallet *models.Wallet, tran *models.Transaction) *models.Wallet {
+	snapshot := *wallet
+	snapshot.Balance = tran.BalanceAfter
+	snapshot.FrozenBalance = tran.FrozenAfter
+	return &snapshot
+}
+
+func optionalKey(key string) *string {
+	if key == "" {
+		return nil
+	}
+	return &key
 }
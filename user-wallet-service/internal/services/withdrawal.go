@@ -0,0 +1,237 @@
+package services
+
+import (
+	models "user-service/internal/models"
+	"user-service/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const pendingWithdrawalBatch = 100
+
+// Withdraw debits the available balance into a pending withdrawal and hands
+// it to the payout provider. A provider error leaves the withdrawal pending
+// for ProcessPendingWithdrawals to retry. stepUp runs in the same database
+// transaction, except for replays, which were verified the first time.
+func (s *walletService) Withdraw(userID uint, amount int64, currency, destination, description, idempotencyKey string, stepUp StepUpCheck) (*models.Wallet, *models.Withdrawal, error) {
+
+	s.logger.Info("service withdraw attempt", "user_id", userID, "amount", amount, "currency", currency)
+
+	var result *models.Wallet
+	var withdrawal *models.Withdrawal
+	err := s.db.Transaction(func(txDB *gorm.DB) error {
+		walletRepo := s.repo.WithDB(txDB)
+
+		wallet, err := walletRepo.GetByUserIDWithLock(userID, currency)
+		if err != nil {
+			return err
+		}
+		if wallet == nil {
+			return utils.ErrWalletNotFound
+		}
+		previous, err := findReplay(walletRepo, wallet, idempotencyKey, models.TransactionWithdraw, amount)
+		if err != nil {
+			return err
+		}
+		if previous != nil {
+			s.logger.Info("service withdraw replayed", "user_id", userID, "transaction_id", previous.ID)
+			withdrawal, err = walletRepo.GetWithdrawalByTransactionID(previous.ID)
+			if err != nil {
+				return err
+			}
+			if withdrawal == nil {
+				return utils.ErrWithdrawalNotFound
+			}
+			result = replayedWallet(wallet, previous)
+			return nil
+		}
+		if err := stepUp(txDB); err != nil {
+			return err
+		}
+
+		if wallet.Balance-wallet.FrozenBalance < amount {
+			return utils.ErrInsufficientAvailableBalance
+		}
+		beforeBalance := wallet.Balance
+		wallet.Balance -= amount
+		if err := walletRepo.SaveWallet(wallet); err != nil {
+			return err
+		}
+
+		transaction := models.Transaction{
+			WalletID:       wallet.ID,
+			UserID:         userID,
+			Type:           models.TransactionWithdraw,
+			Amount:         amount,
+			Currency:       wallet.Currency,
+			BalanceBefore:  beforeBalance,
+			BalanceAfter:   wallet.Balance,
+			FrozenBefore:   wallet.FrozenBalance,
+			FrozenAfter:    wallet.FrozenBalance,
+			Description:    description,
+			IdempotencyKey: optionalKey(idempotencyKey),
+		}
+		if err := walletRepo.CreateTransaction(&transaction); err != nil {
+			return err
+		}
+		if err := postEntry(walletRepo, wallet.Currency, models.TransactionWithdraw, transaction.ID, description,
+			decrease(models.LedgerUserAvailable, userID, amount),
+			increase(models.LedgerPayoutsPending, 0, amount),
+		); err != nil {
+			return err
+		}
+
+		withdrawal = &models.Withdrawal{
+			WalletID:      wallet.ID,
+			UserID:        userID,
+			Amount:        amount,
+			Currency:      wallet.Currency,
+			Destination:   destination,
+			Status:        models.WithdrawalPending,
+			TransactionID: transaction.ID,
+		}
+		if err := walletRepo.CreateWithdrawal(withdrawal); err != nil {
+			return err
+		}
+		result = wallet
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("service withdraw failed", "user_id", userID, "err", err.Error())
+		return nil, nil, err
+	}
+	s.logger.Info("service withdraw created", "user_id", userID, "withdrawal_id", withdrawal.ID)
+
+	if withdrawal.Status == models.WithdrawalPending {
+		if err := s.advanceWithdrawal(withdrawal); err != nil {
+			s.logger.Warn("service withdraw left pending", "withdrawal_id", withdrawal.ID, "err", err.Error())
+		}
+	}
+	return result, withdrawal, nil
+}
+
+func (s *walletService) ListWithdrawals(userID uint, limit, offset int) ([]models.Withdrawal, error) {
+	s.logger.Info("service list withdrawals", "user_id", userID, "limit", limit, "offset", offset)
+	return s.repo.ListWithdrawals(userID, limit, offset)
+}
+
+// ProcessPendingWithdrawals submits pending withdrawals the provider has not
+// accepted yet and polls the status of the others.
+func (s *walletService) ProcessPendingWithdrawals() error {
+	withdrawals, err := s.repo.ListPendingWithdrawals(pendingWithdrawalBatch)
+	if err != nil {
+		return err
+	}
+	for i := range withdrawals {
+		if err := s.advanceWithdrawal(&withdrawals[i]); err != nil {
+			s.logger.Warn("service withdrawal still pending", "withdrawal_id", withdrawals[i].ID, "err", err.Error())
+		}
+	}
+	return nil
+}
+
+// advanceWithdrawal sends the withdrawal to the provider if needed and
+// applies the status the provider reports.
+func (s *walletService) advanceWithdrawal(withdrawal *models.Withdrawal) error {
+	if withdrawal.ProviderReference == "" {
+		reference, err := s.payouts.SendPayout(withdrawal)
+		if err != nil {
+			return err
+		}
+		settled, err := s.recordProviderReference(withdrawal, reference)
+		if err != nil || settled {
+			return err
+		}
+	}
+
+	status, reason, err := s.payouts.PayoutStatus(withdrawal.ProviderReference)
+	if err != nil {
+		return err
+	}
+	if status == models.WithdrawalPending {
+		return nil
+	}
+	return s.settleWithdrawal(withdrawal.ID, status, reason)
+}
+
+// recordProviderReference stores the reference SendPayout returned unless
+// another worker got there first, in which case the withdrawal is refreshed
+// from the locked row. It reports whether the withdrawal is already settled.
+func (s *walletService) recordProviderReference(withdrawal *models.Withdrawal, reference string) (bool, error) {
+	err := s.db.Transaction(func(txDB *gorm.DB) error {
+		walletRepo := s.repo.WithDB(txDB)
+
+		current, err := walletRepo.GetWithdrawalWithLock(withdrawal.ID)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return utils.ErrWithdrawalNotFound
+		}
+		if current.Status == models.WithdrawalPending && current.ProviderReference == "" {
+			if err := walletRepo.SetWithdrawalProviderReference(current.ID, reference); err != nil {
+				return err
+			}
+			current.ProviderReference = reference
+		}
+		*withdrawal = *current
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return withdrawal.Status != models.WithdrawalPending, nil
+}
+
+// settleWithdrawal moves a pending withdrawal to its final status. A failed
+// withdrawal is refunded to the available balance.
+func (s *walletService) settleWithdrawal(id uint, status models.WithdrawalStatus, reason string) error {
+	return s.db.Transaction(func(txDB *gorm.DB) error {
+		walletRepo := s.repo.WithDB(txDB)
+
+		withdrawal, err := walletRepo.GetWithdrawalWithLock(id)
+		if err != nil {
+			return err
+		}
+		if withdrawal == nil {
+			return utils.ErrWithdrawalNotFound
+		}
+		if withdrawal.Status != models.WithdrawalPending {
+			return nil
+		}
+
+		withdrawal.Status = status
+		if status == models.WithdrawalCompleted {
+			if err := postEntry(walletRepo, withdrawal.Currency, models.TransactionWithdraw, withdrawal.TransactionID, "withdrawal completed",
+				decrease(models.LedgerPayoutsPending, 0, withdrawal.Amount),
+				increase(models.LedgerExternalCash, 0, withdrawal.Amount),
+			); err != nil {
+				return err
+			}
+			s.logger.Info("service withdrawal completed", "withdrawal_id", withdrawal.ID)
+			return walletRepo.SaveWithdrawal(withdrawal)
+		}
+
+		wallet, err := walletRepo.GetByUserIDWithLock(withdrawal.UserID, withdrawal.Currency)
+		if err != nil {
+			return err
+		}
+		if wallet == nil {
+			return utils.ErrWalletNotFound
+		}
+		transaction, err := creditWallet(walletRepo, wallet, models.TransactionRefund, withdrawal.Amount, "withdrawal failed: "+reason, nil)
+		if err != nil {
+			return err
+		}
+		if err := postEntry(walletRepo, withdrawal.Currency, models.TransactionRefund, transaction.ID, transaction.Description,
+			decrease(models.LedgerPayoutsPending, 0, withdrawal.Amount),
+			increase(models.LedgerUserAvailable, withdrawal.UserID, withdrawal.Amount),
+		); err != nil {
+			return err
+		}
+		withdrawal.FailureReason = reason
+		withdrawal.RefundTransactionID = &transaction.ID
+		s.logger.Info("service withdrawal failed and refunded", "withdrawal_id", withdrawal.ID, "reason", reason)
+		return walletRepo.SaveWithdrawal(withdrawal)
+	})
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"user-service/internal/config"
+	models "user-service/internal/models"
+)
+
+// racingPayoutProvider rejects every payout and, during the first
+// SendPayout, lets the worker run as if it had picked the withdrawal up in
+// the meantime.
+type racingPayoutProvider struct {
+	fakePayoutProvider
+	worker  func()
+	started atomic.Bool
+}
+
+func (p *racingPayoutProvider) SendPayout(withdrawal *models.Withdrawal) (string, error) {
+	if p.started.CompareAndSwap(false, true) {
+		p.worker()
+	}
+	return "payout-ref-fail", nil
+}
+
+func TestWithdrawalSettledByWorkerDuringSend(t *testing.T) {
+	provider := &racingPayoutProvider{}
+	s := newTestWalletService(newTestDB(t), config.SettlementConfig{}, provider)
+	provider.worker = func() {
+		if err := s.ProcessPendingWithdrawals(); err != nil {
+			t.Errorf("worker: %v", err)
+		}
+	}
+	deposit(t, s, 2, 1000)
+
+	_, withdrawal, err := s.Withdraw(2, 400, models.DefaultCurrency, "card", "cash out", "withdraw-1", noStepUp)
+	if err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+
+	var stored models.Withdrawal
+	if err := s.db.First(&stored, withdrawal.ID).Error; err != nil {
+		t.Fatalf("load withdrawal: %v", err)
+	}
+	if stored.Status != models.WithdrawalFailed || stored.RefundTransactionID == nil {
+		t.Fatalf("withdrawal status %s refund %v, want failed and refunded", stored.Status, stored.RefundTransactionID)
+	}
+	var refunds int64
+	if err := s.db.Model(&models.Transaction{}).Where("type = ?", models.TransactionRefund).Count(&refunds).Error; err != nil {
+		t.Fatalf("count refunds: %v", err)
+	}
+	if refunds != 1 {
+		t.Fatalf("got %d refunds, want 1", refunds)
+	}
+	wallet, err := s.GetWallet(2, models.DefaultCurrency)
+	if err != nil {
+		t.Fatalf("wallet: %v", err)
+	}
+	if wallet.Balance != 1000 {
+		t.Fatalf("balance = %d, want 1000", wallet.Balance)
+	}
+	assertReconciled(t, s)
+}
@@ -0,0 +1,26 @@
+package transport
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// internalTokenHeader carries the shared secret other services present on
+// /internal routes. The gateway never routes /internal, but the service port
+// can still be reached directly.
+const internalTokenHeader = "X-Internal-Token"
+
+// RequireInternalToken admits requests carrying the shared INTERNAL_API_TOKEN.
+// Without a configured token every request is rejected.
+func RequireInternalToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given := c.GetHeader(internalTokenHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
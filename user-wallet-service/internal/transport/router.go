@@ -2,6 +2,7 @@ package transport
 
 import (
 	"log/slog"
+	"user-service/internal/models"
 	"user-service/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -10,6 +11,7 @@ import (
 func SetupRouter(
 	logger *slog.Logger,
 	authHandler *AuthHandler, jwt services.JWTService, walletHandler *WalletHandler,
+	internalToken string,
 ) *gin.Engine {
 	r := gin.New()
 
@@ -17,28 +19,68 @@ func SetupRouter(
 
 	r.Use(LoggingMiddleware(logger))
 
+	r.GET("/.well-known/jwks.json", authHandler.JWKS)
+
+	// not routed by the gateway; called by the gateway and auction-service
+	internal := r.Group("/internal", RequireInternalToken(internalToken))
+	internal.GET("/revocations", authHandler.Revocations)
+
+	// hold mutations are made by auction-service only
+	internalWallet := internal.Group("/wallet")
+	{
+		internalWallet.POST("/holds", walletHandler.WalletHold)
+		internalWallet.POST("/holds/release", walletHandler.WalletReleaseHold)
+		internalWallet.POST("/holds/capture", walletHandler.WalletCaptureHold)
+		internalWallet.POST("/holds/payout", walletHandler.WalletPayoutHold)
+		// deprecated, see WalletFreeze; not exposed to users, since they
+		// would release their own holds
+		internalWallet.POST("/freeze", walletHandler.WalletFreeze)
+		internalWallet.POST("/unfreeze", walletHandler.WalletUnfreeze)
+		internalWallet.POST("/charge", walletHandler.WalletCharge)
+	}
+
 	api := r.Group("/api")
 	{
 		auth := api.Group("/auth")
 		{
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
+			auth.POST("/login/mfa", authHandler.LoginMFA)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/logout", authHandler.Logout)
+			auth.POST("/forgot-password", authHandler.ForgotPassword)
+			auth.POST("/reset-password", authHandler.ResetPassword)
+			auth.POST("/verify-email", authHandler.VerifyEmail)
 		}
 
 		users := api.Group("/users")
 		{
 			users.GET("/me", authHandler.Me)
 			users.PUT("/me", authHandler.UpdateMe)
+			users.PUT("/me/password", authHandler.ChangePassword)
+			users.POST("/me/verify-email", authHandler.ResendEmailVerification)
+			users.POST("/me/2fa/setup", authHandler.SetupTOTP)
+			users.POST("/me/2fa/enable", authHandler.EnableTOTP)
+			users.POST("/me/2fa/disable", authHandler.DisableTOTP)
+			users.POST("/me/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+			users.POST("/:id/unlock", AuthMiddleware(jwt), RequireRoles(models.RoleAdmin), authHandler.UnlockLogin)
+			users.GET("/:id/login-attempts", AuthMiddleware(jwt), RequireRoles(models.RoleAdmin), authHandler.ListLoginAttempts)
 		}
 
+		api.POST("/webhooks/payments", walletHandler.PaymentWebhook)
+
 		wallet := api.Group("/wallet")
 		{
 			wallet.GET("/", walletHandler.GetWallet)
+			wallet.GET("/all", walletHandler.ListWallets)
 			wallet.POST("/deposit", walletHandler.WalletDeposit)
-			wallet.POST("/freeze", walletHandler.WalletFreeze)
-			wallet.POST("/unfreeze", walletHandler.WalletUnfreeze)
-			wallet.POST("/charge", walletHandler.WalletCharge)
+			wallet.GET("/deposits", walletHandler.ListDeposits)
+			wallet.GET("/holds", walletHandler.ListHolds)
 			wallet.GET("/transactions", walletHandler.ListTransactions)
+			wallet.GET("/transactions/export", walletHandler.ExportTransactions)
+			wallet.POST("/withdraw", walletHandler.WalletWithdraw)
+			wallet.GET("/withdrawals", walletHandler.ListWithdrawals)
+			wallet.GET("/reconciliation", AuthMiddleware(jwt), RequireRoles(models.RoleAdmin), walletHandler.Reconcile)
 		}
 	}
 
@@ -1,29 +1,74 @@
 package transport
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"log/slog"
 	"user-service/internal/models"
 	m "user-service/internal/models"
 	"user-service/internal/services"
+	"user-service/internal/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
-	users  services.UserService
-	jwt    services.JWTService
-	logger *slog.Logger
+	users    services.UserService
+	sessions services.SessionService
+	jwt      services.JWTService
+	logger   *slog.Logger
 }
 
-func NewAuthHandler(users services.UserService, jwt services.JWTService, logger *slog.Logger) *AuthHandler {
-	return &AuthHandler{users: users, jwt: jwt, logger: logger}
+func NewAuthHandler(users services.UserService, sessions services.SessionService, jwt services.JWTService, logger *slog.Logger) *AuthHandler {
+	return &AuthHandler{users: users, sessions: sessions, jwt: jwt, logger: logger}
 }
 
 func toSimple(u *m.User) m.SimpleUser {
-	return m.SimpleUser{ID: u.ID, FullName: u.FullName, Email: u.Email, Role: u.Role}
+	return m.SimpleUser{
+		ID: u.ID, FullName: u.FullName, Email: u.Email, Role: u.Role,
+		EmailVerified: u.EmailVerified(), TwoFactorEnabled: u.TwoFactorEnabled(),
+	}
+}
+
+func authResponse(u *m.User, tokens *m.TokenPair) m.AuthResponse {
+	return m.AuthResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+		User:         toSimple(u),
+	}
+}
+
+func authErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, utils.ErrInvalidRefreshToken), errors.Is(err, utils.ErrRefreshTokenReused):
+		return http.StatusUnauthorized
+	case errors.Is(err, utils.ErrInvalidUserToken):
+		return http.StatusBadRequest
+	case errors.Is(err, utils.ErrInvalidMFAChallenge), errors.Is(err, utils.ErrInvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, utils.ErrLoginLocked), errors.Is(err, utils.ErrMFALocked):
+		return http.StatusTooManyRequests
+	case errors.Is(err, utils.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, utils.ErrInvalidPassword), errors.Is(err, utils.ErrInvalidMFACode), errors.Is(err, utils.ErrMFARequired):
+		return http.StatusForbidden
+	case errors.Is(err, utils.ErrMFAAlreadyEnabled), errors.Is(err, utils.ErrMFANotEnabled), errors.Is(err, utils.ErrMFANotSetUp):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// setRetryAfter tells a throttled client when to try logging in again.
+func setRetryAfter(c *gin.Context, err error) {
+	var locked *services.LoginLockedError
+	if errors.As(err, &locked) {
+		c.Header("Retry-After", strconv.Itoa(int(locked.RetryAfter/time.Second)))
+	}
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -33,16 +78,16 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	h.logger.Info("register attempt", "email", req.Email, "role", string(req.Role))
+	h.logger.Info("register attempt", "email", req.Email)
 
-	u, token, err := h.users.Register(req.Email, req.Password, req.Role)
+	u, tokens, err := h.users.Register(req.Email, req.Password)
 	if err != nil {
 		h.logger.Error("register failed", "email", req.Email, "err", err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	h.logger.Info("user registered", "user_id", u.ID, "email", u.Email)
-	c.JSON(http.StatusCreated, m.AuthResponse{Token: token, User: toSimple(u)})
+	c.JSON(http.StatusCreated, authResponse(u, tokens))
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -54,14 +99,94 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 	h.logger.Info("login attempt", "email", req.Email)
 
-	u, token, err := h.users.Login(req.Email, req.Password)
+	u, tokens, challenge, err := h.users.Login(req.Email, req.Password, c.ClientIP())
 	if err != nil {
 		h.logger.Warn("login failed", "email", req.Email, "err", err.Error())
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		setRetryAfter(c, err)
+		c.JSON(authErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if challenge != nil {
+		h.logger.Info("login mfa required", "user_id", u.ID)
+		c.JSON(http.StatusOK, m.MFAChallengeResponse{MFARequired: true, MFAToken: challenge.Token, ExpiresIn: challenge.ExpiresIn})
 		return
 	}
 	h.logger.Info("login success", "user_id", u.ID, "email", u.Email)
-	c.JSON(http.StatusOK, m.AuthResponse{Token: token, User: toSimple(u)})
+	c.JSON(http.StatusOK, authResponse(u, tokens))
+}
+
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var req m.LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("login mfa bad request", "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, tokens, err := h.users.LoginMFA(req.MFAToken, req.Code, c.ClientIP())
+	if err != nil {
+		h.logger.Warn("login mfa failed", "err", err.Error())
+		setRetryAfter(c, err)
+		status := authErrorStatus(err)
+		if errors.Is(err, utils.ErrInvalidMFACode) {
+			status = http.StatusUnauthorized
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.Info("login success", "user_id", u.ID, "email", u.Email)
+	c.JSON(http.StatusOK, authResponse(u, tokens))
+}
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req m.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("refresh bad request", "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, tokens, err := h.sessions.Refresh(req.RefreshToken)
+	if err != nil {
+		h.logger.Warn("refresh failed", "err", err.Error())
+		c.JSON(authErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, authResponse(u, tokens))
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req m.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("logout bad request", "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.sessions.Logout(req.RefreshToken); err != nil {
+		h.logger.Warn("logout failed", "err", err.Error())
+		c.JSON(authErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// JWKS publishes the public keys access tokens are signed with.
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.jwt.JWKS())
+}
+
+// Revocations lists access tokens revoked before their expiry; the gateway
+// polls it to reject them.
+func (h *AuthHandler) Revocations(c *gin.Context) {
+	revoked, err := h.sessions.ListRevocations()
+	if err != nil {
+		h.logger.Error("list revocations failed", "err", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revocations": revoked})
 }
 
 func (h *AuthHandler) Me(c *gin.Context) {
@@ -130,3 +255,196 @@ func (h *AuthHandler) UpdateMe(c *gin.Context) {
 	h.logger.Info("profile updated", "user_id", u.ID)
 	c.JSON(http.StatusOK, toSimple(u))
 }
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req m.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("forgot password bad request", "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.users.ForgotPassword(req.Email); err != nil {
+		h.logger.Error("forgot password failed", "err", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req m.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("reset password bad request", "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.users.ResetPassword(req.Token, req.Password); err != nil {
+		h.logger.Warn("reset password failed", "err", err.Error())
+		c.JSON(authErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req m.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("verify email bad request", "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, err := h.users.VerifyEmail(req.Token)
+	if err != nil {
+		h.logger.Warn("verify email failed", "err", err.Error())
+		c.JSON(authErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, toSimple(u))
+}
+
+func (h *AuthHandler) ResendEmailVerification(c *gin.Context) {
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uid <= 0 {
+		h.logger.Warn("resend verification unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if err := h.users.SendEmailVerification(uint(uid)); err != nil {
+		h.logger.Error("resend verification failed", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uid <= 0 {
+		h.logger.Warn("change password unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req m.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("change password bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.users.ChangePassword(uint(uid), req.CurrentPassword, req.NewPassword); err != nil {
+		h.logger.Warn("change password failed", "user_id", uid, "err", err.Error())
+		c.JSON(authErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) SetupTOTP(c *gin.Context) {
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uid <= 0 {
+		h.logger.Warn("totp setup unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	setup, err := h.users.SetupTOTP(uint(uid))
+	if err != nil {
+		h.logger.Warn("totp setup failed", "user_id", uid, "err", err.Error())
+		c.JSON(authErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, setup)
+}
+
+func (h *AuthHandler) EnableTOTP(c *gin.Context) {
+	h.withMFACode(c, "totp enable", func(uid uint, code string) (any, error) {
+		codes, err := h.users.EnableTOTP(uid, code)
+		return m.RecoveryCodesResponse{RecoveryCodes: codes}, err
+	})
+}
+
+func (h *AuthHandler) DisableTOTP(c *gin.Context) {
+	h.withMFACode(c, "totp disable", func(uid uint, code string) (any, error) {
+		return nil, h.users.DisableTOTP(uid, code)
+	})
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	h.withMFACode(c, "recovery codes", func(uid uint, code string) (any, error) {
+		codes, err := h.users.RegenerateRecoveryCodes(uid, code)
+		return m.RecoveryCodesResponse{RecoveryCodes: codes}, err
+	})
+}
+
+// withMFACode runs a two factor management action that takes a code in the
+// body; a nil result is answered with 204.
+func (h *AuthHandler) withMFACode(c *gin.Context, action string, run func(uid uint, code string) (any, error)) {
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uid <= 0 {
+		h.logger.Warn(action + " unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req m.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn(action+" bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := run(uint(uid), req.Code)
+	if err != nil {
+		h.logger.Warn(action+" failed", "user_id", uid, "err", err.Error())
+		c.JSON(authErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.Info(action+" done", "user_id", uid)
+	if result == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *AuthHandler) UnlockLogin(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	if err := h.users.UnlockLogin(uint(id)); err != nil {
+		h.logger.Warn("unlock login failed", "user_id", id, "err", err.Error())
+		c.JSON(authErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.Info("login unlocked", "user_id", id, "admin_id", c.GetUint("user_id"))
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) ListLoginAttempts(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	attempts, err := h.users.ListLoginAttempts(uint(id), limit)
+	if err != nil {
+		h.logger.Warn("list login attempts failed", "user_id", id, "err", err.Error())
+		c.JSON(authErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attempts": attempts})
+}
@@ -1,11 +1,16 @@
 package transport
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 	"user-service/internal/models"
 	"user-service/internal/services"
 	"user-service/internal/utils"
@@ -34,17 +39,47 @@ func (h *WalletHandler) GetWallet(c *gin.Context) {
 		return
 	}
 	uid := uint(uidInt)
-	h.logger.Info("get wallet", "user_id", uid)
 
-	wallet, err := h.wallet.GetWallet(uid)
+	var req models.WalletForRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		h.logger.Warn("get wallet bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = models.DefaultCurrency
+	}
+	h.logger.Info("get wallet", "user_id", uid, "currency", req.Currency)
+
+	wallet, err := h.wallet.GetWallet(uid, req.Currency)
 	if err != nil {
 		h.logger.Error("get wallet failed", "user_id", uid, "err", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, wallet)
 }
 
+func (h *WalletHandler) ListWallets(c *gin.Context) {
+
+	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uidInt == 0 {
+		h.logger.Warn("list wallets unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	uid := uint(uidInt)
+	h.logger.Info("list wallets", "user_id", uid)
+
+	wallets, err := h.wallet.ListWallets(uid)
+	if err != nil {
+		h.logger.Error("list wallets failed", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"wallets": wallets})
+}
+
 func (h *WalletHandler) WalletDeposit(c *gin.Context) {
 	var req models.TransactionForRequest
 
@@ -60,41 +95,152 @@ func (h *WalletHandler) WalletDeposit(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	log.Println("WALLET AUTH:", c.GetHeader("Authorization")) //удалить
 	if req.Description == "" {
 		req.Description = utils.DefaultDescription
 	}
+	if req.Currency == "" {
+		req.Currency = models.DefaultCurrency
+	}
+
+	key, err := idempotencyKey(c)
+	if err != nil {
+		h.logger.Warn("deposit bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	h.logger.Info("deposit attempt", "user_id", uid, "amount", req.Amount)
 
-	wallet, transaction, err := h.wallet.Deposit(uid, req.Amount, req.Description)
+	deposit, err := h.wallet.CreateDeposit(uid, req.Amount, req.Currency, req.Description, key)
 	if err != nil {
 		h.logger.Error("deposit failed", "user_id", uid, "err", err.Error())
+		c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info("deposit created", "user_id", uid, "reference", deposit.Reference)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"deposit":      deposit,
+		"checkout_url": deposit.CheckoutURL},
+	)
+}
+
+func (h *WalletHandler) ListDeposits(c *gin.Context) {
+
+	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uidInt == 0 {
+		h.logger.Warn("list deposits unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	uid := uint(uidInt)
+
+	limit, err := parseQueryInt(c, "limit", 10, 1, 100)
+	if err != nil {
+		h.logger.Warn("list deposits bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	offset, err := parseQueryInt(c, "offset", 0, 0, 10000)
+	if err != nil {
+		h.logger.Warn("list deposits bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	deposits, err := h.wallet.ListDeposits(uid, limit, offset)
+	if err != nil {
+		h.logger.Error("list deposits failed", "user_id", uid, "err", err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	h.logger.Info("deposit success", "user_id", uid, "transaction_id", transaction.ID)
+	c.JSON(http.StatusOK, gin.H{"deposits": deposits})
+}
+
+// PaymentWebhook receives payment notifications from the payment provider.
+// It is not behind user authentication; the provider signature is the only
+// proof the payment happened.
+func (h *WalletHandler) PaymentWebhook(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		h.logger.Warn("payment webhook bad request", "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.wallet.HandlePaymentWebhook(body, c.GetHeader(paymentSignatureHeader)); err != nil {
+		h.logger.Error("payment webhook failed", "err", err.Error())
+		c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook processed"})
+}
+
+func (h *WalletHandler) WalletHold(c *gin.Context) {
+	var req models.HoldForRequest
+
+	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uidInt == 0 {
+		h.logger.Warn("hold unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	uid := uint(uidInt)
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("hold bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Description == "" {
+		req.Description = utils.DefaultDescription
+	}
+	if req.Currency == "" {
+		req.Currency = models.DefaultCurrency
+	}
+
+	key, err := idempotencyKey(c)
+	if err != nil {
+		h.logger.Warn("hold bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info("hold attempt", "user_id", uid, "reference_type", req.ReferenceType, "reference_id", req.ReferenceID, "amount", req.Amount)
+
+	wallet, transaction, err := h.wallet.Hold(uid, req.HoldReference, req.Amount, req.Currency, req.Description, key)
+	if err != nil {
+		h.logger.Error("hold failed", "user_id", uid, "err", err.Error())
+		c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info("hold success", "user_id", uid, "transaction_id", transaction.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"wallet":         wallet,
+		"hold_id":        transaction.HoldID,
 		"transaction_id": transaction.ID},
 	)
 }
 
-func (h *WalletHandler) WalletFreeze(c *gin.Context) {
-	var req models.TransactionForRequest
+func (h *WalletHandler) WalletReleaseHold(c *gin.Context) {
+	var req models.HoldSettleForRequest
 
 	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
 	if uidInt == 0 {
-		h.logger.Warn("freeze unauthorized")
+		h.logger.Warn("release hold unauthorized")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 	uid := uint(uidInt)
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Warn("freeze bad request", "user_id", uid, "err", err.Error())
+		h.logger.Warn("release hold bad request", "user_id", uid, "err", err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -103,36 +249,44 @@ func (h *WalletHandler) WalletFreeze(c *gin.Context) {
 		req.Description = utils.DefaultDescription
 	}
 
-	h.logger.Info("freeze attempt", "user_id", uid, "amount", req.Amount)
+	key, err := idempotencyKey(c)
+	if err != nil {
+		h.logger.Warn("release hold bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info("release hold attempt", "user_id", uid, "reference_type", req.ReferenceType, "reference_id", req.ReferenceID, "amount", req.Amount)
 
-	wallet, transaction, err := h.wallet.Freeze(uid, req.Amount, req.Description)
+	wallet, transaction, err := h.wallet.ReleaseHold(uid, req.HoldReference, req.Amount, req.Description, key)
 	if err != nil {
-		h.logger.Error("freeze failed", "user_id", uid, "err", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.logger.Error("release hold failed", "user_id", uid, "err", err.Error())
+		c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	h.logger.Info("freeze success", "user_id", uid, "transaction_id", transaction.ID)
+	h.logger.Info("release hold success", "user_id", uid, "transaction_id", transaction.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"wallet":         wallet,
+		"hold_id":        transaction.HoldID,
 		"transaction_id": transaction.ID},
 	)
 }
 
-func (h *WalletHandler) WalletUnfreeze(c *gin.Context) {
-	var req models.TransactionForRequest
+func (h *WalletHandler) WalletCaptureHold(c *gin.Context) {
+	var req models.HoldSettleForRequest
 
 	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
 	if uidInt == 0 {
-		h.logger.Warn("unfreeze unauthorized")
+		h.logger.Warn("capture hold unauthorized")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 	uid := uint(uidInt)
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Warn("unfreeze bad request", "user_id", uid, "err", err.Error())
+		h.logger.Warn("capture hold bad request", "user_id", uid, "err", err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -141,36 +295,44 @@ func (h *WalletHandler) WalletUnfreeze(c *gin.Context) {
 		req.Description = utils.DefaultDescription
 	}
 
-	h.logger.Info("unfreeze attempt", "user_id", uid, "amount", req.Amount)
+	key, err := idempotencyKey(c)
+	if err != nil {
+		h.logger.Warn("capture hold bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info("capture hold attempt", "user_id", uid, "reference_type", req.ReferenceType, "reference_id", req.ReferenceID, "amount", req.Amount)
 
-	wallet, transaction, err := h.wallet.Unfreeze(uid, req.Amount, req.Description)
+	wallet, transaction, err := h.wallet.CaptureHold(uid, req.HoldReference, req.Amount, req.Description, key)
 	if err != nil {
-		h.logger.Error("unfreeze failed", "user_id", uid, "err", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.logger.Error("capture hold failed", "user_id", uid, "err", err.Error())
+		c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	h.logger.Info("unfreeze success", "user_id", uid, "transaction_id", transaction.ID)
+	h.logger.Info("capture hold success", "user_id", uid, "transaction_id", transaction.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"wallet":         wallet,
+		"hold_id":        transaction.HoldID,
 		"transaction_id": transaction.ID},
 	)
 }
 
-func (h *WalletHandler) WalletCharge(c *gin.Context) {
-	var req models.TransactionForRequest
+func (h *WalletHandler) WalletPayoutHold(c *gin.Context) {
+	var req models.HoldPayoutForRequest
 
 	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
 	if uidInt == 0 {
-		h.logger.Warn("charge unauthorized")
+		h.logger.Warn("payout unauthorized")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 	uid := uint(uidInt)
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Warn("charge bad request", "user_id", uid, "err", err.Error())
+		h.logger.Warn("payout bad request", "user_id", uid, "err", err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -179,23 +341,60 @@ func (h *WalletHandler) WalletCharge(c *gin.Context) {
 		req.Description = utils.DefaultDescription
 	}
 
-	h.logger.Info("charge attempt", "user_id", uid, "amount", req.Amount)
+	key, err := idempotencyKey(c)
+	if err != nil {
+		h.logger.Warn("payout bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info("payout attempt", "user_id", uid, "seller_id", req.SellerID, "reference_type", req.ReferenceType, "reference_id", req.ReferenceID, "amount", req.Amount)
 
-	wallet, transaction, err := h.wallet.Charge(uid, req.Amount, req.Description)
+	wallet, transaction, err := h.wallet.PayoutHold(uid, req.HoldReference, req.Amount, req.SellerID, req.Description, key)
 	if err != nil {
-		h.logger.Error("charge failed", "user_id", uid, "err", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.logger.Error("payout failed", "user_id", uid, "err", err.Error())
+		c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	h.logger.Info("charge success", "user_id", uid, "transaction_id", transaction.ID)
+	h.logger.Info("payout success", "user_id", uid, "transaction_id", transaction.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"wallet":         wallet,
+		"hold_id":        transaction.HoldID,
 		"transaction_id": transaction.ID},
 	)
 }
 
+func (h *WalletHandler) ListHolds(c *gin.Context) {
+
+	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uidInt == 0 {
+		h.logger.Warn("list holds unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	uid := uint(uidInt)
+
+	status := models.HoldStatus(c.Query("status"))
+	switch status {
+	case "", models.HoldActive, models.HoldReleased, models.HoldCaptured:
+	default:
+		h.logger.Warn("list holds bad request", "user_id", uid, "status", status)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+
+	holds, err := h.wallet.ListHolds(uid, status)
+	if err != nil {
+		h.logger.Error("list holds failed", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"holds": holds})
+}
+
 func (h *WalletHandler) ListTransactions(c *gin.Context) {
 
 	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
@@ -206,30 +405,343 @@ func (h *WalletHandler) ListTransactions(c *gin.Context) {
 	}
 	uid := uint(uidInt)
 
-	limit, err := parseQueryInt(c, "limit", 10, 1, 100)
+	filter, err := transactionFilter(c)
 	if err != nil {
 		h.logger.Warn("list transactions bad request", "user_id", uid, "err", err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	offset, err := parseQueryInt(c, "offset", 0, 0, 10000)
+	pageSize, err := parseQueryInt(c, "page_size", 20, 1, 100)
 	if err != nil {
 		h.logger.Warn("list transactions bad request", "user_id", uid, "err", err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	h.logger.Info("list transactions", "user_id", uid, "limit", limit, "offset", offset)
+	h.logger.Info("list transactions", "user_id", uid, "page_size", pageSize)
 
-	transactions, err := h.wallet.ListTransactions(uid, limit, offset)
+	page, err := h.wallet.ListTransactions(uid, filter, c.Query("cursor"), pageSize)
 	if err != nil {
 		h.logger.Error("list transactions failed", "user_id", uid, "err", err.Error())
+		c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// ExportTransactions streams the filtered history as CSV or as a JSON array.
+// Rows are written as they are read: an error reading the first batch is
+// answered with an error status, a later one can only cut the download short.
+func (h *WalletHandler) ExportTransactions(c *gin.Context) {
+
+	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uidInt == 0 {
+		h.logger.Warn("export transactions unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	uid := uint(uidInt)
+
+	filter, err := transactionFilter(c)
+	if err != nil {
+		h.logger.Warn("export transactions bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		h.logger.Warn("export transactions bad request", "user_id", uid, "format", format)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format"})
+		return
+	}
+
+	h.logger.Info("export transactions", "user_id", uid, "format", format)
+
+	contentType := "text/csv; charset=utf-8"
+	writeRows, finish := transactionCSVWriter(c.Writer)
+	if format == "json" {
+		contentType = "application/json; charset=utf-8"
+		writeRows, finish = transactionJSONWriter(c.Writer)
+	}
+	// The response starts with the first batch, so a failure to read it still
+	// gets an error status.
+	started := false
+	start := func() {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="transactions.%s"`, format))
+		c.Header("Content-Type", contentType)
+		c.Status(http.StatusOK)
+		started = true
+	}
+	write := func(batch []models.Transaction) error {
+		if !started {
+			start()
+		}
+		return writeRows(batch)
+	}
+
+	if err := h.wallet.ExportTransactions(uid, filter, write); err != nil {
+		h.logger.Error("export transactions failed", "user_id", uid, "err", err.Error())
+		if !started {
+			c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if !started {
+		start()
+	}
+	if err := finish(); err != nil {
+		h.logger.Error("export transactions failed", "user_id", uid, "err", err.Error())
+	}
+}
+
+// transactionFilter reads the history filters shared by the list and export
+// endpoints. A reference_id without reference_type refers to a lot.
+func transactionFilter(c *gin.Context) (models.TransactionFilter, error) {
+	var filter models.TransactionFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		return filter, err
+	}
+	if filter.ReferenceType != "" && filter.ReferenceID == "" {
+		return filter, errors.New("reference_type requires reference_id")
+	}
+	if filter.ReferenceID != "" && filter.ReferenceType == "" {
+		filter.ReferenceType = "lot"
+	}
+	return filter, nil
+}
+
+func transactionCSVWriter(w io.Writer) (func([]models.Transaction) error, func() error) {
+	out := csv.NewWriter(w)
+	header := []string{
+		"id", "created_at", "type", "amount", "currency",
+		"balance_before", "balance_after", "frozen_before", "frozen_after", "hold_id", "description",
+	}
+	headerWritten := false
+	write := func(batch []models.Transaction) error {
+		if !headerWritten {
+			if err := out.Write(header); err != nil {
+				return err
+			}
+			headerWritten = true
+		}
+		for _, t := range batch {
+			holdID := ""
+			if t.HoldID != nil {
+				holdID = strconv.FormatUint(uint64(*t.HoldID), 10)
+			}
+			record := []string{
+				strconv.FormatUint(uint64(t.ID), 10),
+				t.CreatedAt.UTC().Format(time.RFC3339),
+				string(t.Type),
+				strconv.FormatInt(t.Amount, 10),
+				t.Currency,
+				strconv.FormatInt(t.BalanceBefore, 10),
+				strconv.FormatInt(t.BalanceAfter, 10),
+				strconv.FormatInt(t.FrozenBefore, 10),
+				strconv.FormatInt(t.FrozenAfter, 10),
+				holdID,
+				csvText(t.Description),
+			}
+			if err := out.Write(record); err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	}
+	finish := func() error {
+		if !headerWritten {
+			return write(nil)
+		}
+		return nil
+	}
+	return write, finish
+}
+
+// csvText keeps spreadsheet applications from evaluating user supplied text
+// as a formula.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func transactionJSONWriter(w io.Writer) (func([]models.Transaction) error, func() error) {
+	started := false
+	write := func(batch []models.Transaction) error {
+		for _, t := range batch {
+			sep := ","
+			if !started {
+				sep = "["
+				started = true
+			}
+			row, err := json.Marshal(t)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, sep); err != nil {
+				return err
+			}
+			if _, err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	finish := func() error {
+		end := "]"
+		if !started {
+			end = "[]"
+		}
+		_, err := io.WriteString(w, end)
+		return err
+	}
+	return write, finish
+}
+
+// paymentSignatureHeader carries the provider's HMAC-SHA256 of the webhook
+// body.
+const paymentSignatureHeader = "X-Payment-Signature"
+
+// mfaCodeHeader carries the TOTP or recovery code that confirms a sensitive
+// wallet operation of a user with two factor authentication.
+const mfaCodeHeader = "X-MFA-Code"
+
+// idempotencyKey reads the optional Idempotency-Key header of a wallet
+// mutation.
+func idempotencyKey(c *gin.Context) (string, error) {
+	key := c.GetHeader("Idempotency-Key")
+	if len(key) > 128 {
+		return "", utils.ErrIdempotencyKeyTooLong
+	}
+	return key, nil
+}
+
+func walletErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, utils.ErrHoldNotFound), errors.Is(err, utils.ErrPaymentIntentNotFound),
+		errors.Is(err, utils.ErrWalletNotFound), errors.Is(err, utils.ErrWithdrawalNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, utils.ErrInvalidWebhookSignature):
+		return http.StatusUnauthorized
+	case errors.Is(err, utils.ErrMFARequired), errors.Is(err, utils.ErrInvalidMFACode):
+		return http.StatusForbidden
+	case errors.Is(err, utils.ErrMFALocked):
+		return http.StatusTooManyRequests
+	case errors.Is(err, utils.ErrAmountMustBePositive), errors.Is(err, utils.ErrInvalidCursor), errors.Is(err, utils.ErrSellerIsBuyer),
+		errors.Is(err, utils.ErrIdempotencyKeyTooLong):
+		return http.StatusBadRequest
+	case errors.Is(err, utils.ErrIdempotencyKeyReused), errors.Is(err, utils.ErrHoldNotActive), errors.Is(err, utils.ErrCurrencyMismatch),
+		errors.Is(err, utils.ErrInsufficientAvailableBalance), errors.Is(err, utils.ErrInsufficientFrozenBalance),
+		errors.Is(err, utils.ErrInsufficientHold), errors.Is(err, utils.ErrResultingBalanceNegative):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *WalletHandler) WalletWithdraw(c *gin.Context) {
+	var req models.WithdrawalForRequest
+
+	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uidInt == 0 {
+		h.logger.Warn("withdraw unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	uid := uint(uidInt)
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("withdraw bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Description == "" {
+		req.Description = utils.DefaultDescription
+	}
+	if req.Currency == "" {
+		req.Currency = models.DefaultCurrency
+	}
+
+	key, err := idempotencyKey(c)
+	if err != nil {
+		h.logger.Warn("withdraw bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info("withdraw attempt", "user_id", uid, "amount", req.Amount)
+
+	stepUp := h.users.StepUp(uid, c.GetHeader(mfaCodeHeader))
+	wallet, withdrawal, err := h.wallet.Withdraw(uid, req.Amount, req.Currency, req.Destination, req.Description, key, stepUp)
+	if errors.Is(err, utils.ErrInvalidMFACode) {
+		if recordErr := h.users.RecordMFAFailure(uid); recordErr != nil {
+			h.logger.Error("withdraw record mfa failure failed", "user_id", uid, "err", recordErr.Error())
+		}
+	}
+	if err != nil {
+		h.logger.Error("withdraw failed", "user_id", uid, "err", err.Error())
+		c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info("withdraw accepted", "user_id", uid, "withdrawal_id", withdrawal.ID, "status", withdrawal.Status)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"wallet":     wallet,
+		"withdrawal": withdrawal},
+	)
+}
+
+func (h *WalletHandler) ListWithdrawals(c *gin.Context) {
+
+	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uidInt == 0 {
+		h.logger.Warn("list withdrawals unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	uid := uint(uidInt)
+
+	limit, err := parseQueryInt(c, "limit", 10, 1, 100)
+	if err != nil {
+		h.logger.Warn("list withdrawals bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	offset, err := parseQueryInt(c, "offset", 0, 0, 10000)
+	if err != nil {
+		h.logger.Warn("list withdrawals bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	withdrawals, err := h.wallet.ListWithdrawals(uid, limit, offset)
+	if err != nil {
+		h.logger.Error("list withdrawals failed", "user_id", uid, "err", err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"transactions": transactions})
+	c.JSON(http.StatusOK, gin.H{"withdrawals": withdrawals})
+}
+
+func (h *WalletHandler) Reconcile(c *gin.Context) {
+	report, err := h.wallet.Reconcile()
+	if err != nil {
+		h.logger.Error("reconcile failed", "err", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !report.Balanced {
+		h.logger.Warn("ledger out of balance", "unbalanced_entries", len(report.UnbalancedEntries), "mismatches", len(report.Mismatches))
+	}
+	c.JSON(http.StatusOK, report)
 }
 
 func parseQueryInt(c *gin.Context, key string, defaultVal, min, max int) (int, error) {
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"net/http"
+	"strconv"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// legacyWalletOp is one of the deprecated wallet operations that freeze,
+// release or charge the wallet's legacy hold instead of a hold by reference.
+type legacyWalletOp func(userID uint, amount int64, currency, description, idempotencyKey string) (*models.Wallet, *models.Transaction, error)
+
+// WalletFreeze, WalletUnfreeze and WalletCharge serve the endpoints removed
+// in favour of /holds until every client has moved to holds. Responses carry
+// a Deprecation header pointing to the replacement.
+func (h *WalletHandler) WalletFreeze(c *gin.Context) {
+	h.legacyWalletOp(c, "freeze", "/internal/wallet/holds", h.wallet.LegacyFreeze)
+}
+
+func (h *WalletHandler) WalletUnfreeze(c *gin.Context) {
+	h.legacyWalletOp(c, "unfreeze", "/internal/wallet/holds/release", h.wallet.LegacyUnfreeze)
+}
+
+func (h *WalletHandler) WalletCharge(c *gin.Context) {
+	h.legacyWalletOp(c, "charge", "/internal/wallet/holds/capture", h.wallet.LegacyCharge)
+}
+
+func (h *WalletHandler) legacyWalletOp(c *gin.Context, name, successor string, op legacyWalletOp) {
+	var req models.TransactionForRequest
+
+	c.Header("Deprecation", "true")
+	c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+
+	uidInt, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+	if uidInt == 0 {
+		h.logger.Warn(name + " unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	uid := uint(uidInt)
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn(name+" bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = models.DefaultCurrency
+	}
+
+	key, err := idempotencyKey(c)
+	if err != nil {
+		h.logger.Warn(name+" bad request", "user_id", uid, "err", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Warn("deprecated "+name+" called", "user_id", uid, "amount", req.Amount)
+
+	wallet, transaction, err := op(uid, req.Amount, req.Currency, req.Description, key)
+	if err != nil {
+		h.logger.Error(name+" failed", "user_id", uid, "err", err.Error())
+		c.JSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info(name+" success", "user_id", uid, "transaction_id", transaction.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"wallet":         wallet,
+		"transaction_id": transaction.ID},
+	)
+}
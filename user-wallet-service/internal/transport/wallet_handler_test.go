@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"user-service/internal/utils"
+)
+
+func TestWalletErrorStatus(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{utils.ErrInsufficientAvailableBalance, http.StatusConflict},
+		{utils.ErrInsufficientFrozenBalance, http.StatusConflict},
+		{utils.ErrInsufficientHold, http.StatusConflict},
+		{utils.ErrResultingBalanceNegative, http.StatusConflict},
+		{utils.ErrWalletNotFound, http.StatusNotFound},
+		{utils.ErrWithdrawalNotFound, http.StatusNotFound},
+		{utils.ErrIdempotencyKeyTooLong, http.StatusBadRequest},
+		{fmt.Errorf("withdraw: %w", utils.ErrInsufficientAvailableBalance), http.StatusConflict},
+		{fmt.Errorf("unexpected"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		if got := walletErrorStatus(c.err); got != c.want {
+			t.Errorf("walletErrorStatus(%q) = %d, want %d", c.err, got, c.want)
+		}
+	}
+}
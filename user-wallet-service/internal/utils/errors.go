@@ -8,4 +8,29 @@ var (
 	ErrResultingBalanceNegative     = errors.New("resulting balance negative")
 	ErrInsufficientFrozenBalance    = errors.New("insufficient frozen balance")
 	ErrWalletNotFound               = errors.New("wallet not found")
+	ErrHoldNotFound                 = errors.New("hold not found")
+	ErrHoldNotActive                = errors.New("hold is not active")
+	ErrInsufficientHold             = errors.New("amount exceeds the held amount")
+	ErrCurrencyMismatch             = errors.New("currency does not match the existing hold")
+	ErrSellerIsBuyer                = errors.New("seller cannot be paid out from their own hold")
+	ErrWithdrawalNotFound           = errors.New("withdrawal not found")
+	ErrPaymentIntentNotFound        = errors.New("payment intent not found")
+	ErrInvalidWebhookSignature      = errors.New("invalid webhook signature")
+	ErrInvalidCursor                = errors.New("invalid cursor")
+	ErrInvalidRefreshToken          = errors.New("invalid refresh token")
+	ErrRefreshTokenReused           = errors.New("refresh token was already used, session revoked")
+	ErrInvalidUserToken             = errors.New("invalid or expired token")
+	ErrInvalidPassword              = errors.New("invalid current password")
+	ErrMFARequired                  = errors.New("two factor authentication code required")
+	ErrInvalidMFACode               = errors.New("invalid two factor authentication code")
+	ErrInvalidMFAChallenge          = errors.New("invalid or expired mfa challenge")
+	ErrMFAAlreadyEnabled            = errors.New("two factor authentication is already enabled")
+	ErrMFANotEnabled                = errors.New("two factor authentication is not enabled")
+	ErrMFANotSetUp                  = errors.New("two factor authentication is not set up")
+	ErrMFALocked                    = errors.New("too many invalid two factor authentication codes, try again later")
+	ErrLoginLocked                  = errors.New("too many failed login attempts")
+	ErrInvalidCredentials           = errors.New("invalid credentials")
+	ErrUserNotFound                 = errors.New("user not found")
+	ErrIdempotencyKeyReused         = errors.New("idempotency key was already used for a different operation")
+	ErrIdempotencyKeyTooLong        = errors.New("idempotency key must not exceed 128 characters")
 )